@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// roleAttribute is the certificate attribute holding the role of the submitter, issued by
+// the CA with e.g. `fabric-ca-client register --id.attrs 'role=mechanic:ecert'`
+const roleAttribute = "role"
+
+// Roles granted by the role attribute
+const (
+	roleAdmin    = "admin"
+	roleDealer   = "dealer"
+	roleMechanic = "mechanic"
+)
+
+// submittingClient returns the decoded X.509 ID and the MSP ID of the submitter,
+// e.g. x509::CN=User1@org1.example.com,...::CN=ca.org1.example.com,...
+func submittingClient(ctx contractapi.TransactionContextInterface) (string, string, error) {
+	b64ID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to read clientID: %v", err)
+	}
+	decodeID, err := base64.StdEncoding.DecodeString(b64ID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to base64 decode clientID: %v", err)
+	}
+
+	mspId, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to read MSP ID: %v", err)
+	}
+
+	return string(decodeID), mspId, nil
+}
+
+// requirePeerOrganization fails unless the endorsing peer belongs to the organization, so
+// that transactions writing to the collection of the organization are endorsed by its
+// peers only
+func requirePeerOrganization(mspId string) error {
+	peerMSPID, err := peerOrganization()
+	if err != nil {
+		return err
+	}
+	if peerMSPID != mspId {
+		return newError(codeForbidden, "the transaction must be endorsed by peers of %s, not %s", mspId, peerMSPID)
+	}
+
+	return nil
+}
+
+// peerOrganization returns the MSP ID of the endorsing peer
+func peerOrganization() (string, error) {
+	mspId, err := shim.GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("Failed to read the MSP ID of the peer. %v", err)
+	}
+
+	return mspId, nil
+}
+
+// hasRole returns true when the role attribute of the submitter equals the role
+func hasRole(ctx contractapi.TransactionContextInterface, role string) bool {
+	return ctx.GetClientIdentity().AssertAttributeValue(roleAttribute, role) == nil
+}
+
+// requireRole rejects submitters without one of the roles
+func requireRole(ctx contractapi.TransactionContextInterface, roles ...string) error {
+	for _, role := range roles {
+		if hasRole(ctx, role) {
+			return nil
+		}
+	}
+	if len(roles) == 1 {
+		return newError(codeForbidden, "submitting client not authorized, requires the %s role", roles[0])
+	}
+	return newError(codeForbidden, "submitting client not authorized, requires one of the %s roles", strings.Join(roles, ", "))
+}
+
+// authorizePerson rejects submitters that act for a person without being bound to them.
+// Money moves only on behalf of the person's own identity, admins included.
+func authorizePerson(ctx contractapi.TransactionContextInterface, person *Person) error {
+	if person.ClientId == "" {
+		return newError(codeForbidden, "the person %s is not bound to an identity", person.Id)
+	}
+
+	clientId, mspId, err := submittingClient(ctx)
+	if err != nil {
+		return err
+	}
+	if clientId != person.ClientId || mspId != person.MSPID {
+		return newError(codeForbidden, "submitting client not authorized to act for %s", person.Id)
+	}
+	return nil
+}
+
+// administerPerson is authorizePerson for administrative operations, i.e. creating and
+// deleting records, which admins may do for every person
+func administerPerson(ctx contractapi.TransactionContextInterface, person *Person) error {
+	if hasRole(ctx, roleAdmin) {
+		return nil
+	}
+	return authorizePerson(ctx, person)
+}
+
+// authorizeOwner rejects submitters that are not bound to the owner of the car
+func (s *SmartContract) authorizeOwner(ctx contractapi.TransactionContextInterface, car *Car) error {
+	owner, err := readPerson(ctx, car.OwnerId)
+	if err != nil {
+		return err
+	}
+	return authorizePerson(ctx, owner)
+}
+
+// BindPerson binds the person to an X.509 identity of the MSP. Only admins may bind persons,
+// e.g. the persons created by InitLedger or MigrateKeys. The submitter must be able to read
+// the details and the journal of the person, which move to the collection of the MSP.
+func (s *SmartContract) BindPerson(ctx contractapi.TransactionContextInterface, personId string, clientId string, mspId string) error {
+	err := requireRole(ctx, roleAdmin)
+	if err != nil {
+		return err
+	}
+
+	person, err := s.queryPersonRecord(ctx, personId)
+	if err != nil {
+		return err
+	}
+
+	before := *person
+
+	// the details move to the collection of the new organization
+	if mspId != person.MSPID {
+		err = moveJournal(ctx, person, mspId)
+		if err != nil {
+			return err
+		}
+		err = purgePersonDetails(ctx, person)
+		if err != nil {
+			return err
+		}
+	}
+	person.ClientId = clientId
+	person.MSPID = mspId
+
+	err = putPerson(ctx, person)
+	if err != nil {
+		return err
+	}
+
+	return emitPersonEvent(ctx, personUpdatedEvent, &before, person)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/first-blockchain/golang-blockchain/memstub"
+	"github.com/first-blockchain/golang-blockchain/mocks"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	rousseauClientId = "x509::CN=rousseau,OU=client::CN=ca.org1.example.com"
+	poloClientId     = "x509::CN=polo,OU=client::CN=ca.org1.example.com"
+	mechanicClientId = "x509::CN=mechanic,OU=client::CN=ca.org1.example.com"
+)
+
+// newBoundLedger returns an initialized ledger whose person1 and person2 are bound to
+// the clients of Rousseau and Polo
+func newBoundLedger(t *testing.T) (*mocks.TransactionContext, *memstub.Stub) {
+	transactionContext, stub := newInitializedLedger(t)
+	carContract := SmartContract{}
+
+	err := carContract.BindPerson(transactionContext, "person1", rousseauClientId, "Org1MSP")
+	require.NoError(t, err)
+	err = carContract.BindPerson(transactionContext, "person2", poloClientId, "Org1MSP")
+	require.NoError(t, err)
+
+	return transactionContext, stub
+}
+
+func TestCreatePersonBindsSubmitter(t *testing.T) {
+	transactionContext, _ := newTransactionContext()
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+
+	carContract := SmartContract{}
+	withPersonDetails(t, transactionContext, PersonDetails{Name: "Jean-Jacques", Surname: "Rousseau", Email: "rousseau@gmail.com", Money: NewMoney(100, defaultCurrency)})
+	err := carContract.CreatePerson(transactionContext, "person1")
+	require.NoError(t, err)
+
+	person, err := carContract.QueryPerson(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, rousseauClientId, person.ClientId)
+	require.Equal(t, "Org1MSP", person.MSPID)
+
+	withPersonDetails(t, transactionContext, PersonDetails{Name: "Jean-Jacques", Surname: "Rousseau", Email: "jj@gmail.com"})
+	err = carContract.UpdatePerson(transactionContext, "person1")
+	require.NoError(t, err)
+}
+
+func TestBindPersonRequiresAdmin(t *testing.T) {
+	transactionContext, _ := newInitializedLedger(t)
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, roleDealer))
+
+	carContract := SmartContract{}
+	err := carContract.BindPerson(transactionContext, "person1", rousseauClientId, "Org1MSP")
+	requireContractError(t, err, codeForbidden, "submitting client not authorized, requires the admin role")
+}
+
+func TestChangeOwnerAuthorization(t *testing.T) {
+	transactionContext, _ := newUnboundLedger(t)
+	carContract := SmartContract{}
+
+	// persons created by InitLedger are not bound until an admin binds them
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err := carContract.ChangeOwner(transactionContext, "car1", "person2", true)
+	requireContractError(t, err, codeForbidden, "the person person1 is not bound to an identity")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(adminClientId, roleAdmin))
+	err = carContract.BindPerson(transactionContext, "person1", rousseauClientId, "Org1MSP")
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	err = carContract.ChangeOwner(transactionContext, "car1", "person2", true)
+	requireContractError(t, err, codeForbidden, "submitting client not authorized to act for person1")
+
+	// the same client ID issued by another MSP is a different identity
+	otherMSP := newClientIdentity(rousseauClientId, "")
+	otherMSP.GetMSPIDReturns("Org2MSP", nil)
+	transactionContext.GetClientIdentityReturns(otherMSP)
+	err = carContract.ChangeOwner(transactionContext, "car1", "person2", true)
+	requireContractError(t, err, codeForbidden, "submitting client not authorized to act for person1")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err = carContract.ChangeOwner(transactionContext, "car1", "person2", true)
+	require.NoError(t, err)
+}
+
+func TestCarOwnerAuthorization(t *testing.T) {
+	transactionContext, _ := newBoundLedger(t)
+	carContract := SmartContract{}
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	err := carContract.ChangeCarColour(transactionContext, "car1", "white")
+	requireContractError(t, err, codeForbidden, "submitting client not authorized to act for person1")
+	err = carContract.DeleteCar(transactionContext, "car1")
+	requireContractError(t, err, codeForbidden, "submitting client not authorized to act for person1")
+	err = carContract.CreateCar(transactionContext, "car7", "Audi", "A4", 2020, "grey", "person1", 100, defaultCurrency)
+	requireContractError(t, err, codeForbidden, "submitting client not authorized to act for person1")
+
+	// dealers list cars for any person
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, roleDealer))
+	err = carContract.CreateCar(transactionContext, "car7", "Audi", "A4", 2020, "grey", "person1", 100, defaultCurrency)
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err = carContract.ChangeCarColour(transactionContext, "car1", "white")
+	require.NoError(t, err)
+	err = carContract.DeleteCar(transactionContext, "car7")
+	require.NoError(t, err)
+}
+
+func TestAddMalfunctionRequiresMechanic(t *testing.T) {
+	transactionContext, _ := newInitializedLedger(t)
+	carContract := SmartContract{}
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, roleDealer))
+	err := carContract.AddMalfunction(transactionContext, "car1", "broken mirror", 100)
+	requireContractError(t, err, codeForbidden, "submitting client not authorized, requires one of the mechanic, admin roles")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(mechanicClientId, roleMechanic))
+	err = carContract.AddMalfunction(transactionContext, "car1", "broken mirror", 100)
+	require.NoError(t, err)
+}
+
+func TestAdminMayNotMoveMoneyForPersons(t *testing.T) {
+	transactionContext, _ := newBoundLedger(t)
+	carContract := SmartContract{}
+
+	err := carContract.ChangeOwner(transactionContext, "car1", "person2", true)
+	requireContractError(t, err, codeForbidden, "submitting client not authorized to act for person1")
+	err = carContract.RepairCar(transactionContext, "car1")
+	requireContractError(t, err, codeForbidden, "submitting client not authorized to act for person1")
+	withPersonDetails(t, transactionContext, PersonDetails{Name: "Jean-Jacques", Surname: "Rousseau", Email: "jj@gmail.com"})
+	err = carContract.UpdatePerson(transactionContext, "person1")
+	requireContractError(t, err, codeForbidden, "submitting client not authorized to act for person1")
+
+	// creating and deleting records stays administrative
+	err = carContract.CreateCar(transactionContext, "car7", "Audi", "A4", 2020, "grey", "person1", 100, defaultCurrency)
+	require.NoError(t, err)
+	err = carContract.DeleteCar(transactionContext, "car7")
+	require.NoError(t, err)
+}
+
+func TestQueryPersonHidesDetails(t *testing.T) {
+	transactionContext, _ := newBoundLedger(t)
+	carContract := SmartContract{}
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	person, err := carContract.QueryPerson(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, &Person{Id: "person1", ClientId: rousseauClientId, MSPID: "Org1MSP"}, person)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	person, err = carContract.QueryPerson(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, "rousseau@gmail.com", person.Email)
+	require.Equal(t, NewMoney(890099, defaultCurrency), person.Money)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(adminClientId, roleAdmin))
+	person, err = carContract.QueryPerson(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, "rousseau@gmail.com", person.Email)
+}
@@ -0,0 +1,638 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// A car can be sold in a sealed-bid auction instead of at a fixed price. The auction
+// follows the commit-reveal design of the auction sample: while the auction is open,
+// bidders keep their bids in the collection of their organization with SubmitBid and
+// commit to them with CommitBid, so that the world state holds only their hashes. Once the
+// seller closes the auction, bidders reveal their bids and the seller ends the auction,
+// which transfers the car to the highest bidder who bid at least the reserve price.
+// Bidders escrow the price when they reveal the bid, so that the seller's transaction need
+// not read their balance. Bidders linked to a token account escrow nothing and pay in
+// tokens when they win.
+
+// Status of an auction
+const (
+	auctionOpen   = "open"
+	auctionClosed = "closed"
+	auctionEnded  = "ended"
+)
+
+// bidTransientKey is the transient field holding the AuctionBid of SubmitBid and RevealBid,
+// so that the bid never appears in the transaction arguments
+const bidTransientKey = "bid"
+
+// Auction is the public state of the auction of a car. A car has at most one auction that
+// is not ended.
+type Auction struct {
+	CarId        string
+	SellerId     string
+	ReservePrice Money
+	Status       string
+	SealedBids   map[string]SealedBid
+	RevealedBids map[string]RevealedBid
+	WinnerId     string `json:",omitempty"`
+	Price        *Money `json:",omitempty"`
+	CreatedAt    time.Time
+}
+
+// SealedBid is the hash of a bid and the organization whose collection holds the bid
+type SealedBid struct {
+	MSPID string
+	Hash  string
+}
+
+// RevealedBid is a bid revealed after the auction was closed. Its price stays in escrow
+// until the auction ends, unless the bid is paid InTokens.
+type RevealedBid struct {
+	BidderId string
+	Price    Money
+	InTokens bool `json:",omitempty"`
+}
+
+// AuctionBid is a bid as submitted and revealed by the bidder. The salt keeps others from
+// guessing the price from the hash.
+type AuctionBid struct {
+	CarId    string
+	BidderId string
+	Price    Money
+	Salt     string
+}
+
+// CreateAuction opens an auction of the car with the reserve price in minor units of the
+// car's currency. Bids below the reserve price do not win.
+func (s *SmartContract) CreateAuction(ctx contractapi.TransactionContextInterface, carId string, reservePrice int64) error {
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	err = requireInService(car)
+	if err != nil {
+		return err
+	}
+
+	err = s.authorizeOwner(ctx, car)
+	if err != nil {
+		return err
+	}
+
+	if reservePrice < 0 {
+		return newError(codeInvalidArgument, "the reserve price must not be negative")
+	}
+
+	existing, err := readAuction(ctx, carId)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.Status != auctionEnded {
+		return newError(codeAlreadyExists, "the car %s is already being auctioned", carId)
+	}
+
+	err = requireNotForSale(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	createdAt, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	auction := &Auction{
+		CarId:        carId,
+		SellerId:     car.OwnerId,
+		ReservePrice: NewMoney(reservePrice, car.Price.Currency),
+		Status:       auctionOpen,
+		SealedBids:   map[string]SealedBid{},
+		RevealedBids: map[string]RevealedBid{},
+		CreatedAt:    createdAt,
+	}
+
+	err = putAuction(ctx, auction)
+	if err != nil {
+		return err
+	}
+
+	return emitAuctionEvent(ctx, auctionCreatedEvent, auction, nil)
+}
+
+// SubmitBid keeps the bid passed in the transient field bid in the collection of the bidder's
+// organization and returns its ID. Only peers of that organization endorse it, so that the
+// bid never reaches any other organization; the bidder then commits to the bid with CommitBid.
+func (s *SmartContract) SubmitBid(ctx contractapi.TransactionContextInterface, carId string) (string, error) {
+	auction, err := s.QueryAuction(ctx, carId)
+	if err != nil {
+		return "", err
+	}
+	if auction.Status != auctionOpen {
+		return "", newError(codeInvalidState, "the auction of the car %s is not open", carId)
+	}
+
+	bid, err := transientBid(ctx, auction)
+	if err != nil {
+		return "", err
+	}
+
+	bidder, err := readPerson(ctx, bid.BidderId)
+	if err != nil {
+		return "", err
+	}
+
+	err = authorizePerson(ctx, bidder)
+	if err != nil {
+		return "", err
+	}
+
+	err = requirePeerOrganization(bidder.MSPID)
+	if err != nil {
+		return "", err
+	}
+
+	if bidder.Id == auction.SellerId {
+		return "", newError(codeAlreadyOwner, "the person %s already owns the car %s", bidder.Id, carId)
+	}
+
+	bidAsBytes, err := json.Marshal(bid)
+	if err != nil {
+		return "", err
+	}
+
+	// the transaction ID identifies the bid
+	bidId := ctx.GetStub().GetTxID()
+	key, err := bidKey(ctx, carId, bidId)
+	if err != nil {
+		return "", err
+	}
+
+	err = ctx.GetStub().PutPrivateData(personCollection(bidder.MSPID), key, bidAsBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return bidId, nil
+}
+
+// CommitBid adds the hash of the bid kept by SubmitBid to the open auction. Every peer can
+// read the hash, so any organization may endorse it.
+func (s *SmartContract) CommitBid(ctx contractapi.TransactionContextInterface, carId string, bidderId string, bidId string) error {
+	auction, err := s.QueryAuction(ctx, carId)
+	if err != nil {
+		return err
+	}
+	if auction.Status != auctionOpen {
+		return newError(codeInvalidState, "the auction of the car %s is not open", carId)
+	}
+	if _, ok := auction.SealedBids[bidId]; ok {
+		return newError(codeAlreadyExists, "the bid %s is already committed", bidId)
+	}
+
+	bidder, err := readPerson(ctx, bidderId)
+	if err != nil {
+		return err
+	}
+
+	err = authorizePerson(ctx, bidder)
+	if err != nil {
+		return err
+	}
+
+	key, err := bidKey(ctx, carId, bidId)
+	if err != nil {
+		return err
+	}
+
+	collection := personCollection(bidder.MSPID)
+	hash, err := ctx.GetStub().GetPrivateDataHash(collection, key)
+	if err != nil {
+		return fmt.Errorf("Failed to read from collection %s. %v", collection, err)
+	}
+	if hash == nil {
+		return newError(codeNotFound, "the bid %s does not exist", bidId)
+	}
+
+	auction.SealedBids[bidId] = SealedBid{MSPID: bidder.MSPID, Hash: hex.EncodeToString(hash)}
+
+	err = putAuction(ctx, auction)
+	if err != nil {
+		return err
+	}
+
+	return emitAuctionEvent(ctx, bidSubmittedEvent, auction, nil)
+}
+
+// CloseAuction stops accepting bids, so that the bidders can reveal them
+func (s *SmartContract) CloseAuction(ctx contractapi.TransactionContextInterface, carId string) error {
+	auction, err := s.QueryAuction(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	err = s.authorizeSeller(ctx, auction)
+	if err != nil {
+		return err
+	}
+
+	if auction.Status != auctionOpen {
+		return newError(codeInvalidState, "the auction of the car %s is not open", carId)
+	}
+
+	auction.Status = auctionClosed
+
+	err = putAuction(ctx, auction)
+	if err != nil {
+		return err
+	}
+
+	return emitAuctionEvent(ctx, auctionClosedEvent, auction, nil)
+}
+
+// RevealBid reveals the bid passed in the transient field bid after the auction was closed.
+// The bid is accepted only if its hash equals the hash submitted with the bid ID. The price
+// moves from the bidder's balance into escrow, so peers of the bidder's organization endorse
+// the reveal, unless the bidder is linked to a token account.
+func (s *SmartContract) RevealBid(ctx contractapi.TransactionContextInterface, carId string, bidId string) error {
+	auction, err := s.QueryAuction(ctx, carId)
+	if err != nil {
+		return err
+	}
+	if auction.Status != auctionClosed {
+		return newError(codeInvalidState, "the auction of the car %s is not closed", carId)
+	}
+
+	sealed, ok := auction.SealedBids[bidId]
+	if !ok {
+		return newError(codeNotFound, "the bid %s does not exist", bidId)
+	}
+	if _, ok := auction.RevealedBids[bidId]; ok {
+		return newError(codeAlreadyExists, "the bid %s is already revealed", bidId)
+	}
+
+	bid, err := transientBid(ctx, auction)
+	if err != nil {
+		return err
+	}
+
+	// the bid is compared in the encoding written by SubmitBid, so the order and spacing
+	// of the submitted JSON do not matter
+	bidAsBytes, err := json.Marshal(bid)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(bidAsBytes)
+	if hex.EncodeToString(hash[:]) != sealed.Hash {
+		return newError(codeInvalidArgument, "the bid does not match the sealed bid %s", bidId)
+	}
+
+	bidder, err := readPerson(ctx, bid.BidderId)
+	if err != nil {
+		return err
+	}
+
+	err = authorizePerson(ctx, bidder)
+	if err != nil {
+		return err
+	}
+
+	revealed := RevealedBid{BidderId: bid.BidderId, Price: bid.Price, InTokens: bidder.TokenAccount != ""}
+	if !revealed.InTokens {
+		err = escrowBid(ctx, bidder, bid)
+		if err != nil {
+			return err
+		}
+	}
+
+	auction.RevealedBids[bidId] = revealed
+
+	err = putAuction(ctx, auction)
+	if err != nil {
+		return err
+	}
+
+	return emitAuctionEvent(ctx, bidRevealedEvent, auction, nil)
+}
+
+// EndAuction ends the closed auction. The car goes to the highest revealed bid at or above
+// the reserve price, ties going to the lower bid ID. The seller is paid out of the escrow of
+// the winning bid and the other bids are refunded as payments. Bids paid in tokens are
+// passed over if the bidder no longer holds enough tokens. Bids that were not revealed are
+// ignored. AuctionEnded carries the transferred car.
+func (s *SmartContract) EndAuction(ctx contractapi.TransactionContextInterface, carId string) error {
+	auction, err := s.QueryAuction(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	err = s.authorizeSeller(ctx, auction)
+	if err != nil {
+		return err
+	}
+
+	if auction.Status != auctionClosed {
+		return newError(codeInvalidState, "the auction of the car %s is not closed", carId)
+	}
+
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return err
+	}
+	if car.OwnerId != auction.SellerId {
+		return newError(codeInvalidState, "the car %s no longer belongs to the seller %s", carId, auction.SellerId)
+	}
+
+	auction.Status = auctionEnded
+
+	var sold *Car
+	winningBidId := ""
+	for _, bidId := range rankedBids(auction) {
+		bid := auction.RevealedBids[bidId]
+
+		cmp, err := bid.Price.Cmp(auction.ReservePrice)
+		if err != nil {
+			return err
+		}
+		if cmp < 0 {
+			break
+		}
+
+		if bid.InTokens {
+			bidder, err := readPerson(ctx, bid.BidderId)
+			if err != nil {
+				return err
+			}
+			ok, err := holdsTokens(ctx, bidder, bid.Price)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		err = s.payOutBid(ctx, auction, car, bid)
+		if err != nil {
+			return err
+		}
+
+		price := bid.Price
+		auction.WinnerId = bid.BidderId
+		auction.Price = &price
+		sold = car
+		winningBidId = bidId
+		break
+	}
+
+	err = refundBids(ctx, auction, winningBidId)
+	if err != nil {
+		return err
+	}
+
+	err = putAuction(ctx, auction)
+	if err != nil {
+		return err
+	}
+
+	// AuctionEnded replaces the CarTransferred event of the transfer, so it carries the car
+	return emitAuctionEvent(ctx, auctionEndedEvent, auction, sold)
+}
+
+// QueryAuction returns the current or last auction of the car
+func (s *SmartContract) QueryAuction(ctx contractapi.TransactionContextInterface, carId string) (*Auction, error) {
+	auction, err := readAuction(ctx, carId)
+	if err != nil {
+		return nil, err
+	}
+	if auction == nil {
+		return nil, newError(codeNotFound, "the car %s has not been auctioned", carId)
+	}
+
+	return auction, nil
+}
+
+// authorizeSeller checks that the submitting client acts for the seller of the auction
+func (s *SmartContract) authorizeSeller(ctx contractapi.TransactionContextInterface, auction *Auction) error {
+	seller, err := readPerson(ctx, auction.SellerId)
+	if err != nil {
+		return err
+	}
+
+	return authorizePerson(ctx, seller)
+}
+
+// requireNoAuction fails while the car is being auctioned, so that it cannot be sold to
+// anyone but the winner
+func requireNoAuction(ctx contractapi.TransactionContextInterface, carId string) error {
+	auction, err := readAuction(ctx, carId)
+	if err != nil {
+		return err
+	}
+	if auction != nil && auction.Status != auctionEnded {
+		return newError(codeInvalidState, "the car %s is being auctioned", carId)
+	}
+
+	return nil
+}
+
+// payOutBid pays the seller of the auction out of the escrow of the winning bid, or in the
+// bidder's tokens for bids paid in tokens, and hands the car over to the bidder
+func (s *SmartContract) payOutBid(ctx contractapi.TransactionContextInterface, auction *Auction, car *Car, bid RevealedBid) error {
+	if bid.InTokens {
+		seller, err := readPerson(ctx, auction.SellerId)
+		if err != nil {
+			return err
+		}
+		bidder, err := readPerson(ctx, bid.BidderId)
+		if err != nil {
+			return err
+		}
+
+		err = payTokens(ctx, bidder, seller, bid.Price)
+		if err != nil {
+			return err
+		}
+		return handOverCar(ctx, car, bid.BidderId, bid.Price)
+	}
+
+	seller, err := s.queryPersonRecord(ctx, auction.SellerId)
+	if err != nil {
+		return err
+	}
+
+	err = payOut(ctx, escrowAccount, seller, bid.Price, "sale of "+car.Id)
+	if err != nil {
+		return err
+	}
+
+	err = putPerson(ctx, seller)
+	if err != nil {
+		return err
+	}
+
+	return handOverCar(ctx, car, bid.BidderId, bid.Price)
+}
+
+// escrowBid moves the price of the bid from the balance of the bidder into escrow
+func escrowBid(ctx contractapi.TransactionContextInterface, bidder *Person, bid *AuctionBid) error {
+	err := requirePeerOrganization(bidder.MSPID)
+	if err != nil {
+		return err
+	}
+
+	err = addPersonDetails(ctx, bidder)
+	if err != nil {
+		return err
+	}
+
+	cmp, err := bidder.Money.Cmp(bid.Price)
+	if err != nil {
+		return err
+	}
+	if cmp < 0 {
+		return newError(codeInsufficientFunds, "the balance of %s is too low to bid %s", bidder.Id, bid.Price)
+	}
+
+	err = payIn(ctx, bidder, escrowAccount, bid.Price, "bid for "+bid.CarId)
+	if err != nil {
+		return err
+	}
+
+	return putPerson(ctx, bidder)
+}
+
+// refundBids refunds the revealed bids of the auction except the winning bid as payments,
+// since the bidders may belong to other organizations. A transaction owes a person at most
+// one payment, so the bids of each bidder are refunded together.
+func refundBids(ctx contractapi.TransactionContextInterface, auction *Auction, winningBidId string) error {
+	refunds := map[string]Money{}
+	bidderIds := []string{}
+	for bidId, bid := range auction.RevealedBids {
+		if bidId == winningBidId || bid.InTokens {
+			continue
+		}
+
+		refund, ok := refunds[bid.BidderId]
+		if !ok {
+			refund = NewMoney(0, bid.Price.Currency)
+			bidderIds = append(bidderIds, bid.BidderId)
+		}
+
+		var err error
+		refunds[bid.BidderId], err = refund.Add(bid.Price)
+		if err != nil {
+			return err
+		}
+	}
+
+	// map iteration order differs between peers, so the payments are written in bidder order
+	sort.Strings(bidderIds)
+	for _, bidderId := range bidderIds {
+		err := owePayment(ctx, bidderId, escrowAccount, refunds[bidderId], "refund of bids for "+auction.CarId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// transientBid reads the bid passed to SubmitBid or RevealBid and checks it against the auction
+func transientBid(ctx contractapi.TransactionContextInterface, auction *Auction) (*AuctionBid, error) {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the transient map. %v", err)
+	}
+
+	bidAsBytes, ok := transient[bidTransientKey]
+	if !ok {
+		return nil, newError(codeInvalidArgument, "the bid must be passed in the transient field %s", bidTransientKey)
+	}
+
+	bid := new(AuctionBid)
+	err = json.Unmarshal(bidAsBytes, bid)
+	if err != nil {
+		return nil, newError(codeInvalidArgument, "the bid is not valid JSON")
+	}
+
+	if bid.CarId != auction.CarId {
+		return nil, newError(codeInvalidArgument, "the bid is not for the car %s", auction.CarId)
+	}
+	if bid.Salt == "" {
+		return nil, newError(codeInvalidArgument, "the bid must have a salt")
+	}
+	if bid.Price.Amount <= 0 {
+		return nil, newError(codeInvalidArgument, "the price must be greater than zero")
+	}
+	if bid.Price.Currency != auction.ReservePrice.Currency {
+		return nil, newError(codeCurrencyMismatch, "currency mismatch: %s and %s", bid.Price.Currency, auction.ReservePrice.Currency)
+	}
+
+	return bid, nil
+}
+
+// rankedBids returns the IDs of the revealed bids from the highest to the lowest price.
+// Map iteration order differs between peers, so ties are broken by the bid ID.
+func rankedBids(auction *Auction) []string {
+	bidIds := make([]string, 0, len(auction.RevealedBids))
+	for bidId := range auction.RevealedBids {
+		bidIds = append(bidIds, bidId)
+	}
+
+	sort.Slice(bidIds, func(i, j int) bool {
+		first, second := auction.RevealedBids[bidIds[i]], auction.RevealedBids[bidIds[j]]
+		if first.Price.Amount != second.Price.Amount {
+			return first.Price.Amount > second.Price.Amount
+		}
+		return bidIds[i] < bidIds[j]
+	})
+
+	return bidIds
+}
+
+// readAuction reads the auction of the car or returns nil if the car has not been auctioned
+func readAuction(ctx contractapi.TransactionContextInterface, carId string) (*Auction, error) {
+	key, err := auctionKey(ctx, carId)
+	if err != nil {
+		return nil, err
+	}
+
+	auctionAsBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+	if auctionAsBytes == nil {
+		return nil, nil
+	}
+
+	auction := new(Auction)
+	err = json.Unmarshal(auctionAsBytes, auction)
+	if err != nil {
+		return nil, err
+	}
+
+	return auction, nil
+}
+
+// putAuction writes the auction to the world state under its typed key
+func putAuction(ctx contractapi.TransactionContextInterface, auction *Auction) error {
+	key, err := auctionKey(ctx, auction.CarId)
+	if err != nil {
+		return err
+	}
+
+	auctionAsBytes, err := json.Marshal(auction)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, auctionAsBytes)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/first-blockchain/golang-blockchain/memstub"
+	"github.com/first-blockchain/golang-blockchain/mocks"
+	"github.com/stretchr/testify/require"
+)
+
+const avogadroClientId = "x509::CN=avogadro,OU=client::CN=ca.org1.example.com"
+
+// withBid passes the bid in the transient field of the next transaction
+func withBid(t *testing.T, transactionContext *mocks.TransactionContext, bid AuctionBid) {
+	bidAsBytes, err := json.Marshal(bid)
+	require.NoError(t, err)
+	transactionContext.GetStub().(*memstub.Stub).SetTransient(map[string][]byte{bidTransientKey: bidAsBytes})
+}
+
+func TestAuction(t *testing.T) {
+	transactionContext, stub := newBoundLedger(t)
+	carContract := SmartContract{}
+	started := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	err := carContract.BindPerson(transactionContext, "person3", avogadroClientId, "Org1MSP")
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	stub.StartTx("tx2", started)
+	err = carContract.CreateAuction(transactionContext, "car1", 12000)
+	require.NoError(t, err)
+	require.Equal(t, "AuctionCreated", stub.Event().EventName)
+
+	err = carContract.CreateAuction(transactionContext, "car1", 12000)
+	requireContractError(t, err, codeAlreadyExists, "the car car1 is already being auctioned")
+
+	// the car cannot be sold to anyone but the winner
+	err = carContract.ChangeOwner(transactionContext, "car1", "person2", true)
+	requireContractError(t, err, codeInvalidState, "the car car1 is being auctioned")
+	err = carContract.ListCarForSale(transactionContext, "car1", 15000)
+	requireContractError(t, err, codeInvalidState, "the car car1 is being auctioned")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	poloBid := AuctionBid{CarId: "car1", BidderId: "person2", Price: NewMoney(15000, defaultCurrency), Salt: "c1f7"}
+	withBid(t, transactionContext, AuctionBid{CarId: "car1", BidderId: "person1", Price: NewMoney(15000, defaultCurrency), Salt: "c1f7"})
+	_, err = carContract.SubmitBid(transactionContext, "car1")
+	requireContractError(t, err, codeForbidden, "submitting client not authorized to act for person1")
+
+	// peers of other organizations do not endorse the bid
+	onPeerOf(t, "Org2MSP")
+	withBid(t, transactionContext, poloBid)
+	_, err = carContract.SubmitBid(transactionContext, "car1")
+	requireContractError(t, err, codeForbidden, "the transaction must be endorsed by peers of Org1MSP, not Org2MSP")
+
+	onPeerOf(t, "Org1MSP")
+	stub.StartTx("tx3", started.Add(time.Minute))
+	withBid(t, transactionContext, poloBid)
+	poloBidId, err := carContract.SubmitBid(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, "tx3", poloBidId)
+	require.Nil(t, stub.Event())
+
+	// any peer commits to the bid, which changes only the world state
+	onPeerOf(t, "Org2MSP")
+	stub.StartTx("tx4", started.Add(time.Minute))
+	err = carContract.CommitBid(transactionContext, "car1", "person2", "tx0")
+	requireContractError(t, err, codeNotFound, "the bid tx0 does not exist")
+	err = carContract.CommitBid(transactionContext, "car1", "person2", poloBidId)
+	require.NoError(t, err)
+	require.Equal(t, "BidSubmitted", stub.Event().EventName)
+	// the price stays in the collection of the bidder until the bid is revealed
+	require.NotContains(t, string(stub.Event().Payload), "15000")
+	err = carContract.CommitBid(transactionContext, "car1", "person2", poloBidId)
+	requireContractError(t, err, codeAlreadyExists, "the bid tx3 is already committed")
+	onPeerOf(t, "Org1MSP")
+
+	key, err := bidKey(transactionContext, "car1", poloBidId)
+	require.NoError(t, err)
+	private, err := stub.GetPrivateData(org1Collection, key)
+	require.NoError(t, err)
+	require.Contains(t, string(private), `"Salt":"c1f7"`)
+
+	// Avogadro outbids Polo but cannot pay the bid
+	transactionContext.GetClientIdentityReturns(newClientIdentity(avogadroClientId, ""))
+	stub.StartTx("tx5", started.Add(2*time.Minute))
+	avogadroBid := AuctionBid{CarId: "car1", BidderId: "person3", Price: NewMoney(400000, defaultCurrency), Salt: "9ab2"}
+	withBid(t, transactionContext, avogadroBid)
+	avogadroBidId, err := carContract.SubmitBid(transactionContext, "car1")
+	require.NoError(t, err)
+	err = carContract.CommitBid(transactionContext, "car1", "person3", avogadroBidId)
+	require.NoError(t, err)
+
+	err = carContract.RevealBid(transactionContext, "car1", avogadroBidId)
+	requireContractError(t, err, codeInvalidState, "the auction of the car car1 is not closed")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	stub.StartTx("tx6", started.Add(time.Hour))
+	err = carContract.CloseAuction(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, "AuctionClosed", stub.Event().EventName)
+
+	withBid(t, transactionContext, poloBid)
+	_, err = carContract.SubmitBid(transactionContext, "car1")
+	requireContractError(t, err, codeInvalidState, "the auction of the car car1 is not open")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	stub.StartTx("tx7", started.Add(time.Hour+time.Minute))
+	withBid(t, transactionContext, AuctionBid{CarId: "car1", BidderId: "person2", Price: NewMoney(150000, defaultCurrency), Salt: "c1f7"})
+	err = carContract.RevealBid(transactionContext, "car1", poloBidId)
+	requireContractError(t, err, codeInvalidArgument, "the bid does not match the sealed bid tx3")
+
+	// the order of the fields does not matter
+	stub.SetTransient(map[string][]byte{bidTransientKey: []byte(
+		`{"Salt":"c1f7","Price":{"Currency":"EUR","Amount":15000},"BidderId":"person2","CarId":"car1"}`)})
+	err = carContract.RevealBid(transactionContext, "car1", poloBidId)
+	require.NoError(t, err)
+	require.Equal(t, "BidRevealed", stub.Event().EventName)
+
+	// the revealed bid is escrowed
+	polo, err := carContract.QueryPerson(transactionContext, "person2")
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(308033, defaultCurrency), polo.Money)
+
+	// Avogadro cannot escrow the bid, which is therefore never revealed
+	transactionContext.GetClientIdentityReturns(newClientIdentity(avogadroClientId, ""))
+	stub.StartTx("tx8", started.Add(time.Hour+2*time.Minute))
+	withBid(t, transactionContext, avogadroBid)
+	err = carContract.RevealBid(transactionContext, "car1", avogadroBidId)
+	requireContractError(t, err, codeInsufficientFunds, "the balance of person3 is too low to bid 4000.00 EUR")
+
+	// only the seller ends the auction
+	err = carContract.EndAuction(transactionContext, "car1")
+	requireContractError(t, err, codeForbidden, "submitting client not authorized to act for person1")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	stub.StartTx("tx9", started.Add(2*time.Hour))
+	err = carContract.EndAuction(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, "AuctionEnded", stub.Event().EventName)
+
+	var event AuctionEvent
+	err = json.Unmarshal(stub.Event().Payload, &event)
+	require.NoError(t, err)
+	require.Equal(t, "person2", event.Auction.WinnerId)
+	require.Equal(t, "person2", event.Car.OwnerId)
+	require.NotContains(t, string(stub.Event().Payload), "Money")
+
+	auction, err := carContract.QueryAuction(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, auctionEnded, auction.Status)
+	require.Equal(t, "person2", auction.WinnerId)
+	require.Equal(t, NewMoney(15000, defaultCurrency), *auction.Price)
+
+	car, err := carContract.QueryCar(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, "person2", car.OwnerId)
+	require.Equal(t, &CarTransfer{Price: NewMoney(15000, defaultCurrency), At: started.Add(2 * time.Hour)}, car.LastTransfer)
+
+	requireBalance(t, transactionContext, "person2", 308033)
+	requireBalance(t, transactionContext, "person1", 905099)
+
+	// the winning bid is not refunded
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	payments, err := carContract.QueryPayments(transactionContext, "person2")
+	require.NoError(t, err)
+	require.Empty(t, payments)
+}
+
+func TestAuctionWithoutWinner(t *testing.T) {
+	transactionContext, stub := newBoundLedger(t)
+	carContract := SmartContract{}
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err := carContract.CreateAuction(transactionContext, "car1", 12000)
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	stub.StartTx("tx2", time.Now())
+	bid := AuctionBid{CarId: "car1", BidderId: "person2", Price: NewMoney(11000, defaultCurrency), Salt: "5e3d"}
+	withBid(t, transactionContext, bid)
+	bidId, err := carContract.SubmitBid(transactionContext, "car1")
+	require.NoError(t, err)
+	err = carContract.CommitBid(transactionContext, "car1", "person2", bidId)
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err = carContract.CloseAuction(transactionContext, "car1")
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	withBid(t, transactionContext, bid)
+	err = carContract.RevealBid(transactionContext, "car1", bidId)
+	require.NoError(t, err)
+
+	// the only bid is below the reserve price
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	stub.StartTx("tx3", time.Now())
+	err = carContract.EndAuction(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, "AuctionEnded", stub.Event().EventName)
+	require.NotContains(t, string(stub.Event().Payload), `"Car"`)
+
+	// the escrowed bid is refunded as a payment
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	payments, err := carContract.QueryPayments(transactionContext, "person2")
+	require.NoError(t, err)
+	require.Len(t, payments, 1)
+	require.Equal(t, NewMoney(11000, defaultCurrency), payments[0].Amount)
+	require.Equal(t, "refund of bids for car1", payments[0].Memo)
+
+	collected, err := carContract.CollectPayments(transactionContext, "person2")
+	require.NoError(t, err)
+	require.Equal(t, 1, collected)
+
+	polo, err := carContract.QueryPerson(transactionContext, "person2")
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(323033, defaultCurrency), polo.Money)
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+
+	car, err := carContract.QueryCar(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, "person1", car.OwnerId)
+
+	auction, err := carContract.QueryAuction(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Empty(t, auction.WinnerId)
+
+	// an ended auction does not block a new one
+	err = carContract.CreateAuction(transactionContext, "car1", 10000)
+	require.NoError(t, err)
+}
+
+func TestListedCarIsNotAuctioned(t *testing.T) {
+	transactionContext, _ := newBoundLedger(t)
+	carContract := SmartContract{}
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err := carContract.ListCarForSale(transactionContext, "car1", 15000)
+	require.NoError(t, err)
+
+	err = carContract.CreateAuction(transactionContext, "car1", 12000)
+	requireContractError(t, err, codeInvalidState, "the car car1 is listed for sale")
+
+	err = carContract.CancelSale(transactionContext, "car1")
+	require.NoError(t, err)
+	err = carContract.CreateAuction(transactionContext, "car1", 12000)
+	require.NoError(t, err)
+}
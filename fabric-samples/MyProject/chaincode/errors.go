@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ErrorCode identifies why a transaction was rejected. Clients map it to a response,
+// e.g. NOT_FOUND to 404, instead of parsing the message.
+type ErrorCode string
+
+const (
+	codeNotFound          ErrorCode = "NOT_FOUND"
+	codeAlreadyExists     ErrorCode = "ALREADY_EXISTS"
+	codeAlreadyOwner      ErrorCode = "ALREADY_OWNER"
+	codeHasMalfunctions   ErrorCode = "HAS_MALFUNCTIONS"
+	codeNoMalfunctions    ErrorCode = "NO_MALFUNCTIONS"
+	codeInsufficientFunds ErrorCode = "INSUFFICIENT_FUNDS"
+	codeOwnsCars          ErrorCode = "OWNS_CARS"
+	codeCurrencyMismatch  ErrorCode = "CURRENCY_MISMATCH"
+	codeAmountOverflow    ErrorCode = "AMOUNT_OVERFLOW"
+	codeForbidden         ErrorCode = "FORBIDDEN"
+	codeInvalidArgument   ErrorCode = "INVALID_ARGUMENT"
+	codeOfferExpired      ErrorCode = "OFFER_EXPIRED"
+	codePriceMismatch     ErrorCode = "PRICE_MISMATCH"
+	codeHashMismatch      ErrorCode = "HASH_MISMATCH"
+	codeInvalidState      ErrorCode = "INVALID_STATE"
+)
+
+// ContractError is an error the caller can act on. Its message is a JSON envelope, e.g.
+// {"Code":"NOT_FOUND","Message":"car1 does not exist"}, because the message is the only
+// part of an error that reaches the client. Errors of the world state itself stay plain.
+type ContractError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (e *ContractError) Error() string {
+	envelope, err := json.Marshal(e)
+	if err != nil {
+		return e.Message
+	}
+	return string(envelope)
+}
+
+// newError returns a ContractError with a formatted message
+func newError(code ErrorCode, format string, args ...interface{}) error {
+	return &ContractError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestContractErrorEnvelope(t *testing.T) {
+	transactionContext, _ := newInitializedLedger(t)
+
+	carContract := SmartContract{}
+	err := carContract.ChangeOwner(transactionContext, "car6", "person3", false)
+	require.EqualError(t, err, `{"Code":"ALREADY_OWNER","Message":"This person already owns this car!"}`)
+
+	_, err = carContract.QueryCar(transactionContext, "car9")
+	envelope := ContractError{}
+	require.NoError(t, json.Unmarshal([]byte(err.Error()), &envelope))
+	require.Equal(t, ContractError{Code: codeNotFound, Message: "car9 does not exist"}, envelope)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Names of the chaincode events. Fabric keeps a single event per transaction, so every
+// transaction that changes the world state emits exactly one of them. Events are delivered
+// to every member of the channel, so they never carry balances or personal details.
+//
+// Car events carry a CarEvent payload:
+//
+//	CarCreated          CreateCar; Before is empty
+//	CarUpdated          UpdateCar
+//	CarTransferred      ChangeOwner, ChangeOwnerAtValuation and TransferCar
+//	CarRecoloured       ChangeCarColour
+//	MalfunctionAdded    AddMalfunction or AddAssessedMalfunction while the car is still
+//	                    worth repairing
+//	MalfunctionReported ReportMalfunction
+//	MalfunctionQuoted   QuoteMalfunction while the car is still worth repairing
+//	RepairOrdered       RepairMalfunctions
+//	MalfunctionFixed    CompleteRepair
+//	CarRepaired         RepairCar
+//	CarScrapped         AddMalfunction, AddAssessedMalfunction or QuoteMalfunction once the
+//	                    unpaid repairs exceed the price
+//	CarDeleted          DeleteCar; After is empty
+//
+// Person events carry a PersonEvent payload with the public part of the person:
+//
+//	PersonCreated     CreatePerson; Before is empty
+//	PersonUpdated     UpdatePerson, BindPerson and LinkTokenAccount
+//	PersonDeleted     DeletePerson; After is empty
+//	PaymentsCollected CollectPayments
+//	MoneyDeposited    Deposit
+//	MoneyWithdrawn    Withdraw
+//
+// Sale events carry a SaleEvent payload:
+//
+//	CarListed        ListCarForSale; Offer is empty
+//	SaleCancelled    CancelSale; Offer is empty
+//	OfferMade        MakeOffer
+//	OfferCancelled   CancelOffer; Listing is empty
+//
+// Auction events carry an AuctionEvent payload with the public state of the auction:
+//
+//	AuctionCreated   CreateAuction
+//	BidSubmitted     CommitBid
+//	AuctionClosed    CloseAuction
+//	BidRevealed      RevealBid
+//	AuctionEnded     EndAuction; Car is the car transferred to the winner and empty without
+//	                 a winner
+//
+// TokenSettlementSet is emitted by SetTokenSettlement and carries the TokenSettlement.
+const (
+	carCreatedEvent          = "CarCreated"
+	carUpdatedEvent          = "CarUpdated"
+	carTransferredEvent      = "CarTransferred"
+	carRecolouredEvent       = "CarRecoloured"
+	malfunctionAddedEvent    = "MalfunctionAdded"
+	malfunctionReportedEvent = "MalfunctionReported"
+	malfunctionQuotedEvent   = "MalfunctionQuoted"
+	repairOrderedEvent       = "RepairOrdered"
+	malfunctionFixedEvent    = "MalfunctionFixed"
+	carRepairedEvent         = "CarRepaired"
+	carScrappedEvent         = "CarScrapped"
+	carDeletedEvent          = "CarDeleted"
+	personCreatedEvent       = "PersonCreated"
+	personUpdatedEvent       = "PersonUpdated"
+	personDeletedEvent       = "PersonDeleted"
+	paymentsCollectedEvent   = "PaymentsCollected"
+	moneyDepositedEvent      = "MoneyDeposited"
+	moneyWithdrawnEvent      = "MoneyWithdrawn"
+	carListedEvent           = "CarListed"
+	saleCancelledEvent       = "SaleCancelled"
+	offerMadeEvent           = "OfferMade"
+	offerCancelledEvent      = "OfferCancelled"
+	auctionCreatedEvent      = "AuctionCreated"
+	bidSubmittedEvent        = "BidSubmitted"
+	auctionClosedEvent       = "AuctionClosed"
+	bidRevealedEvent         = "BidRevealed"
+	auctionEndedEvent        = "AuctionEnded"
+	tokenSettlementSetEvent  = "TokenSettlementSet"
+)
+
+// CarEvent is the payload of car events. Before and After are the car as it was
+// before and after the transaction.
+type CarEvent struct {
+	CarId     string
+	Timestamp time.Time
+	Before    *Car `json:",omitempty"`
+	After     *Car `json:",omitempty"`
+}
+
+// PersonEvent is the payload of person events
+type PersonEvent struct {
+	PersonId  string
+	Timestamp time.Time
+	Before    *Person `json:",omitempty"`
+	After     *Person `json:",omitempty"`
+}
+
+// SaleEvent is the payload of sale events
+type SaleEvent struct {
+	CarId     string
+	Timestamp time.Time
+	Listing   *SaleListing `json:",omitempty"`
+	Offer     *Offer       `json:",omitempty"`
+}
+
+// AuctionEvent is the payload of auction events
+type AuctionEvent struct {
+	CarId     string
+	Timestamp time.Time
+	Auction   *Auction
+	Car       *Car `json:",omitempty"`
+}
+
+// emitCarEvent sets the event of the transaction. before or after is nil when the
+// car is created or removed.
+func emitCarEvent(ctx contractapi.TransactionContextInterface, name string, before *Car, after *Car) error {
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	event := CarEvent{Timestamp: timestamp, Before: before, After: after}
+	if after != nil {
+		event.CarId = after.Id
+	} else {
+		event.CarId = before.Id
+	}
+
+	return emitEvent(ctx, name, event)
+}
+
+// emitPersonEvent sets the event of the transaction. before or after is nil when the
+// person is created or removed.
+func emitPersonEvent(ctx contractapi.TransactionContextInterface, name string, before *Person, after *Person) error {
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	event := PersonEvent{Timestamp: timestamp, Before: publicPerson(before), After: publicPerson(after)}
+	if after != nil {
+		event.PersonId = after.Id
+	} else {
+		event.PersonId = before.Id
+	}
+
+	return emitEvent(ctx, name, event)
+}
+
+// emitSaleEvent sets the event of the transaction. listing or offer is nil when the
+// event concerns only one of them.
+func emitSaleEvent(ctx contractapi.TransactionContextInterface, name string, listing *SaleListing, offer *Offer) error {
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	event := SaleEvent{Timestamp: timestamp, Listing: listing, Offer: offer}
+	if listing != nil {
+		event.CarId = listing.CarId
+	} else {
+		event.CarId = offer.CarId
+	}
+
+	return emitEvent(ctx, name, event)
+}
+
+// emitAuctionEvent sets the event of the transaction. car is nil unless the auction
+// transferred it.
+func emitAuctionEvent(ctx contractapi.TransactionContextInterface, name string, auction *Auction, car *Car) error {
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, name, AuctionEvent{CarId: auction.CarId, Timestamp: timestamp, Auction: auction, Car: car})
+}
+
+func emitEvent(ctx contractapi.TransactionContextInterface, name string, payload interface{}) error {
+	payloadAsBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().SetEvent(name, payloadAsBytes)
+}
+
+// txTime returns the timestamp of the transaction, which is identical on every endorsing peer
+func txTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	err = txTimestamp.CheckValid()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return txTimestamp.AsTime(), nil
+}
+
+// copyCar returns a copy of the car that does not share its malfunction list
+func copyCar(car *Car) *Car {
+	copied := *car
+	copied.MalfunctionList = append([]CarMalfunction{}, car.MalfunctionList...)
+	return &copied
+}
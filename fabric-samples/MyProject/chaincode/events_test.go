@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/first-blockchain/golang-blockchain/memstub"
+	"github.com/stretchr/testify/require"
+)
+
+// carEvent decodes the event set by the current transaction
+func carEvent(t *testing.T, stub *memstub.Stub, name string) CarEvent {
+	require.NotNil(t, stub.Event())
+	require.Equal(t, name, stub.Event().EventName)
+
+	var event CarEvent
+	err := json.Unmarshal(stub.Event().Payload, &event)
+	require.NoError(t, err)
+	return event
+}
+
+func TestCarEvents(t *testing.T) {
+	transactionContext, stub := newInitializedLedger(t)
+	transferred := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	carContract := SmartContract{}
+	stub.StartTx("tx2", transferred)
+	err := carContract.ChangeOwner(transactionContext, "car1", "person2", true)
+	require.NoError(t, err)
+
+	event := carEvent(t, stub, "CarTransferred")
+	require.Equal(t, "car1", event.CarId)
+	require.Equal(t, transferred, event.Timestamp)
+	require.Equal(t, "person1", event.Before.OwnerId)
+	require.Equal(t, "person2", event.After.OwnerId)
+	// balances are private, events reach every member of the channel
+	require.NotContains(t, string(stub.Event().Payload), "Money")
+
+	stub.StartTx("tx3", transferred)
+	err = carContract.ChangeCarColour(transactionContext, "car1", "green")
+	require.NoError(t, err)
+
+	event = carEvent(t, stub, "CarRecoloured")
+	require.Equal(t, "blue", event.Before.Colour)
+	require.Equal(t, "green", event.After.Colour)
+
+	stub.StartTx("tx4", transferred)
+	err = carContract.RepairCar(transactionContext, "car1")
+	require.NoError(t, err)
+
+	event = carEvent(t, stub, "CarRepaired")
+	require.Equal(t, malfunctionQuoted, event.Before.MalfunctionList[0].Status)
+	require.Equal(t, malfunctionFixed, event.After.MalfunctionList[0].Status)
+
+	stub.StartTx("tx5", transferred)
+	err = carContract.AddMalfunction(transactionContext, "car1", "Broken Mirror", 3000)
+	require.NoError(t, err)
+
+	event = carEvent(t, stub, "MalfunctionAdded")
+	require.Len(t, event.Before.MalfunctionList, 2)
+	require.Len(t, event.After.MalfunctionList, 3)
+
+	stub.StartTx("tx6", transferred)
+	err = carContract.AddMalfunction(transactionContext, "car1", "Engine Failure", 9000)
+	require.NoError(t, err)
+
+	event = carEvent(t, stub, "CarScrapped")
+	require.Equal(t, "car1", event.CarId)
+	require.Len(t, event.Before.MalfunctionList, 3)
+	require.Len(t, event.After.MalfunctionList, 4)
+	require.Equal(t, carScrapped, event.After.Status)
+
+	stub.StartTx("tx7", transferred)
+	err = carContract.DeleteCar(transactionContext, "car9")
+	require.Error(t, err)
+	require.Nil(t, stub.Event())
+}
+
+func TestPersonEvents(t *testing.T) {
+	transactionContext, stub := newInitializedLedger(t)
+
+	carContract := SmartContract{}
+	withPersonDetails(t, transactionContext, PersonDetails{Name: "Nikola", Surname: "Tesla", Email: "tesla@gmail.com", Money: NewMoney(100, defaultCurrency)})
+	err := carContract.CreatePerson(transactionContext, "person4")
+	require.NoError(t, err)
+	require.Equal(t, "PersonCreated", stub.Event().EventName)
+
+	stub.StartTx("tx2", time.Now())
+	withPersonDetails(t, transactionContext, PersonDetails{Name: "Nikola", Surname: "Tesla", Email: "nikola@gmail.com"})
+	err = carContract.UpdatePerson(transactionContext, "person4")
+	require.NoError(t, err)
+
+	var event PersonEvent
+	err = json.Unmarshal(stub.Event().Payload, &event)
+	require.NoError(t, err)
+	require.Equal(t, "PersonUpdated", stub.Event().EventName)
+	require.Equal(t, "person4", event.PersonId)
+	require.Equal(t, &Person{Id: "person4", ClientId: adminClientId, MSPID: "Org1MSP"}, event.After)
+	require.NotContains(t, string(stub.Event().Payload), "gmail.com")
+
+	stub.StartTx("tx3", time.Now())
+	err = carContract.DeletePerson(transactionContext, "person4")
+	require.NoError(t, err)
+	require.Equal(t, "PersonDeleted", stub.Event().EventName)
+}
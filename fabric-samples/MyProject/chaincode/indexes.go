@@ -0,0 +1,99 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Index entries are normal key/value entries in state. Only the key is needed,
+// so the value is a null character - passing nil would delete the key.
+var indexValue = []byte{0x00}
+
+func colourOwnerIndexKey(ctx contractapi.TransactionContextInterface, car *Car) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(colourOwnerIndex, []string{car.Colour, car.OwnerId, car.Id})
+}
+
+func ownerIndexKey(ctx contractapi.TransactionContextInterface, car *Car) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(ownerIndex, []string{car.OwnerId, car.Id})
+}
+
+// putCarIndexes writes every index entry of the car
+func putCarIndexes(ctx contractapi.TransactionContextInterface, car *Car) error {
+	colourOwnerKey, err := colourOwnerIndexKey(ctx, car)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(colourOwnerKey, indexValue)
+	if err != nil {
+		return err
+	}
+
+	ownerKey, err := ownerIndexKey(ctx, car)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(ownerKey, indexValue)
+}
+
+// deleteCarIndexes removes every index entry of the car
+func deleteCarIndexes(ctx contractapi.TransactionContextInterface, car *Car) error {
+	colourOwnerKey, err := colourOwnerIndexKey(ctx, car)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().DelState(colourOwnerKey)
+	if err != nil {
+		return err
+	}
+
+	ownerKey, err := ownerIndexKey(ctx, car)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(ownerKey)
+}
+
+// updateCarIndexes moves the index entries of a car from its old colour and owner
+// to the current ones. Entries whose attributes did not change are left untouched.
+func updateCarIndexes(ctx contractapi.TransactionContextInterface, old *Car, car *Car) error {
+	if old.Colour != car.Colour || old.OwnerId != car.OwnerId {
+		oldKey, err := colourOwnerIndexKey(ctx, old)
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().DelState(oldKey)
+		if err != nil {
+			return err
+		}
+
+		newKey, err := colourOwnerIndexKey(ctx, car)
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().PutState(newKey, indexValue)
+		if err != nil {
+			return err
+		}
+	}
+
+	if old.OwnerId != car.OwnerId {
+		oldKey, err := ownerIndexKey(ctx, old)
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().DelState(oldKey)
+		if err != nil {
+			return err
+		}
+
+		newKey, err := ownerIndexKey(ctx, car)
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().PutState(newKey, indexValue)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,631 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// Every change of a balance is recorded in a double-entry journal. A posting moves an amount
+// from its debit to its credit account, each of which is either a person or one of the
+// external accounts below, and is written as two legs under the same transaction: one
+// debiting the debit account and one crediting the credit account. Both legs are stored in
+// the collection of every person taking part, so that the amounts stay as private as the
+// balances themselves, and the external accounts have a balance in every collection.
+const (
+	// cashAccount is where deposits and opening balances come from and withdrawals go to
+	cashAccount = "@cash"
+	// escrowAccount holds the price of the offers and revealed bids until they pay for the
+	// car or are refunded
+	escrowAccount = "@escrow"
+	// repairAccount receives the repair prices paid by owners
+	repairAccount = "@repairs"
+	// scrapAccount pays the scrap value of scrapped cars
+	scrapAccount = "@scrap"
+)
+
+// externalAccounts are the accounts that do not belong to a person
+var externalAccounts = []string{cashAccount, escrowAccount, repairAccount, scrapAccount}
+
+const (
+	debitSide  = "debit"
+	creditSide = "credit"
+)
+
+// journalTimeLayout formats the timestamps of journal keys with a fixed width, so that the
+// keys sort in time order
+const journalTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// JournalEntry is one leg of a posting. It debits or credits the Account by the amount,
+// which the posting moves from or to the Contra account. Balance is the balance of a person
+// after the leg; the legs of external accounts have none.
+type JournalEntry struct {
+	TxId      string
+	Timestamp time.Time
+	Account   string
+	Side      string
+	Contra    string
+	Amount    Money
+	Balance   *Money `json:",omitempty"`
+	Memo      string
+}
+
+// Statement lists the journal entries of a person from From up to but excluding To
+type Statement struct {
+	PersonId       string
+	From           time.Time
+	To             time.Time
+	OpeningBalance Money
+	ClosingBalance Money
+	Entries        []JournalEntry
+}
+
+// Reconciliation compares the balance of a person with the sum of the person's journal. The
+// journal is reconciled when both are equal, the balance recorded with every entry adds up
+// and the debits of every transaction of the person equal its credits. Unbalanced lists the
+// transactions whose legs do not.
+type Reconciliation struct {
+	PersonId       string
+	Balance        Money
+	JournalBalance Money
+	Entries        int
+	Unbalanced     []string
+	Reconciled     bool
+}
+
+// AccountBalance is the balance of an external account in one currency, summed over the legs
+// in the collection of an organization
+type AccountBalance struct {
+	Account string
+	Balance Money
+	Entries int
+}
+
+// Deposit pays the amount in minor units of the balance's currency into the balance of the person
+func (s *SmartContract) Deposit(ctx contractapi.TransactionContextInterface, personId string, amount int64) error {
+	if amount <= 0 {
+		return newError(codeInvalidArgument, "the amount must be greater than zero")
+	}
+
+	person, err := s.queryPersonRecord(ctx, personId)
+	if err != nil {
+		return err
+	}
+
+	err = authorizePerson(ctx, person)
+	if err != nil {
+		return err
+	}
+
+	before := *person
+
+	err = payOut(ctx, cashAccount, person, NewMoney(amount, person.Money.Currency), "deposit")
+	if err != nil {
+		return err
+	}
+
+	err = putPerson(ctx, person)
+	if err != nil {
+		return err
+	}
+
+	return emitPersonEvent(ctx, moneyDepositedEvent, &before, person)
+}
+
+// Withdraw pays the amount in minor units of the balance's currency out of the balance of the person
+func (s *SmartContract) Withdraw(ctx contractapi.TransactionContextInterface, personId string, amount int64) error {
+	if amount <= 0 {
+		return newError(codeInvalidArgument, "the amount must be greater than zero")
+	}
+
+	person, err := s.queryPersonRecord(ctx, personId)
+	if err != nil {
+		return err
+	}
+
+	err = authorizePerson(ctx, person)
+	if err != nil {
+		return err
+	}
+
+	price := NewMoney(amount, person.Money.Currency)
+	cmp, err := person.Money.Cmp(price)
+	if err != nil {
+		return err
+	}
+	if cmp < 0 {
+		return newError(codeInsufficientFunds, "the balance of %s is too low to withdraw %s", personId, price)
+	}
+
+	before := *person
+
+	err = payIn(ctx, person, cashAccount, price, "withdrawal")
+	if err != nil {
+		return err
+	}
+
+	err = putPerson(ctx, person)
+	if err != nil {
+		return err
+	}
+
+	return emitPersonEvent(ctx, moneyWithdrawnEvent, &before, person)
+}
+
+// GetStatement returns the journal entries of the person from the RFC 3339 timestamp from up
+// to but excluding to, with the balances before and after them. Only peers of the person's
+// organization hold the journal.
+func (s *SmartContract) GetStatement(ctx contractapi.TransactionContextInterface, personId string, from string, to string) (*Statement, error) {
+	fromTime, err := time.Parse(time.RFC3339, from)
+	if err != nil {
+		return nil, newError(codeInvalidArgument, "from must be an RFC 3339 timestamp")
+	}
+	toTime, err := time.Parse(time.RFC3339, to)
+	if err != nil {
+		return nil, newError(codeInvalidArgument, "to must be an RFC 3339 timestamp")
+	}
+	if !toTime.After(fromTime) {
+		return nil, newError(codeInvalidArgument, "to must be after from")
+	}
+
+	person, err := s.queryPersonRecord(ctx, personId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = authorizePerson(ctx, person)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &Statement{
+		PersonId:       personId,
+		From:           fromTime,
+		To:             toTime,
+		OpeningBalance: NewMoney(0, person.Money.Currency),
+		ClosingBalance: NewMoney(0, person.Money.Currency),
+		Entries:        []JournalEntry{},
+	}
+
+	err = readJournal(ctx, person, func(entry *JournalEntry) (bool, error) {
+		if !entry.Timestamp.Before(toTime) {
+			return false, nil
+		}
+
+		balance, err := statement.ClosingBalance.Add(entry.change())
+		if err != nil {
+			return false, err
+		}
+		statement.ClosingBalance = balance
+
+		if entry.Timestamp.Before(fromTime) {
+			statement.OpeningBalance = balance
+		} else {
+			statement.Entries = append(statement.Entries, *entry)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return statement, nil
+}
+
+// ReconcileBalance checks that the journal of the person sums to the person's balance and
+// that the debits of each of the person's transactions equal its credits. Only peers of the
+// person's organization hold the journal.
+func (s *SmartContract) ReconcileBalance(ctx contractapi.TransactionContextInterface, personId string) (*Reconciliation, error) {
+	person, err := s.queryPersonRecord(ctx, personId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = authorizePerson(ctx, person)
+	if err != nil {
+		return nil, err
+	}
+
+	reconciliation := &Reconciliation{
+		PersonId:       personId,
+		Balance:        person.Money,
+		JournalBalance: NewMoney(0, person.Money.Currency),
+		Unbalanced:     []string{},
+		Reconciled:     true,
+	}
+
+	totals := map[string]*postingTotals{}
+	txIds := []string{}
+	err = readJournal(ctx, person, func(entry *JournalEntry) (bool, error) {
+		balance, err := reconciliation.JournalBalance.Add(entry.change())
+		if err != nil {
+			return false, err
+		}
+		reconciliation.JournalBalance = balance
+		reconciliation.Entries++
+
+		if entry.Balance == nil || *entry.Balance != balance {
+			reconciliation.Reconciled = false
+		}
+
+		// the legs of a transaction are adjacent, as their keys share its timestamp and id
+		if _, ok := totals[entry.TxId]; !ok {
+			totals[entry.TxId] = &postingTotals{}
+			txIds = append(txIds, entry.TxId)
+		}
+		err = totals[entry.TxId].add(entry)
+		if err != nil {
+			return false, err
+		}
+
+		contra, err := readContraEntry(ctx, person.MSPID, entry)
+		if err != nil || contra == nil {
+			return err == nil, err
+		}
+		return true, totals[entry.TxId].add(contra)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, txId := range txIds {
+		if totals[txId].debits != totals[txId].credits {
+			reconciliation.Unbalanced = append(reconciliation.Unbalanced, txId)
+			reconciliation.Reconciled = false
+		}
+	}
+
+	if reconciliation.JournalBalance != reconciliation.Balance {
+		reconciliation.Reconciled = false
+	}
+
+	return reconciliation, nil
+}
+
+// QueryAccountBalances returns the balances of the external accounts per currency. Each
+// organization holds the legs of the postings with its own persons only, so the balances
+// are those of the submitter's organization. A balance is the sum of the account's credits
+// less its debits, e.g. @cash is negative by the money paid in.
+func (s *SmartContract) QueryAccountBalances(ctx contractapi.TransactionContextInterface) ([]*AccountBalance, error) {
+	err := requireRole(ctx, roleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	_, submitterMSPID, err := submittingClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := []*AccountBalance{}
+	for _, account := range externalAccounts {
+		records, err := journalRecords(ctx, personCollection(submitterMSPID), account)
+		if err != nil {
+			return nil, err
+		}
+
+		byCurrency := map[string]*AccountBalance{}
+		currencies := []string{}
+		for _, record := range records {
+			entry := new(JournalEntry)
+			err = json.Unmarshal(record.Value, entry)
+			if err != nil {
+				return nil, err
+			}
+
+			currency := entry.Amount.Currency
+			if _, ok := byCurrency[currency]; !ok {
+				byCurrency[currency] = &AccountBalance{Account: account, Balance: NewMoney(0, currency)}
+				currencies = append(currencies, currency)
+			}
+			balance := byCurrency[currency]
+			balance.Balance, err = balance.Balance.Add(entry.change())
+			if err != nil {
+				return nil, err
+			}
+			balance.Entries++
+		}
+
+		sort.Strings(currencies)
+		for _, currency := range currencies {
+			balances = append(balances, byCurrency[currency])
+		}
+	}
+
+	return balances, nil
+}
+
+// postingTotals sums the debits and credits of the legs of a transaction
+type postingTotals struct {
+	debits  Money
+	credits Money
+}
+
+// add adds the amount of the leg to the total of its side
+func (totals *postingTotals) add(entry *JournalEntry) error {
+	total := &totals.credits
+	if entry.Side == debitSide {
+		total = &totals.debits
+	}
+	if total.Currency == "" {
+		total.Currency = entry.Amount.Currency
+	}
+
+	sum, err := total.Add(entry.Amount)
+	if err != nil {
+		return err
+	}
+	*total = sum
+	return nil
+}
+
+// change returns the amount by which the leg changes the balance of its account
+func (entry *JournalEntry) change() Money {
+	if entry.Side == debitSide {
+		return NewMoney(-entry.Amount.Amount, entry.Amount.Currency)
+	}
+	return entry.Amount
+}
+
+// contraSide returns the side of the other leg of the posting
+func (entry *JournalEntry) contraSide() string {
+	if entry.Side == debitSide {
+		return creditSide
+	}
+	return debitSide
+}
+
+// transferMoney moves the amount from the payer to the payee
+func transferMoney(ctx contractapi.TransactionContextInterface, payer *Person, payee *Person, amount Money, memo string) error {
+	var err error
+	payer.Money, err = payer.Money.Sub(amount)
+	if err != nil {
+		return err
+	}
+	payee.Money, err = payee.Money.Add(amount)
+	if err != nil {
+		return err
+	}
+
+	return postEntry(ctx, payer.Id, payee.Id, amount, memo, payer, payee)
+}
+
+// payIn moves the amount from the person to the external account
+func payIn(ctx contractapi.TransactionContextInterface, person *Person, account string, amount Money, memo string) error {
+	var err error
+	person.Money, err = person.Money.Sub(amount)
+	if err != nil {
+		return err
+	}
+
+	return postEntry(ctx, person.Id, account, amount, memo, person)
+}
+
+// payOut moves the amount from the external account to the person
+func payOut(ctx contractapi.TransactionContextInterface, account string, person *Person, amount Money, memo string) error {
+	var err error
+	person.Money, err = person.Money.Add(amount)
+	if err != nil {
+		return err
+	}
+
+	return postEntry(ctx, account, person.Id, amount, memo, person)
+}
+
+// postEntry records the posting from the debit to the credit account in the journal of each
+// of the persons taking part, whose balances have already been changed. Postings without an
+// amount are not recorded.
+func postEntry(ctx contractapi.TransactionContextInterface, debit string, credit string, amount Money, memo string, persons ...*Person) error {
+	if amount.Amount == 0 {
+		return nil
+	}
+
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	legs := []*JournalEntry{
+		{TxId: ctx.GetStub().GetTxID(), Timestamp: timestamp, Account: debit, Side: debitSide, Contra: credit, Amount: amount, Memo: memo},
+		{TxId: ctx.GetStub().GetTxID(), Timestamp: timestamp, Account: credit, Side: creditSide, Contra: debit, Amount: amount, Memo: memo},
+	}
+	for _, leg := range legs {
+		for _, person := range persons {
+			if person.Id == leg.Account {
+				balance := person.Money
+				leg.Balance = &balance
+			}
+		}
+	}
+
+	for _, person := range persons {
+		for _, leg := range legs {
+			err = putJournalEntry(ctx, person, leg)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// putJournalEntry writes the leg to the collection of the organization of the person
+func putJournalEntry(ctx contractapi.TransactionContextInterface, person *Person, entry *JournalEntry) error {
+	if person.MSPID == "" {
+		return fmt.Errorf("the person %s does not belong to an organization", person.Id)
+	}
+
+	key, err := journalKey(ctx, entry)
+	if err != nil {
+		return err
+	}
+
+	entryAsBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutPrivateData(personCollection(person.MSPID), key, entryAsBytes)
+}
+
+// readContraEntry reads the other leg of the posting from the collection of the organization
+// or returns nil if there is none
+func readContraEntry(ctx contractapi.TransactionContextInterface, mspId string, entry *JournalEntry) (*JournalEntry, error) {
+	key, err := contraKey(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	collection := personCollection(mspId)
+	entryAsBytes, err := ctx.GetStub().GetPrivateData(collection, key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from collection %s. %v", collection, err)
+	}
+	if entryAsBytes == nil {
+		return nil, nil
+	}
+
+	contra := new(JournalEntry)
+	err = json.Unmarshal(entryAsBytes, contra)
+	if err != nil {
+		return nil, err
+	}
+
+	return contra, nil
+}
+
+// readJournal visits the legs of the person in time order until visit returns false
+func readJournal(ctx contractapi.TransactionContextInterface, person *Person, visit func(entry *JournalEntry) (bool, error)) error {
+	if person.MSPID == "" {
+		return nil
+	}
+
+	records, err := journalRecords(ctx, personCollection(person.MSPID), person.Id)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		entry := new(JournalEntry)
+		err = json.Unmarshal(record.Value, entry)
+		if err != nil {
+			return err
+		}
+
+		more, err := visit(entry)
+		if err != nil || !more {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// moveJournal copies the postings of the person to the collection of the organization and
+// purges them from the person's current collection
+func moveJournal(ctx contractapi.TransactionContextInterface, person *Person, mspId string) error {
+	err := readJournal(ctx, person, func(entry *JournalEntry) (bool, error) {
+		moved := &Person{Id: person.Id, MSPID: mspId}
+		err := putJournalEntry(ctx, moved, entry)
+		if err != nil {
+			return false, err
+		}
+
+		contra, err := readContraEntry(ctx, person.MSPID, entry)
+		if err != nil || contra == nil {
+			return err == nil, err
+		}
+		return true, putJournalEntry(ctx, moved, contra)
+	})
+	if err != nil {
+		return err
+	}
+
+	return purgeJournal(ctx, person)
+}
+
+// purgeJournal removes the postings of the person from its collection including their
+// history. Postings with another person of the same organization stay, as they belong to
+// the journal of the other person as well.
+func purgeJournal(ctx contractapi.TransactionContextInterface, person *Person) error {
+	if person.MSPID == "" {
+		return nil
+	}
+
+	collection := personCollection(person.MSPID)
+	return readJournal(ctx, person, func(entry *JournalEntry) (bool, error) {
+		shared, err := sharedPosting(ctx, person.MSPID, entry)
+		if err != nil || shared {
+			return err == nil, err
+		}
+
+		key, err := journalKey(ctx, entry)
+		if err != nil {
+			return false, err
+		}
+		err = ctx.GetStub().PurgePrivateData(collection, key)
+		if err != nil {
+			return false, err
+		}
+
+		key, err = contraKey(ctx, entry)
+		if err != nil {
+			return false, err
+		}
+		err = ctx.GetStub().PurgePrivateData(collection, key)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+}
+
+// sharedPosting reports whether the contra account of the leg is a person of the organization
+func sharedPosting(ctx contractapi.TransactionContextInterface, mspId string, entry *JournalEntry) (bool, error) {
+	if strings.HasPrefix(entry.Contra, "@") {
+		return false, nil
+	}
+
+	key, err := personKey(ctx, entry.Contra)
+	if err != nil {
+		return false, err
+	}
+
+	personAsBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+	if personAsBytes == nil {
+		return false, nil
+	}
+
+	contra := new(Person)
+	_ = json.Unmarshal(personAsBytes, contra)
+	return contra.MSPID == mspId, nil
+}
+
+// journalRecords reads the legs of the account from the collection in time order
+func journalRecords(ctx contractapi.TransactionContextInterface, collection string, account string) ([]*queryresult.KV, error) {
+	iterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(collection, journalObjectType, []string{account})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from collection %s. %v", collection, err)
+	}
+	defer iterator.Close()
+
+	records := []*queryresult.KV{}
+	for iterator.HasNext() {
+		record, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
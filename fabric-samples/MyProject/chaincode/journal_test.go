@@ -0,0 +1,206 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+	transactionContext, stub := newBoundLedger(t)
+	carContract := SmartContract{}
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err := carContract.Deposit(transactionContext, "person1", 0)
+	requireContractError(t, err, codeInvalidArgument, "the amount must be greater than zero")
+
+	stub.StartTx("tx2", time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC))
+	err = carContract.Deposit(transactionContext, "person1", 10000)
+	require.NoError(t, err)
+	require.Equal(t, "MoneyDeposited", stub.Event().EventName)
+	require.NotContains(t, string(stub.Event().Payload), "900099")
+
+	err = carContract.Withdraw(transactionContext, "person1", 900100)
+	requireContractError(t, err, codeInsufficientFunds, "the balance of person1 is too low to withdraw 9001.00 EUR")
+
+	// car1 sells at its price less the unpaid repairs
+	purchased := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	stub.StartTx("tx3", purchased)
+	err = carContract.ChangeOwner(transactionContext, "car1", "person2", true)
+	require.NoError(t, err)
+
+	repaired := time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC)
+	stub.StartTx("tx4", repaired)
+	err = carContract.RepairCar(transactionContext, "car2")
+	require.NoError(t, err)
+
+	stub.StartTx("tx5", time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC))
+	err = carContract.Withdraw(transactionContext, "person1", 500)
+	require.NoError(t, err)
+	require.Equal(t, "MoneyWithdrawn", stub.Event().EventName)
+
+	statement, err := carContract.GetStatement(transactionContext, "person1", "2023-02-15T00:00:00Z", "2023-05-01T00:00:00Z")
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(900099, defaultCurrency), statement.OpeningBalance)
+	require.Equal(t, NewMoney(897099, defaultCurrency), statement.ClosingBalance)
+	purchasedBalance := NewMoney(901099, defaultCurrency)
+	repairedBalance := NewMoney(897099, defaultCurrency)
+	require.Equal(t, []JournalEntry{
+		{TxId: "tx3", Timestamp: purchased, Account: "person1", Side: creditSide, Contra: "person2", Amount: NewMoney(1000, defaultCurrency), Balance: &purchasedBalance, Memo: "purchase of car1"},
+		{TxId: "tx4", Timestamp: repaired, Account: "person1", Side: debitSide, Contra: repairAccount, Amount: NewMoney(4000, defaultCurrency), Balance: &repairedBalance, Memo: "repair of car2"},
+	}, statement.Entries)
+
+	reconciliation, err := carContract.ReconcileBalance(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, &Reconciliation{
+		PersonId:       "person1",
+		Balance:        NewMoney(896599, defaultCurrency),
+		JournalBalance: NewMoney(896599, defaultCurrency),
+		Entries:        5,
+		Unbalanced:     []string{},
+		Reconciled:     true,
+	}, reconciliation)
+
+	// both legs of the purchase are in the collection of the buyer and the seller
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	reconciliation, err = carContract.ReconcileBalance(transactionContext, "person2")
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(322033, defaultCurrency), reconciliation.JournalBalance)
+	require.Equal(t, 2, reconciliation.Entries)
+	require.True(t, reconciliation.Reconciled)
+
+	_, err = carContract.GetStatement(transactionContext, "person1", "2023-01-01T00:00:00Z", "2024-01-01T00:00:00Z")
+	requireContractError(t, err, codeForbidden, "submitting client not authorized to act for person1")
+
+	_, err = carContract.GetStatement(transactionContext, "person2", "2024-01-01T00:00:00Z", "2023-01-01T00:00:00Z")
+	requireContractError(t, err, codeInvalidArgument, "to must be after from")
+
+	// the external accounts balance the persons
+	transactionContext.GetClientIdentityReturns(newClientIdentity(adminClientId, roleAdmin))
+	balances, err := carContract.QueryAccountBalances(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, []*AccountBalance{
+		{Account: cashAccount, Balance: NewMoney(-1555965, defaultCurrency), Entries: 5},
+		{Account: repairAccount, Balance: NewMoney(4000, defaultCurrency), Entries: 1},
+	}, balances)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	_, err = carContract.QueryAccountBalances(transactionContext)
+	requireContractError(t, err, codeForbidden, "submitting client not authorized, requires the admin role")
+}
+
+func TestJournalEscrow(t *testing.T) {
+	transactionContext, stub := newBoundLedger(t)
+	carContract := SmartContract{}
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	stub.StartTx("tx2", time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC))
+	err := carContract.ListCarForSale(transactionContext, "car1", 15000)
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	stub.StartTx("tx3", time.Date(2023, 2, 2, 0, 0, 0, 0, time.UTC))
+	err = carContract.MakeOffer(transactionContext, "car1", "person2", 15000, 3600)
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	stub.StartTx("tx4", time.Date(2023, 2, 3, 0, 0, 0, 0, time.UTC))
+	err = carContract.CancelSale(transactionContext, "car1")
+	require.NoError(t, err)
+
+	// the refund is journaled when the buyer collects it
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	stub.StartTx("tx5", time.Date(2023, 2, 4, 0, 0, 0, 0, time.UTC))
+	_, err = carContract.CollectPayments(transactionContext, "person2")
+	require.NoError(t, err)
+
+	statement, err := carContract.GetStatement(transactionContext, "person2", "2023-02-01T00:00:00Z", "2023-03-01T00:00:00Z")
+	require.NoError(t, err)
+	require.Len(t, statement.Entries, 2)
+	require.Equal(t, "offer for car1", statement.Entries[0].Memo)
+	require.Equal(t, escrowAccount, statement.Entries[0].Contra)
+	require.Equal(t, "refund of the offer for car1", statement.Entries[1].Memo)
+	require.Equal(t, escrowAccount, statement.Entries[1].Contra)
+
+	reconciliation, err := carContract.ReconcileBalance(transactionContext, "person2")
+	require.NoError(t, err)
+	require.Equal(t, 3, reconciliation.Entries)
+	require.True(t, reconciliation.Reconciled)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(adminClientId, roleAdmin))
+	balances, err := carContract.QueryAccountBalances(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, &AccountBalance{Account: escrowAccount, Balance: NewMoney(0, defaultCurrency), Entries: 2}, balances[1])
+}
+
+func TestReconcileBalance(t *testing.T) {
+	transactionContext, stub := newInitializedLedger(t)
+	carContract := SmartContract{}
+
+	// a balance changed without a journal entry does not reconcile
+	person, err := carContract.QueryPerson(transactionContext, "person3")
+	require.NoError(t, err)
+	person.Money = NewMoney(1, defaultCurrency)
+	err = putPerson(transactionContext, person)
+	require.NoError(t, err)
+
+	reconciliation, err := carContract.ReconcileBalance(transactionContext, "person3")
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(333333, defaultCurrency), reconciliation.JournalBalance)
+	require.False(t, reconciliation.Reconciled)
+
+	// persons that were journaled before are left untouched by the migration
+	migrated, err := carContract.MigrateJournal(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 0, migrated)
+
+	err = purgeJournal(transactionContext, person)
+	require.NoError(t, err)
+
+	stub.StartTx("tx2", time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC))
+	migrated, err = carContract.MigrateJournal(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 1, migrated)
+
+	reconciliation, err = carContract.ReconcileBalance(transactionContext, "person3")
+	require.NoError(t, err)
+	require.True(t, reconciliation.Reconciled)
+
+	// a posting missing its other leg does not balance
+	cashLeg, err := journalKey(transactionContext, &JournalEntry{Account: cashAccount, Timestamp: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC), TxId: "tx2", Side: debitSide, Contra: "person3"})
+	require.NoError(t, err)
+	cashLegAsBytes, err := stub.GetPrivateData(personCollection("Org1MSP"), cashLeg)
+	require.NoError(t, err)
+	require.NotNil(t, cashLegAsBytes)
+	err = stub.DelPrivateData(personCollection("Org1MSP"), cashLeg)
+	require.NoError(t, err)
+
+	reconciliation, err = carContract.ReconcileBalance(transactionContext, "person3")
+	require.NoError(t, err)
+	require.Equal(t, []string{"tx2"}, reconciliation.Unbalanced)
+	require.False(t, reconciliation.Reconciled)
+
+	err = stub.PutPrivateData(personCollection("Org1MSP"), cashLeg, cashLegAsBytes)
+	require.NoError(t, err)
+
+	// the journal moves with the person to the collection of another organization
+	err = carContract.BindPerson(transactionContext, "person3", avogadroClientId, "Org2MSP")
+	require.NoError(t, err)
+
+	records, err := journalRecords(transactionContext, personCollection("Org1MSP"), "person3")
+	require.NoError(t, err)
+	require.Empty(t, records)
+	records, err = journalRecords(transactionContext, personCollection("Org1MSP"), cashAccount)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	avogadro := newClientIdentity(avogadroClientId, "")
+	avogadro.GetMSPIDReturns("Org2MSP", nil)
+	transactionContext.GetClientIdentityReturns(avogadro)
+	reconciliation, err = carContract.ReconcileBalance(transactionContext, "person3")
+	require.NoError(t, err)
+	require.Equal(t, 1, reconciliation.Entries)
+	require.Empty(t, reconciliation.Unbalanced)
+	require.True(t, reconciliation.Reconciled)
+}
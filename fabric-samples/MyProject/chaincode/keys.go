@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Every entity is stored under a composite key in its own namespace, e.g. car~car1,
+// so that iterating one object type never returns records of another one.
+const (
+	carObjectType     = "car"
+	personObjectType  = "person"
+	saleObjectType    = "sale"
+	offerObjectType   = "offer"
+	paymentObjectType = "payment"
+	auctionObjectType = "auction"
+	bidObjectType     = "bid"
+	journalObjectType = "journal"
+	settingObjectType = "setting"
+)
+
+func carKey(ctx contractapi.TransactionContextInterface, carId string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(carObjectType, []string{carId})
+}
+
+func personKey(ctx contractapi.TransactionContextInterface, personId string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(personObjectType, []string{personId})
+}
+
+func saleKey(ctx contractapi.TransactionContextInterface, carId string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(saleObjectType, []string{carId})
+}
+
+// offerKey is offer~carId~buyerId, so that the offers for a car are read with one partial key
+func offerKey(ctx contractapi.TransactionContextInterface, carId string, buyerId string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(offerObjectType, []string{carId, buyerId})
+}
+
+// paymentKey is payment~payeeId~txId, so that the payments owed to a person are read with
+// one partial key
+func paymentKey(ctx contractapi.TransactionContextInterface, payeeId string, txId string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(paymentObjectType, []string{payeeId, txId})
+}
+
+func auctionKey(ctx contractapi.TransactionContextInterface, carId string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(auctionObjectType, []string{carId})
+}
+
+// bidKey is bid~carId~bidId, the key of a sealed bid in the collection of the bidder
+func bidKey(ctx contractapi.TransactionContextInterface, carId string, bidId string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(bidObjectType, []string{carId, bidId})
+}
+
+// journalKey is journal~account~timestamp~txId~side~contra, the key of a leg of a posting in
+// the collection of a person taking part. The timestamp sorts the legs of an account by time.
+func journalKey(ctx contractapi.TransactionContextInterface, entry *JournalEntry) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(journalObjectType, []string{entry.Account, entry.Timestamp.UTC().Format(journalTimeLayout), entry.TxId, entry.Side, entry.Contra})
+}
+
+// contraKey is the key of the other leg of the posting of the leg
+func contraKey(ctx contractapi.TransactionContextInterface, entry *JournalEntry) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(journalObjectType, []string{entry.Contra, entry.Timestamp.UTC().Format(journalTimeLayout), entry.TxId, entry.contraSide(), entry.Account})
+}
+
+// tokenSettlementKey is setting~token, the key of the TokenSettlement
+func tokenSettlementKey(ctx contractapi.TransactionContextInterface) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(settingObjectType, []string{"token"})
+}
+
+// putCar writes the car to the world state under its typed key
+func putCar(ctx contractapi.TransactionContextInterface, car *Car) error {
+	key, err := carKey(ctx, car.Id)
+	if err != nil {
+		return err
+	}
+
+	carAsBytes, err := json.Marshal(car)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, carAsBytes)
+}
+
+// deleteCar removes the car, all of its index entries, its sale and its auction from the
+// world state. The offers and revealed bids made for the car are refunded.
+func deleteCar(ctx contractapi.TransactionContextInterface, car *Car) error {
+	key, err := carKey(ctx, car.Id)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return err
+	}
+
+	err = deleteCarIndexes(ctx, car)
+	if err != nil {
+		return err
+	}
+
+	return withdrawCar(ctx, car.Id)
+}
+
+// withdrawCar removes the sale and the auction of the car, if there are any. The offers and
+// the revealed bids of an auction that has not ended are refunded.
+func withdrawCar(ctx contractapi.TransactionContextInterface, carId string) error {
+	_, err := deleteSale(ctx, carId, "")
+	if err != nil {
+		return err
+	}
+
+	auction, err := readAuction(ctx, carId)
+	if err != nil {
+		return err
+	}
+	if auction == nil {
+		return nil
+	}
+	if auction.Status != auctionEnded {
+		err = refundBids(ctx, auction, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	key, err := auctionKey(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(key)
+}
+
+// putPerson writes the public part of the person to the world state under its typed key
+// and the details to the collection of its organization
+func putPerson(ctx contractapi.TransactionContextInterface, person *Person) error {
+	key, err := personKey(ctx, person.Id)
+	if err != nil {
+		return err
+	}
+
+	personAsBytes, err := json.Marshal(personRecord{Id: person.Id, ClientId: person.ClientId, MSPID: person.MSPID, TokenAccount: person.TokenAccount})
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(key, personAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return putPersonDetails(ctx, person)
+}
+
+// deletePerson removes the person from the world state and its details and journal from the
+// collection
+func deletePerson(ctx contractapi.TransactionContextInterface, person *Person) error {
+	key, err := personKey(ctx, person.Id)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return err
+	}
+
+	err = purgeJournal(ctx, person)
+	if err != nil {
+		return err
+	}
+
+	return purgePersonDetails(ctx, person)
+}
@@ -3,19 +3,46 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
+	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
+const (
+	// colourOwnerIndex enables colour-based range queries, e.g. all blue cars of an owner.
+	colourOwnerIndex = "Colour~OwnerId~Id"
+	// ownerIndex enables owner-based range queries, e.g. all cars of person1.
+	ownerIndex = "OwnerId~Id"
+)
+
 type SmartContract struct {
 	contractapi.Contract
 }
 
+// CarMalfunction is an entry of the service history of a car. It is reported by a mechanic,
+// quoted, paid for by the owner when the repair is ordered and kept once it is fixed.
+// Severity is empty when the malfunction was not assessed.
 type CarMalfunction struct {
+	Id          string
 	Description string
-	RepairPrice float32
+	Severity    string `json:",omitempty"`
+	Status      string
+	RepairPrice Money
+	ReportedBy  string `json:",omitempty"`
+	ReportedAt  time.Time
+	FixedAt     *time.Time `json:",omitempty"`
+}
+
+// CarTransfer is the price a car was last sold at and the time of the sale
+type CarTransfer struct {
+	Price Money
+	At    time.Time
 }
 
+// Car is in service while Status is empty. A scrapped car stays in the world state with
+// the time it was scrapped and the scrap value paid to its owner. LastTransfer is empty
+// until the car is sold for the first time.
 type Car struct {
 	Id              string
 	Brand           string
@@ -23,16 +50,33 @@ type Car struct {
 	Year            int
 	Colour          string
 	OwnerId         string
-	Price           float32
+	Price           Money
 	MalfunctionList []CarMalfunction
+	Status          string       `json:",omitempty"`
+	ScrappedAt      *time.Time   `json:",omitempty"`
+	ScrapValue      *Money       `json:",omitempty"`
+	LastTransfer    *CarTransfer `json:",omitempty"`
 }
 
+// Person is bound to the X.509 identity that created it. Transactions acting for
+// the person are authorized against ClientId and MSPID. Name, Surname, Email and Money
+// are the PersonDetails kept in the private data collection of the MSP. A person linked
+// to a TokenAccount pays in tokens for cars bought through ChangeOwner and for RepairCar,
+// see TokenSettlement.
 type Person struct {
-	Id      string
-	Name    string
-	Surname string
-	Email   string
-	Money   float32
+	Id           string
+	Name         string
+	Surname      string
+	Email        string
+	Money        Money
+	ClientId     string
+	MSPID        string
+	TokenAccount string `json:",omitempty"`
+}
+
+// public returns the fields of the person every client may read
+func (p *Person) public() *Person {
+	return &Person{Id: p.Id, ClientId: p.ClientId, MSPID: p.MSPID, TokenAccount: p.TokenAccount}
 }
 
 type QueryResult struct {
@@ -40,70 +84,78 @@ type QueryResult struct {
 	Record *Car
 }
 
+// HistoryQueryResult is a single version of a car returned by GetCarHistory
+type HistoryQueryResult struct {
+	Record    *Car
+	TxId      string
+	Timestamp time.Time
+	IsDelete  bool
+}
+
 func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
 	cars := []Car{
-		{Id: "car1", Brand: "Toyota", Year: 2001, Model: "Prius", Colour: "blue", OwnerId: "person1", Price: 100.00, MalfunctionList: []CarMalfunction{
-			{Description: "Broken Tail/Head Lights", RepairPrice: 40},
-			{Description: "Warning Lights", RepairPrice: 50},
+		{Id: "car1", Brand: "Toyota", Year: 2001, Model: "Prius", Colour: "blue", OwnerId: "person1", Price: NewMoney(10000, defaultCurrency), MalfunctionList: []CarMalfunction{
+			{Id: "m1", Description: "Broken Tail/Head Lights", RepairPrice: NewMoney(4000, defaultCurrency), Status: malfunctionQuoted},
+			{Id: "m2", Description: "Warning Lights", RepairPrice: NewMoney(5000, defaultCurrency), Status: malfunctionQuoted},
 		}},
-		{Id: "car2", Brand: "Ford", Year: 2001, Model: "Mustang", Colour: "red", OwnerId: "person1", Price: 200.00, MalfunctionList: []CarMalfunction{
-			{Description: "Bad Fuel Economy", RepairPrice: 40},
+		{Id: "car2", Brand: "Ford", Year: 2001, Model: "Mustang", Colour: "red", OwnerId: "person1", Price: NewMoney(20000, defaultCurrency), MalfunctionList: []CarMalfunction{
+			{Id: "m1", Description: "Bad Fuel Economy", RepairPrice: NewMoney(4000, defaultCurrency), Status: malfunctionQuoted},
 		}},
-		{Id: "car3", Brand: "Fiat", Year: 2001, Model: "XXL", Colour: "pink", OwnerId: "person1", Price: 300.00, MalfunctionList: []CarMalfunction{
-			{Description: "Flat Tires", RepairPrice: 50},
+		{Id: "car3", Brand: "Fiat", Year: 2001, Model: "XXL", Colour: "pink", OwnerId: "person1", Price: NewMoney(30000, defaultCurrency), MalfunctionList: []CarMalfunction{
+			{Id: "m1", Description: "Flat Tires", RepairPrice: NewMoney(5000, defaultCurrency), Status: malfunctionQuoted},
 		}},
-		{Id: "car4", Brand: "Hyundai", Year: 2001, Model: "Tucson", Colour: "green", OwnerId: "person2", Price: 400.00, MalfunctionList: []CarMalfunction{
-			{Description: "Rusting", RepairPrice: 100},
+		{Id: "car4", Brand: "Hyundai", Year: 2001, Model: "Tucson", Colour: "green", OwnerId: "person2", Price: NewMoney(40000, defaultCurrency), MalfunctionList: []CarMalfunction{
+			{Id: "m1", Description: "Rusting", RepairPrice: NewMoney(10000, defaultCurrency), Status: malfunctionQuoted},
 		}},
-		{Id: "car5", Brand: "Volkswagen", Year: 2001, Model: "Passat", Colour: "yellow", OwnerId: "person3", Price: 500.00, MalfunctionList: []CarMalfunction{
-			{Description: "Bad Brakes", RepairPrice: 10},
-			{Description: "Overheating", RepairPrice: 15},
+		{Id: "car5", Brand: "Volkswagen", Year: 2001, Model: "Passat", Colour: "yellow", OwnerId: "person3", Price: NewMoney(50000, defaultCurrency), MalfunctionList: []CarMalfunction{
+			{Id: "m1", Description: "Bad Brakes", RepairPrice: NewMoney(1000, defaultCurrency), Status: malfunctionQuoted},
+			{Id: "m2", Description: "Overheating", RepairPrice: NewMoney(1500, defaultCurrency), Status: malfunctionQuoted},
 		}},
-		{Id: "car6", Brand: "Tesla", Year: 2001, Model: "S", Colour: "black", OwnerId: "person3", Price: 600.00, MalfunctionList: []CarMalfunction{
-			{Description: "Airbags That Injure", RepairPrice: 20},
+		{Id: "car6", Brand: "Tesla", Year: 2001, Model: "S", Colour: "black", OwnerId: "person3", Price: NewMoney(60000, defaultCurrency), MalfunctionList: []CarMalfunction{
+			{Id: "m1", Description: "Airbags That Injure", RepairPrice: NewMoney(2000, defaultCurrency), Status: malfunctionQuoted},
 		}},
 	}
 	persons := []Person{
-		{Id: "person1", Name: "Jean-Jacques", Surname: "Rousseau", Email: "rousseau@gmail.com", Money: 8900.99},
-		{Id: "person2", Name: "Marco", Surname: "Polo", Email: "polo@gmail.com", Money: 3230.33},
-		{Id: "person3", Name: "Amadeo", Surname: "Avogadro", Email: "avogadro@gmail.com", Money: 3333.33},
+		{Id: "person1", Name: "Jean-Jacques", Surname: "Rousseau", Email: "rousseau@gmail.com", Money: NewMoney(890099, defaultCurrency)},
+		{Id: "person2", Name: "Marco", Surname: "Polo", Email: "polo@gmail.com", Money: NewMoney(323033, defaultCurrency)},
+		{Id: "person3", Name: "Amadeo", Surname: "Avogadro", Email: "avogadro@gmail.com", Money: NewMoney(333333, defaultCurrency)},
 	}
 
 	for _, car := range cars {
-		carAsBytes, _ := json.Marshal(car)
-		err := ctx.GetStub().PutState(car.Id, carAsBytes)
+		err := putCar(ctx, &car)
 
 		if err != nil {
 			return fmt.Errorf("Failed to put to world state. %s", err.Error())
 		}
 
-		//  ==== Index the marble to enable color-based range queries, e.g. return all blue marbles ====
+		//  ==== Index the car to enable colour- and owner-based range queries, e.g. return all blue cars ====
 		//  An 'index' is a normal key/value entry in state.
 		//  The key is a composite key, with the elements that you want to range query on listed first.
-		//  In our case, the composite key is based on indexName=color~name.
-		//  This will enable very efficient state range queries based on composite keys matching indexName=color~*
-		indexName := "Colour~OwnerId~Id"
-		colorOwnerIndexKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{car.Colour, car.OwnerId, car.Id})
+		//  In our case, the composite keys are based on indexName=Colour~OwnerId~Id and indexName=OwnerId~Id.
+		//  This will enable very efficient state range queries based on composite keys matching indexName=colour~*
+		err = putCarIndexes(ctx, &car)
 		if err != nil {
 			return err
 		}
+	}
 
-		value := []byte{0x00}
-		err = ctx.GetStub().PutState(colorOwnerIndexKey, value)
-		if err != nil {
-			return err
-		}
+	// the persons are not bound to a client yet, but their details are kept by the
+	// organization initializing the ledger
+	_, mspId, err := submittingClient(ctx)
+	if err != nil {
+		return err
 	}
 
 	for _, person := range persons {
-		personAsBytes, err := json.Marshal(person)
+		person.MSPID = mspId
+		err := putPerson(ctx, &person)
 		if err != nil {
-			return err
+			return fmt.Errorf("Failed to put persons to world state. %v", err)
 		}
 
-		err = ctx.GetStub().PutState(person.Id, personAsBytes)
+		err = postEntry(ctx, cashAccount, person.Id, person.Money, "opening balance", &person)
 		if err != nil {
-			return fmt.Errorf("Failed to put persons to world state. %v", err)
+			return err
 		}
 	}
 
@@ -112,14 +164,19 @@ func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface)
 
 // QueryCar returns the car stored in the world state with given id
 func (s *SmartContract) QueryCar(ctx contractapi.TransactionContextInterface, carNumber string) (*Car, error) {
-	carAsBytes, err := ctx.GetStub().GetState(carNumber)
+	key, err := carKey(ctx, carNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	carAsBytes, err := ctx.GetStub().GetState(key)
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
 	}
 
 	if carAsBytes == nil {
-		return nil, fmt.Errorf("%s does not exist", carNumber)
+		return nil, newError(codeNotFound, "%s does not exist", carNumber)
 	}
 
 	car := new(Car)
@@ -128,18 +185,60 @@ func (s *SmartContract) QueryCar(ctx contractapi.TransactionContextInterface, ca
 	return car, nil
 }
 
-//QueryPerson returns the person stored in the world state with given id
+// QueryPerson returns the person stored in the world state with given id together with
+// the details from the collection of its organization. Other clients than the person and
+// admins only see the public fields.
 func (s *SmartContract) QueryPerson(ctx contractapi.TransactionContextInterface, personId string) (*Person, error) {
-	personAsBytes, err := ctx.GetStub().GetState(personId)
+	person, err := readPerson(ctx, personId)
+	if err != nil {
+		return nil, err
+	}
+
+	// the collection is not read at all for other clients
+	if administerPerson(ctx, person) != nil {
+		return person.public(), nil
+	}
+
+	err = addPersonDetails(ctx, person)
+	if err != nil {
+		return nil, err
+	}
+	return person, nil
+}
+
+// queryPersonRecord returns the complete person for the checks of the transactions
+func (s *SmartContract) queryPersonRecord(ctx contractapi.TransactionContextInterface, personId string) (*Person, error) {
+	person, err := readPerson(ctx, personId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = addPersonDetails(ctx, person)
+	if err != nil {
+		return nil, err
+	}
+
+	return person, nil
+}
+
+// readPerson returns the person stored in the world state without the details
+func readPerson(ctx contractapi.TransactionContextInterface, personId string) (*Person, error) {
+	key, err := personKey(ctx, personId)
+	if err != nil {
+		return nil, err
+	}
+
+	personAsBytes, err := ctx.GetStub().GetState(key)
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
 	}
 
 	if personAsBytes == nil {
-		return nil, fmt.Errorf("%s does not exist", personId)
+		return nil, newError(codeNotFound, "%s does not exist", personId)
 	}
 
+	// persons written before MigratePrivateData still carry their details in the world state
 	person := new(Person)
 	_ = json.Unmarshal(personAsBytes, person)
 
@@ -148,16 +247,18 @@ func (s *SmartContract) QueryPerson(ctx contractapi.TransactionContextInterface,
 
 // QueryAllCars returns all cars found in world state
 func (s *SmartContract) QueryAllCars(ctx contractapi.TransactionContextInterface) ([]*Car, error) {
-	startKey := ""
-	endKey := ""
-
-	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(carObjectType, []string{})
 
 	if err != nil {
 		return nil, err
 	}
 	defer resultsIterator.Close()
 
+	return carsFromIterator(resultsIterator)
+}
+
+// carsFromIterator decodes every car returned by the iterator
+func carsFromIterator(resultsIterator shim.StateQueryIteratorInterface) ([]*Car, error) {
 	retList := []*Car{}
 
 	for resultsIterator.HasNext() {
@@ -178,17 +279,39 @@ func (s *SmartContract) QueryAllCars(ctx contractapi.TransactionContextInterface
 	return retList, nil
 }
 
+// QueryCarsByColor returns all cars of the given colour
 func (s *SmartContract) QueryCarsByColor(ctx contractapi.TransactionContextInterface, color string) ([]*Car, error) {
-	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey("Colour~OwnerId~Id", []string{color})
+	return s.queryCarsByIndex(ctx, colourOwnerIndex, []string{color})
+}
+
+// QueryCarsByOwner returns all cars owned by the person with given id
+func (s *SmartContract) QueryCarsByOwner(ctx contractapi.TransactionContextInterface, OwnerId string) ([]*Car, error) {
+	return s.queryCarsByIndex(ctx, ownerIndex, []string{OwnerId})
+}
+
+// QueryCarsByColorAndOwner returns all cars of the given colour owned by the person with given id
+func (s *SmartContract) QueryCarsByColorAndOwner(ctx contractapi.TransactionContextInterface, Colour string, OwnerId string) ([]*Car, error) {
+	return s.queryCarsByIndex(ctx, colourOwnerIndex, []string{Colour, OwnerId})
+}
+
+// queryCarsByIndex looks up the cars referenced by index entries matching the partial key.
+// The car id is always the last attribute of an index key.
+func (s *SmartContract) queryCarsByIndex(ctx contractapi.TransactionContextInterface, indexName string, attributes []string) ([]*Car, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(indexName, attributes)
 	if err != nil {
 		return nil, err
 	}
 
 	defer iterator.Close()
 
+	return s.carsFromIndexIterator(ctx, iterator)
+}
+
+// carsFromIndexIterator resolves every index entry returned by the iterator to its car
+func (s *SmartContract) carsFromIndexIterator(ctx contractapi.TransactionContextInterface, iterator shim.StateQueryIteratorInterface) ([]*Car, error) {
 	retList := make([]*Car, 0)
 
-	for i := 0; iterator.HasNext(); i++ {
+	for iterator.HasNext() {
 		responseRange, err := iterator.Next()
 		if err != nil {
 			return nil, err
@@ -199,7 +322,7 @@ func (s *SmartContract) QueryCarsByColor(ctx contractapi.TransactionContextInter
 			return nil, err
 		}
 
-		retCarId := compositeKeyParts[2]
+		retCarId := compositeKeyParts[len(compositeKeyParts)-1]
 
 		car, err := s.QueryCar(ctx, retCarId)
 		if err != nil {
@@ -210,282 +333,630 @@ func (s *SmartContract) QueryCarsByColor(ctx contractapi.TransactionContextInter
 	}
 
 	return retList, nil
-
 }
 
-func (s *SmartContract) QueryCarsByOwner(ctx contractapi.TransactionContextInterface, OwnerId string) ([]*Car, error) {
-	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey("Colour~OwnerId~Id", []string{OwnerId})
+// GetCarHistory returns every version of the car with given id, newest first, including
+// previous owners, colours and malfunction lists. Only versions written under the typed
+// car key are returned, i.e. none from before MigrateKeys was run.
+func (s *SmartContract) GetCarHistory(ctx contractapi.TransactionContextInterface, carId string) ([]HistoryQueryResult, error) {
+	key, err := carKey(ctx, carId)
 	if err != nil {
 		return nil, err
 	}
 
-	defer iterator.Close()
-
-	retList := make([]*Car, 0)
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
 
-	for i := 0; iterator.HasNext(); i++ {
-		responseRange, err := iterator.Next()
+	records := []HistoryQueryResult{}
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
 		if err != nil {
 			return nil, err
 		}
 
-		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(responseRange.Key)
+		car := Car{Id: carId}
+		if len(response.Value) > 0 {
+			err = json.Unmarshal(response.Value, &car)
+			if err != nil {
+				return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+			}
+		}
+
+		err = response.Timestamp.CheckValid()
 		if err != nil {
 			return nil, err
 		}
 
-		carId := compositeKeyParts[2]
+		records = append(records, HistoryQueryResult{
+			Record:    &car,
+			TxId:      response.TxId,
+			Timestamp: response.Timestamp.AsTime(),
+			IsDelete:  response.IsDelete,
+		})
+	}
+
+	return records, nil
+}
 
-		car, err := s.QueryCar(ctx, carId)
+// CreateCar issues a new car without malfunctions to the world state with given details
+func (s *SmartContract) CreateCar(ctx contractapi.TransactionContextInterface, id string, brand string, model string, year int, colour string, ownerId string, price int64, currency string) error {
+	exists, err := s.CarExists(ctx, id)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return newError(codeAlreadyExists, "the car %s already exists", id)
+	}
+
+	ownerExists, err := s.PersonExists(ctx, ownerId)
+	if err != nil {
+		return err
+	}
+	if !ownerExists {
+		return newError(codeNotFound, "the person %s does not exist", ownerId)
+	}
+
+	// dealers and admins list cars for any person, everyone else only for themselves
+	if !hasRole(ctx, roleDealer) {
+		owner, err := readPerson(ctx, ownerId)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		err = administerPerson(ctx, owner)
+		if err != nil {
+			return err
+		}
+	}
 
-		retList = append(retList, car)
+	car := Car{
+		Id:              id,
+		Brand:           brand,
+		Model:           model,
+		Year:            year,
+		Colour:          colour,
+		OwnerId:         ownerId,
+		Price:           NewMoney(price, currency),
+		MalfunctionList: []CarMalfunction{},
 	}
 
-	return retList, nil
+	err = putCar(ctx, &car)
+	if err != nil {
+		return err
+	}
+
+	err = putCarIndexes(ctx, &car)
+	if err != nil {
+		return err
+	}
+
+	return emitCarEvent(ctx, carCreatedEvent, nil, &car)
 }
 
-func (s *SmartContract) QueryCarsByColorAndOwner(ctx contractapi.TransactionContextInterface, Colour string, OwnerId string) ([]*Car, error) {
+// UpdateCar updates the details of an existing car. Ownership and malfunctions are
+// changed only through ChangeOwner, AddMalfunction and RepairCar.
+func (s *SmartContract) UpdateCar(ctx contractapi.TransactionContextInterface, id string, brand string, model string, year int, colour string, price int64, currency string) error {
+	car, err := s.QueryCar(ctx, id)
+	if err != nil {
+		return err
+	}
 
-	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey("Colour~OwnerId~Id", []string{Colour, OwnerId})
+	err = requireInService(car)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	defer iterator.Close()
+	err = s.authorizeOwner(ctx, car)
+	if err != nil {
+		return err
+	}
 
-	retList := make([]*Car, 0)
+	before := copyCar(car)
 
-	for i := 0; iterator.HasNext(); i++ {
-		responseRange, err := iterator.Next()
-		if err != nil {
-			return nil, err
-		}
+	car.Brand = brand
+	car.Model = model
+	car.Year = year
+	car.Colour = colour
+	car.Price = NewMoney(price, currency)
 
-		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(responseRange.Key)
-		if err != nil {
-			return nil, err
-		}
+	err = putCar(ctx, car)
+	if err != nil {
+		return err
+	}
 
-		retCarId := compositeKeyParts[2]
+	err = updateCarIndexes(ctx, before, car)
+	if err != nil {
+		return err
+	}
 
-		car, err := s.QueryCar(ctx, retCarId)
+	return emitCarEvent(ctx, carUpdatedEvent, before, car)
+}
+
+// DeleteCar deletes the car with given id together with its index entries
+func (s *SmartContract) DeleteCar(ctx contractapi.TransactionContextInterface, id string) error {
+	car, err := s.QueryCar(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// admins remove any car, everyone else only their own
+	if !hasRole(ctx, roleAdmin) {
+		err = s.authorizeOwner(ctx, car)
 		if err != nil {
-			return nil, err
+			return err
 		}
+	}
 
-		retList = append(retList, car)
+	err = deleteCar(ctx, car)
+	if err != nil {
+		return err
 	}
 
-	return retList, nil
+	return emitCarEvent(ctx, carDeletedEvent, car, nil)
 }
 
-func (s *SmartContract) ChangeOwner(ctx contractapi.TransactionContextInterface, carId string, newOwnerId string, acceptCarWithMalfunction bool) error {
-	car, err := s.QueryCar(ctx, carId)
+// CarExists returns true when the car with given id exists in world state
+func (s *SmartContract) CarExists(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
+	key, err := carKey(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	carAsBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	return carAsBytes != nil, nil
+}
+
+// CreatePerson issues a new person to the world state. The PersonDetails, including the
+// opening balance, are passed as JSON in the transient field person.
+func (s *SmartContract) CreatePerson(ctx contractapi.TransactionContextInterface, id string) error {
+	details, err := transientPersonDetails(ctx)
 	if err != nil {
 		return err
 	}
+	if details.Money.Currency == "" {
+		return newError(codeInvalidArgument, "the currency of the balance is required")
+	}
 
-	if car.OwnerId == newOwnerId {
-		return fmt.Errorf("This person already owns this car!")
+	exists, err := s.PersonExists(ctx, id)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return newError(codeAlreadyExists, "the person %s already exists", id)
 	}
 
-	price := car.Price
+	clientId, mspId, err := submittingClient(ctx)
+	if err != nil {
+		return err
+	}
 
-	newOwner, err := s.QueryPerson(ctx, newOwnerId)
+	person := Person{
+		Id:       id,
+		Name:     details.Name,
+		Surname:  details.Surname,
+		Email:    details.Email,
+		Money:    details.Money,
+		ClientId: clientId,
+		MSPID:    mspId,
+	}
+
+	err = putPerson(ctx, &person)
 	if err != nil {
 		return err
 	}
 
-	oldOwner, err := s.QueryPerson(ctx, car.OwnerId)
+	err = postEntry(ctx, cashAccount, person.Id, person.Money, "opening balance", &person)
 	if err != nil {
 		return err
 	}
 
-	if !acceptCarWithMalfunction && len(car.MalfunctionList) > 0 {
-		return fmt.Errorf("This car has malfunctions, purchase cannot be made! ")
+	return emitPersonEvent(ctx, personCreatedEvent, nil, &person)
+}
+
+// UpdatePerson updates the personal details of an existing person, passed as JSON in the
+// transient field person. The balance is changed only by deposits, withdrawals, purchases
+// and repairs, each of which is recorded in the journal of the person.
+func (s *SmartContract) UpdatePerson(ctx contractapi.TransactionContextInterface, id string) error {
+	details, err := transientPersonDetails(ctx)
+	if err != nil {
+		return err
 	}
-	if acceptCarWithMalfunction && len(car.MalfunctionList) > 0 {
-		for _, malfunction := range car.MalfunctionList {
-			price -= malfunction.RepairPrice
-		}
+
+	person, err := s.queryPersonRecord(ctx, id)
+	if err != nil {
+		return err
 	}
-	if newOwner.Money < price {
-		return fmt.Errorf("The buyer doesn't have enough money to buy the car! ")
+
+	err = authorizePerson(ctx, person)
+	if err != nil {
+		return err
 	}
 
-	newOwner.Money -= price
-	oldOwner.Money += price
+	before := *person
 
-	car.OwnerId = newOwnerId
+	person.Name = details.Name
+	person.Surname = details.Surname
+	person.Email = details.Email
 
-	carAsBytes, err := json.Marshal(car)
+	err = putPerson(ctx, person)
 	if err != nil {
 		return err
 	}
-	err = ctx.GetStub().PutState(carId, carAsBytes)
+
+	return emitPersonEvent(ctx, personUpdatedEvent, &before, person)
+}
+
+// DeletePerson deletes the person with given id. A person who still owns cars cannot be deleted.
+func (s *SmartContract) DeletePerson(ctx contractapi.TransactionContextInterface, id string) error {
+	person, err := readPerson(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	indexName := "Colour~OwnerId~Id"
-	colorOwnerIdIndexKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{car.Colour, car.OwnerId, car.Id})
+	err = administerPerson(ctx, person)
 	if err != nil {
 		return err
 	}
-	value := []byte{0x00}
-	err = ctx.GetStub().PutState(colorOwnerIdIndexKey, value)
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(ownerIndex, []string{id})
 	if err != nil {
 		return err
 	}
-	oldColorOwnerIDIndexKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{car.Colour, oldOwner.Id, car.Id})
+	defer iterator.Close()
+
+	if iterator.HasNext() {
+		return newError(codeOwnsCars, "the person %s still owns cars", id)
+	}
+
+	err = deletePerson(ctx, person)
+	if err != nil {
+		return err
+	}
+
+	return emitPersonEvent(ctx, personDeletedEvent, person, nil)
+}
+
+// PersonExists returns true when the person with given id exists in world state
+func (s *SmartContract) PersonExists(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
+	key, err := personKey(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	personAsBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	return personAsBytes != nil, nil
+}
+
+// ChangeOwner sells the car at its price on the owner's authority alone. Sales that the
+// buyer agrees to go through ListCarForSale, MakeOffer and TransferCar, and sales at the
+// valuation of the car through ChangeOwnerAtValuation.
+// When the buyer and the seller belong to different organizations, the submitter passes the
+// details of the other organization's person in the transient field details:<id>. The
+// details of both are verified against their hash on the channel before any money moves.
+// A buyer linked to a token account pays the token account of the seller instead, see
+// LinkTokenAccount.
+func (s *SmartContract) ChangeOwner(ctx contractapi.TransactionContextInterface, carId string, newOwnerId string, acceptCarWithMalfunction bool) error {
+	return s.changeOwner(ctx, carId, newOwnerId, acceptCarWithMalfunction, false)
+}
+
+// ChangeOwnerAtValuation sells the car like ChangeOwner, but at its valuation instead of
+// its price. The valuation already accounts for unpaid repairs.
+func (s *SmartContract) ChangeOwnerAtValuation(ctx contractapi.TransactionContextInterface, carId string, newOwnerId string, acceptCarWithMalfunction bool) error {
+	return s.changeOwner(ctx, carId, newOwnerId, acceptCarWithMalfunction, true)
+}
+
+// changeOwner sells the car to the new owner at its price less unpaid repairs, or at its
+// valuation when the seller opted in
+func (s *SmartContract) changeOwner(ctx contractapi.TransactionContextInterface, carId string, newOwnerId string, acceptCarWithMalfunction bool, atValuation bool) error {
+	car, err := s.QueryCar(ctx, carId)
 	if err != nil {
 		return err
 	}
-	err = ctx.GetStub().DelState(oldColorOwnerIDIndexKey)
+
+	err = requireInService(car)
 	if err != nil {
 		return err
 	}
 
-	oldOwnerAsBytes, err := json.Marshal(oldOwner)
+	if car.OwnerId == newOwnerId {
+		return newError(codeAlreadyOwner, "This person already owns this car!")
+	}
+
+	err = requireNoAuction(ctx, carId)
 	if err != nil {
 		return err
 	}
-	err = ctx.GetStub().PutState(oldOwner.Id, oldOwnerAsBytes)
+
+	price := car.Price
+
+	newOwner, err := s.queryPersonRecord(ctx, newOwnerId)
 	if err != nil {
 		return err
 	}
 
-	newOwnerAsBytes, err := json.Marshal(newOwner)
+	oldOwner, err := s.queryPersonRecord(ctx, car.OwnerId)
 	if err != nil {
 		return err
 	}
-	err = ctx.GetStub().PutState(newOwner.Id, newOwnerAsBytes)
+
+	// only the current owner may sell the car
+	err = authorizePerson(ctx, oldOwner)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	hasMalfunctions := hasOpenMalfunctions(car.MalfunctionList)
+	if !acceptCarWithMalfunction && hasMalfunctions {
+		return newError(codeHasMalfunctions, "This car has malfunctions, purchase cannot be made! ")
+	}
+	if atValuation {
+		valuation, err := valueCar(ctx, car)
+		if err != nil {
+			return err
+		}
+		price = valuation.Value
+	} else if hasMalfunctions {
+		repairPrice, err := totalRepairPrice(price.Currency, car.MalfunctionList)
+		if err != nil {
+			return err
+		}
+		price, err = price.Sub(repairPrice)
+		if err != nil {
+			return err
+		}
+	}
+	return transferCar(ctx, car, oldOwner, newOwner, price)
 }
 
-func (s *SmartContract) ChangeCarColour(ctx contractapi.TransactionContextInterface, carNumber string, newColour string) error {
-	car, err := s.QueryCar(ctx, carNumber)
+// transferCar pays the price from the buyer to the seller and hands the car over.
+// A pending sale of the car ends with the transfer: the other offers are refunded and the
+// escrow of the buyer's own offer pays for the car, unless the buyer pays in tokens.
+func transferCar(ctx contractapi.TransactionContextInterface, car *Car, oldOwner *Person, newOwner *Person, price Money) error {
+	offer, err := deleteSale(ctx, car.Id, newOwner.Id)
+	if err != nil {
+		return err
+	}
+	if offer != nil && !offer.InTokens {
+		err = payOut(ctx, escrowAccount, newOwner, offer.Price, "refund of the offer for "+car.Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	// buyers linked to a token account pay in tokens, their balance is left untouched
+	if newOwner.TokenAccount != "" {
+		err = payTokens(ctx, newOwner, oldOwner, price)
+		if err != nil {
+			return err
+		}
+	} else {
+		cmp, err := newOwner.Money.Cmp(price)
+		if err != nil {
+			return err
+		}
+		if cmp < 0 {
+			return newError(codeInsufficientFunds, "The buyer doesn't have enough money to buy the car! ")
+		}
+
+		err = transferMoney(ctx, newOwner, oldOwner, price, "purchase of "+car.Id)
+		if err != nil {
+			return err
+		}
+	}
 
+	err = putPerson(ctx, oldOwner)
 	if err != nil {
 		return err
 	}
 
-	oldColour := car.Colour
-	car.Colour = newColour
+	err = putPerson(ctx, newOwner)
+	if err != nil {
+		return err
+	}
 
-	carAsBytes, _ := json.Marshal(car)
+	return handOverCar(ctx, car, newOwner.Id, price)
+}
 
-	err = ctx.GetStub().PutState(carNumber, carAsBytes)
+// handOverCar makes the person the owner of the car once the car has been paid the price for
+func handOverCar(ctx contractapi.TransactionContextInterface, car *Car, newOwnerId string, price Money) error {
+	transferredAt, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
 
+	before := copyCar(car)
+	car.OwnerId = newOwnerId
+	car.LastTransfer = &CarTransfer{Price: price, At: transferredAt}
+
+	err = putCar(ctx, car)
 	if err != nil {
 		return err
 	}
 
-	//Must change the entry
-	indexName := "Colour~OwnerId~Id"
-	newColorOwnerIndexKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{newColour, car.OwnerId, car.Id})
+	err = updateCarIndexes(ctx, before, car)
 	if err != nil {
 		return err
 	}
 
-	value := []byte{0x00}
-	err = ctx.GetStub().PutState(newColorOwnerIndexKey, value)
+	return emitCarEvent(ctx, carTransferredEvent, before, car)
+}
+
+func (s *SmartContract) ChangeCarColour(ctx contractapi.TransactionContextInterface, carNumber string, newColour string) error {
+	car, err := s.QueryCar(ctx, carNumber)
+
 	if err != nil {
 		return err
 	}
 
-	oldColorOwnerIndexKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{oldColour, car.OwnerId, car.Id})
+	err = requireInService(car)
 	if err != nil {
 		return err
 	}
 
-	err = ctx.GetStub().DelState(oldColorOwnerIndexKey)
+	err = s.authorizeOwner(ctx, car)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	before := copyCar(car)
+	car.Colour = newColour
+
+	err = putCar(ctx, car)
+
+	if err != nil {
+		return err
+	}
+
+	//Must change the entry
+	err = updateCarIndexes(ctx, before, car)
+	if err != nil {
+		return err
+	}
+
+	return emitCarEvent(ctx, carRecolouredEvent, before, car)
+}
+
+// AddMalfunction reports and quotes a malfunction in one step, with a repair price in minor
+// units of the car's currency. A car whose unpaid repairs exceed its price is scrapped.
+func (s *SmartContract) AddMalfunction(ctx contractapi.TransactionContextInterface, carId string, description string, price int64) error {
+	return s.addMalfunction(ctx, carId, description, "", price)
 }
 
-func (s *SmartContract) AddMalfunction(ctx contractapi.TransactionContextInterface, carId string, description string, price float32) error {
+// addMalfunction adds a quoted malfunction with the severity, which is empty if it has not
+// been assessed
+func (s *SmartContract) addMalfunction(ctx contractapi.TransactionContextInterface, carId string, description string, severity string, price int64) error {
+	err := requireRole(ctx, roleMechanic, roleAdmin)
+	if err != nil {
+		return err
+	}
+
+	if price <= 0 {
+		return newError(codeInvalidArgument, "the repair price must be greater than zero")
+	}
+
 	car, err := s.QueryCar(ctx, carId)
 	if err != nil {
 		return err
 	}
 
-	newMalfunction := CarMalfunction{
-		Description: description,
-		RepairPrice: price,
+	err = requireInService(car)
+	if err != nil {
+		return err
+	}
+
+	before := copyCar(car)
+
+	malfunction, err := newMalfunction(ctx, car, description, severity)
+	if err != nil {
+		return err
 	}
+	malfunction.Status = malfunctionQuoted
+	malfunction.RepairPrice = NewMoney(price, car.Price.Currency)
 
-	car.MalfunctionList = append(car.MalfunctionList, newMalfunction)
+	car.MalfunctionList = append(car.MalfunctionList, malfunction)
 
-	totalMalfunctionsPrice := float32(0)
-	for _, malfunction := range car.MalfunctionList {
-		totalMalfunctionsPrice += malfunction.RepairPrice
+	scrapped, err := s.scrapIfUnrepairable(ctx, before, car)
+	if err != nil || scrapped {
+		return err
 	}
 
-	if totalMalfunctionsPrice > car.Price {
-		err = ctx.GetStub().DelState(carId)
-		if err != nil {
-			return err
-		}
-	} else {
-		carAsBytes, _ := json.Marshal(car)
-		err = ctx.GetStub().PutState(car.Id, carAsBytes)
-		if err != nil {
-			return fmt.Errorf("Failed to put to world state. %s", err.Error())
-		}
+	err = putCar(ctx, car)
+	if err != nil {
+		return fmt.Errorf("Failed to put to world state. %s", err.Error())
 	}
-	return nil
+	return emitCarEvent(ctx, malfunctionAddedEvent, before, car)
 }
 
+// RepairCar pays for every quoted malfunction of the car and marks it fixed. Malfunctions
+// that are not quoted yet or already being repaired are left as they are. Owners linked to
+// a token account pay the repair account of the TokenSettlement.
 func (s *SmartContract) RepairCar(ctx contractapi.TransactionContextInterface, carId string) error {
 	car, err := s.QueryCar(ctx, carId)
 	if err != nil {
 		return err
 	}
-	owner, err := s.QueryPerson(ctx, car.OwnerId)
+
+	err = requireInService(car)
+	if err != nil {
+		return err
+	}
+	owner, err := s.queryPersonRecord(ctx, car.OwnerId)
 	if err != nil {
 		return err
 	}
 
-	price := float32(0)
-	for _, malfuction := range car.MalfunctionList {
-		price += malfuction.RepairPrice
+	err = authorizePerson(ctx, owner)
+	if err != nil {
+		return err
+	}
+
+	if !hasQuotedMalfunctions(car.MalfunctionList) {
+		return newError(codeNoMalfunctions, "the car %s has no quoted malfunctions to repair", carId)
 	}
-	if owner.Money < price {
-		return fmt.Errorf("The owner has no enough money to repair the car.")
+
+	price, err := totalRepairPrice(car.Price.Currency, car.MalfunctionList)
+	if err != nil {
+		return err
 	}
 
-	owner.Money -= price
-	car.MalfunctionList = []CarMalfunction{}
+	err = payRepair(ctx, owner, price, carId)
+	if err != nil {
+		return err
+	}
 
-	carAsBytes, err := json.Marshal(car)
+	fixedAt, err := txTime(ctx)
 	if err != nil {
 		return err
 	}
-	err = ctx.GetStub().PutState(carId, carAsBytes)
+
+	before := copyCar(car)
+	for i := range car.MalfunctionList {
+		malfunction := &car.MalfunctionList[i]
+		if malfunction.Status == malfunctionQuoted {
+			malfunction.Status = malfunctionFixed
+			malfunction.FixedAt = &fixedAt
+		}
+	}
+
+	err = putCar(ctx, car)
 	if err != nil {
 		return err
 	}
 
-	ownerAsBytes, err := json.Marshal(owner)
+	err = putPerson(ctx, owner)
 	if err != nil {
 		return err
 	}
-	err = ctx.GetStub().PutState(owner.Id, ownerAsBytes)
+
+	return emitCarEvent(ctx, carRepairedEvent, before, car)
+}
+
+// payRepair pays the repair price of the car. Owners linked to a token account pay in
+// tokens and their balance is left untouched, all others pay from their balance.
+func payRepair(ctx contractapi.TransactionContextInterface, owner *Person, price Money, carId string) error {
+	if owner.TokenAccount != "" {
+		return payRepairTokens(ctx, owner, price)
+	}
+
+	cmp, err := owner.Money.Cmp(price)
 	if err != nil {
 		return err
 	}
+	if cmp < 0 {
+		return newError(codeInsufficientFunds, "The owner has no enough money to repair the car.")
+	}
 
-	return nil
+	return payIn(ctx, owner, repairAccount, price, "repair of "+carId)
 }
 
 func main() {
@@ -0,0 +1,663 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/first-blockchain/golang-blockchain/memstub"
+	"github.com/first-blockchain/golang-blockchain/mocks"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+//go:generate counterfeiter -o mocks/transaction.go -fake-name TransactionContext . transactionContext
+type transactionContext interface {
+	contractapi.TransactionContextInterface
+}
+
+//go:generate counterfeiter -o mocks/chaincodestub.go -fake-name ChaincodeStub . chaincodeStub
+type chaincodeStub interface {
+	shim.ChaincodeStubInterface
+}
+
+//go:generate counterfeiter -o mocks/statequeryiterator.go -fake-name StateQueryIterator . stateQueryIterator
+type stateQueryIterator interface {
+	shim.StateQueryIteratorInterface
+}
+
+//go:generate counterfeiter -o mocks/clientidentity.go -fake-name ClientIdentity . clientIdentity
+type clientIdentity interface {
+	cid.ClientIdentity
+}
+
+// adminClientId is the X.509 ID of the submitter of newTransactionContext
+const adminClientId = "x509::CN=admin,OU=client::CN=ca.org1.example.com"
+
+// newClientIdentity returns a submitter of Org1MSP with the role attribute, if role is not empty
+func newClientIdentity(clientId string, role string) *mocks.ClientIdentity {
+	identity := &mocks.ClientIdentity{}
+	identity.GetIDReturns(base64.StdEncoding.EncodeToString([]byte(clientId)), nil)
+	identity.GetMSPIDReturns("Org1MSP", nil)
+	identity.GetAttributeValueCalls(func(name string) (string, bool, error) {
+		if name != roleAttribute || role == "" {
+			return "", false, nil
+		}
+		return role, true, nil
+	})
+	identity.AssertAttributeValueCalls(func(name string, value string) error {
+		if name != roleAttribute || role != value {
+			return fmt.Errorf("attribute '%s' equals '%s', not '%s'", name, role, value)
+		}
+		return nil
+	})
+
+	return identity
+}
+
+// newTransactionContext returns a transaction context on an empty in-memory ledger,
+// submitted by an admin
+func newTransactionContext() (*mocks.TransactionContext, *memstub.Stub) {
+	stub := memstub.New()
+	transactionContext := &mocks.TransactionContext{}
+	transactionContext.GetStubReturns(stub)
+	transactionContext.GetClientIdentityReturns(newClientIdentity(adminClientId, roleAdmin))
+
+	return transactionContext, stub
+}
+
+// newMockTransactionContext returns a transaction context whose fake stub only creates and
+// splits composite keys, for tests scripting the results of the stub
+func newMockTransactionContext() (*mocks.TransactionContext, *mocks.ChaincodeStub) {
+	chaincodeStub := &mocks.ChaincodeStub{}
+	chaincodeStub.CreateCompositeKeyStub = shim.CreateCompositeKey
+	chaincodeStub.SplitCompositeKeyStub = splitCompositeKey
+
+	transactionContext := &mocks.TransactionContext{}
+	transactionContext.GetStubReturns(chaincodeStub)
+
+	return transactionContext, chaincodeStub
+}
+
+// withPersonDetails passes the details to CreatePerson or UpdatePerson in the transient map
+func withPersonDetails(t *testing.T, transactionContext *mocks.TransactionContext, details PersonDetails) {
+	detailsAsBytes, err := json.Marshal(details)
+	require.NoError(t, err)
+	transactionContext.GetStub().(*memstub.Stub).SetTransient(map[string][]byte{personTransientKey: detailsAsBytes})
+}
+
+// requireContractError asserts that err is a ContractError with the code and message
+func requireContractError(t *testing.T, err error, code ErrorCode, message string) {
+	var contractError *ContractError
+	require.ErrorAs(t, err, &contractError)
+	require.Equal(t, &ContractError{Code: code, Message: message}, contractError)
+}
+
+func newStateQueryIterator(results ...*queryresult.KV) *mocks.StateQueryIterator {
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextCalls(func() bool {
+		return iterator.NextCallCount() < len(results)
+	})
+	iterator.NextCalls(func() (*queryresult.KV, error) {
+		return results[iterator.NextCallCount()-1], nil
+	})
+
+	return iterator
+}
+
+func splitCompositeKey(compositeKey string) (string, []string, error) {
+	parts := strings.Split(strings.TrimPrefix(compositeKey, "\x00"), "\x00")
+	return parts[0], parts[1 : len(parts)-1], nil
+}
+
+// historyQueryIterator is a fake history iterator over fixed results
+type historyQueryIterator struct {
+	results []*queryresult.KeyModification
+}
+
+func (it *historyQueryIterator) HasNext() bool {
+	return len(it.results) > 0
+}
+
+func (it *historyQueryIterator) Next() (*queryresult.KeyModification, error) {
+	result := it.results[0]
+	it.results = it.results[1:]
+	return result, nil
+}
+
+func (it *historyQueryIterator) Close() error {
+	return nil
+}
+
+// newInitializedLedger returns a transaction context on a ledger seeded by InitLedger whose
+// persons are bound to the admin submitting the transactions, so that it may move their money
+func newInitializedLedger(t *testing.T) (*mocks.TransactionContext, *memstub.Stub) {
+	transactionContext, stub := newUnboundLedger(t)
+
+	carContract := SmartContract{}
+	for _, personId := range []string{"person1", "person2", "person3"} {
+		err := carContract.BindPerson(transactionContext, personId, adminClientId, "Org1MSP")
+		require.NoError(t, err)
+	}
+
+	return transactionContext, stub
+}
+
+// onPeerOf runs the chaincode as if on a peer of the organization
+func onPeerOf(t *testing.T, mspId string) {
+	t.Setenv("CORE_PEER_LOCALMSPID", mspId)
+}
+
+// newUnboundLedger returns a transaction context on a ledger seeded by InitLedger, run on a
+// peer of Org1
+func newUnboundLedger(t *testing.T) (*mocks.TransactionContext, *memstub.Stub) {
+	onPeerOf(t, "Org1MSP")
+	transactionContext, stub := newTransactionContext()
+
+	carContract := SmartContract{}
+	err := carContract.InitLedger(transactionContext)
+	require.NoError(t, err)
+
+	return transactionContext, stub
+}
+
+// indexedCarIds returns the ids of the cars referenced by all entries of the index
+func indexedCarIds(t *testing.T, stub *memstub.Stub, indexName string) []string {
+	iterator, err := stub.GetStateByPartialCompositeKey(indexName, []string{})
+	require.NoError(t, err)
+	defer iterator.Close()
+
+	ids := []string{}
+	for iterator.HasNext() {
+		response, err := iterator.Next()
+		require.NoError(t, err)
+		_, attributes, err := stub.SplitCompositeKey(response.Key)
+		require.NoError(t, err)
+		ids = append(ids, attributes[len(attributes)-1])
+	}
+	return ids
+}
+
+func carIds(cars []*Car) []string {
+	ids := []string{}
+	for _, car := range cars {
+		ids = append(ids, car.Id)
+	}
+	return ids
+}
+
+func TestInitLedger(t *testing.T) {
+	transactionContext, stub := newInitializedLedger(t)
+
+	carContract := SmartContract{}
+	car, err := carContract.QueryCar(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, "person1", car.OwnerId)
+	require.Equal(t, NewMoney(10000, defaultCurrency), car.Price)
+	require.Len(t, car.MalfunctionList, 2)
+
+	person, err := carContract.QueryPerson(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(890099, defaultCurrency), person.Money)
+
+	ownerKey, err := shim.CreateCompositeKey(ownerIndex, []string{"person1", "car1"})
+	require.NoError(t, err)
+	value, err := stub.GetState(ownerKey)
+	require.NoError(t, err)
+	require.Equal(t, indexValue, value)
+
+	chaincodeStub := &mocks.ChaincodeStub{}
+	chaincodeStub.CreateCompositeKeyStub = shim.CreateCompositeKey
+	chaincodeStub.PutStateReturns(fmt.Errorf("failed inserting key"))
+	transactionContext.GetStubReturns(chaincodeStub)
+	err = carContract.InitLedger(transactionContext)
+	require.EqualError(t, err, "Failed to put to world state. failed inserting key")
+}
+
+func TestQueryCar(t *testing.T) {
+	transactionContext, _ := newInitializedLedger(t)
+
+	carContract := SmartContract{}
+	_, err := carContract.QueryCar(transactionContext, "car7")
+	requireContractError(t, err, codeNotFound, "car7 does not exist")
+
+	_, err = carContract.QueryCar(transactionContext, "person1")
+	requireContractError(t, err, codeNotFound, "person1 does not exist")
+
+	chaincodeStub := &mocks.ChaincodeStub{}
+	chaincodeStub.GetStateReturns(nil, fmt.Errorf("unable to retrieve car"))
+	transactionContext.GetStubReturns(chaincodeStub)
+	_, err = carContract.QueryCar(transactionContext, "car1")
+	require.EqualError(t, err, "Failed to read from world state. unable to retrieve car")
+}
+
+func TestQueryPerson(t *testing.T) {
+	transactionContext, _ := newInitializedLedger(t)
+
+	carContract := SmartContract{}
+	person, err := carContract.QueryPerson(transactionContext, "person2")
+	require.NoError(t, err)
+	require.Equal(t, "Polo", person.Surname)
+
+	_, err = carContract.QueryPerson(transactionContext, "car1")
+	requireContractError(t, err, codeNotFound, "car1 does not exist")
+}
+
+func TestQueryAllCars(t *testing.T) {
+	transactionContext, _ := newInitializedLedger(t)
+
+	carContract := SmartContract{}
+	cars, err := carContract.QueryAllCars(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, []string{"car1", "car2", "car3", "car4", "car5", "car6"}, carIds(cars))
+}
+
+func TestQueryCarsByIndex(t *testing.T) {
+	transactionContext, _ := newInitializedLedger(t)
+
+	carContract := SmartContract{}
+	cars, err := carContract.QueryCarsByColor(transactionContext, "blue")
+	require.NoError(t, err)
+	require.Equal(t, []string{"car1"}, carIds(cars))
+
+	cars, err = carContract.QueryCarsByOwner(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"car1", "car2", "car3"}, carIds(cars))
+
+	cars, err = carContract.QueryCarsByColorAndOwner(transactionContext, "black", "person3")
+	require.NoError(t, err)
+	require.Equal(t, []string{"car6"}, carIds(cars))
+
+	cars, err = carContract.QueryCarsByColorAndOwner(transactionContext, "black", "person1")
+	require.NoError(t, err)
+	require.Empty(t, cars)
+}
+
+func TestCreateCar(t *testing.T) {
+	transactionContext, _ := newInitializedLedger(t)
+
+	carContract := SmartContract{}
+	err := carContract.CreateCar(transactionContext, "car7", "Skoda", "Octavia", 2015, "white", "person2", 150000, defaultCurrency)
+	require.NoError(t, err)
+
+	car, err := carContract.QueryCar(transactionContext, "car7")
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(150000, defaultCurrency), car.Price)
+	require.Empty(t, car.MalfunctionList)
+
+	cars, err := carContract.QueryCarsByColorAndOwner(transactionContext, "white", "person2")
+	require.NoError(t, err)
+	require.Equal(t, []string{"car7"}, carIds(cars))
+
+	err = carContract.CreateCar(transactionContext, "car7", "Skoda", "Octavia", 2015, "white", "person2", 150000, defaultCurrency)
+	requireContractError(t, err, codeAlreadyExists, "the car car7 already exists")
+
+	err = carContract.CreateCar(transactionContext, "car8", "Skoda", "Octavia", 2015, "white", "person9", 150000, defaultCurrency)
+	requireContractError(t, err, codeNotFound, "the person person9 does not exist")
+}
+
+func TestUpdateCar(t *testing.T) {
+	transactionContext, _ := newInitializedLedger(t)
+
+	carContract := SmartContract{}
+	err := carContract.UpdateCar(transactionContext, "car1", "Toyota", "Corolla", 2003, "white", 12000, defaultCurrency)
+	require.NoError(t, err)
+
+	car, err := carContract.QueryCar(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, "Corolla", car.Model)
+	require.Equal(t, "person1", car.OwnerId)
+	require.Len(t, car.MalfunctionList, 2)
+
+	cars, err := carContract.QueryCarsByColor(transactionContext, "blue")
+	require.NoError(t, err)
+	require.Empty(t, cars)
+
+	cars, err = carContract.QueryCarsByColor(transactionContext, "white")
+	require.NoError(t, err)
+	require.Equal(t, []string{"car1"}, carIds(cars))
+
+	err = carContract.UpdateCar(transactionContext, "car9", "Toyota", "Corolla", 2003, "white", 12000, defaultCurrency)
+	requireContractError(t, err, codeNotFound, "car9 does not exist")
+}
+
+func TestDeleteCar(t *testing.T) {
+	transactionContext, stub := newInitializedLedger(t)
+
+	carContract := SmartContract{}
+	err := carContract.DeleteCar(transactionContext, "car1")
+	require.NoError(t, err)
+
+	exists, err := carContract.CarExists(transactionContext, "car1")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	require.NotContains(t, indexedCarIds(t, stub, colourOwnerIndex), "car1")
+	require.NotContains(t, indexedCarIds(t, stub, ownerIndex), "car1")
+
+	err = carContract.DeleteCar(transactionContext, "car1")
+	requireContractError(t, err, codeNotFound, "car1 does not exist")
+}
+
+func TestPersonLifecycle(t *testing.T) {
+	transactionContext, _ := newInitializedLedger(t)
+
+	carContract := SmartContract{}
+	withPersonDetails(t, transactionContext, PersonDetails{Name: "Nikola", Surname: "Tesla", Email: "tesla@gmail.com", Money: NewMoney(50000, defaultCurrency)})
+	err := carContract.CreatePerson(transactionContext, "person4")
+	require.NoError(t, err)
+
+	withPersonDetails(t, transactionContext, PersonDetails{Name: "Nikola", Surname: "Tesla", Email: "tesla@gmail.com", Money: NewMoney(50000, defaultCurrency)})
+	err = carContract.CreatePerson(transactionContext, "person4")
+	requireContractError(t, err, codeAlreadyExists, "the person person4 already exists")
+
+	withPersonDetails(t, transactionContext, PersonDetails{Name: "Nikola", Surname: "Tesla", Email: "nikola@gmail.com"})
+	err = carContract.UpdatePerson(transactionContext, "person4")
+	require.NoError(t, err)
+
+	person, err := carContract.QueryPerson(transactionContext, "person4")
+	require.NoError(t, err)
+	require.Equal(t, "nikola@gmail.com", person.Email)
+	require.Equal(t, NewMoney(50000, defaultCurrency), person.Money)
+
+	err = carContract.DeletePerson(transactionContext, "person4")
+	require.NoError(t, err)
+
+	exists, err := carContract.PersonExists(transactionContext, "person4")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	err = carContract.DeletePerson(transactionContext, "person4")
+	requireContractError(t, err, codeNotFound, "person4 does not exist")
+
+	err = carContract.DeletePerson(transactionContext, "person1")
+	requireContractError(t, err, codeOwnsCars, "the person person1 still owns cars")
+}
+
+func TestChangeOwner(t *testing.T) {
+	transactionContext, _ := newInitializedLedger(t)
+
+	carContract := SmartContract{}
+	err := carContract.ChangeOwner(transactionContext, "car1", "person1", true)
+	requireContractError(t, err, codeAlreadyOwner, "This person already owns this car!")
+
+	err = carContract.ChangeOwner(transactionContext, "car1", "person2", false)
+	requireContractError(t, err, codeHasMalfunctions, "This car has malfunctions, purchase cannot be made! ")
+
+	err = carContract.ChangeOwner(transactionContext, "car1", "person9", true)
+	requireContractError(t, err, codeNotFound, "person9 does not exist")
+
+	// car1 costs 100.00 and has 90.00 of malfunctions, so the buyer pays 10.00
+	err = carContract.ChangeOwner(transactionContext, "car1", "person2", true)
+	require.NoError(t, err)
+
+	car, err := carContract.QueryCar(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, "person2", car.OwnerId)
+
+	buyer, err := carContract.QueryPerson(transactionContext, "person2")
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(322033, defaultCurrency), buyer.Money)
+
+	seller, err := carContract.QueryPerson(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(891099, defaultCurrency), seller.Money)
+
+	cars, err := carContract.QueryCarsByOwner(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"car2", "car3"}, carIds(cars))
+
+	cars, err = carContract.QueryCarsByColorAndOwner(transactionContext, "blue", "person2")
+	require.NoError(t, err)
+	require.Equal(t, []string{"car1"}, carIds(cars))
+}
+
+func TestChangeOwnerInsufficientFunds(t *testing.T) {
+	transactionContext, _ := newInitializedLedger(t)
+
+	carContract := SmartContract{}
+	withPersonDetails(t, transactionContext, PersonDetails{Name: "Nikola", Surname: "Tesla", Email: "tesla@gmail.com", Money: NewMoney(100, defaultCurrency)})
+	err := carContract.CreatePerson(transactionContext, "person4")
+	require.NoError(t, err)
+
+	err = carContract.ChangeOwner(transactionContext, "car6", "person4", true)
+	requireContractError(t, err, codeInsufficientFunds, "The buyer doesn't have enough money to buy the car! ")
+
+	car, err := carContract.QueryCar(transactionContext, "car6")
+	require.NoError(t, err)
+	require.Equal(t, "person3", car.OwnerId)
+}
+
+func TestChangeCarColour(t *testing.T) {
+	transactionContext, _ := newInitializedLedger(t)
+
+	carContract := SmartContract{}
+	err := carContract.ChangeCarColour(transactionContext, "car2", "blue")
+	require.NoError(t, err)
+
+	cars, err := carContract.QueryCarsByColor(transactionContext, "blue")
+	require.NoError(t, err)
+	require.Equal(t, []string{"car1", "car2"}, carIds(cars))
+
+	cars, err = carContract.QueryCarsByColor(transactionContext, "red")
+	require.NoError(t, err)
+	require.Empty(t, cars)
+
+	cars, err = carContract.QueryCarsByOwner(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"car1", "car2", "car3"}, carIds(cars))
+
+	err = carContract.ChangeCarColour(transactionContext, "car9", "blue")
+	requireContractError(t, err, codeNotFound, "car9 does not exist")
+}
+
+func TestAddMalfunction(t *testing.T) {
+	transactionContext, stub := newInitializedLedger(t)
+
+	reported := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+	stub.StartTx("tx2", reported)
+	carContract := SmartContract{}
+	err := carContract.AddMalfunction(transactionContext, "car2", "Broken Mirror", 0)
+	requireContractError(t, err, codeInvalidArgument, "the repair price must be greater than zero")
+	err = carContract.AddMalfunction(transactionContext, "car2", "Broken Mirror", -3000)
+	requireContractError(t, err, codeInvalidArgument, "the repair price must be greater than zero")
+
+	err = carContract.AddMalfunction(transactionContext, "car2", "Broken Mirror", 3000)
+	require.NoError(t, err)
+
+	car, err := carContract.QueryCar(transactionContext, "car2")
+	require.NoError(t, err)
+	require.Equal(t, CarMalfunction{
+		Id:          "m2",
+		Description: "Broken Mirror",
+		Status:      malfunctionQuoted,
+		RepairPrice: NewMoney(3000, defaultCurrency),
+		ReportedBy:  adminClientId,
+		ReportedAt:  reported,
+	}, car.MalfunctionList[1])
+
+	// car2 costs 200.00, the total repair price is now 70.00 + 140.00
+	err = carContract.AddMalfunction(transactionContext, "car2", "Engine Failure", 14000)
+	require.NoError(t, err)
+
+	// the scrapped car stays queryable and indexed
+	car, err = carContract.QueryCar(transactionContext, "car2")
+	require.NoError(t, err)
+	require.Equal(t, carScrapped, car.Status)
+	require.Equal(t, reported, *car.ScrappedAt)
+	require.Equal(t, NewMoney(2000, defaultCurrency), *car.ScrapValue)
+
+	require.Contains(t, indexedCarIds(t, stub, colourOwnerIndex), "car2")
+	require.Contains(t, indexedCarIds(t, stub, ownerIndex), "car2")
+
+	// the owner is owed a tenth of the price
+	payments, err := carContract.QueryPayments(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Len(t, payments, 1)
+	require.Equal(t, scrapAccount, payments[0].Debit)
+	require.Equal(t, NewMoney(2000, defaultCurrency), payments[0].Amount)
+	require.Equal(t, "scrap value of car2", payments[0].Memo)
+
+	_, err = carContract.CollectPayments(transactionContext, "person1")
+	require.NoError(t, err)
+	owner, err := carContract.QueryPerson(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(892099, defaultCurrency), owner.Money)
+
+	err = carContract.AddMalfunction(transactionContext, "car2", "Flat Tires", 100)
+	requireContractError(t, err, codeInvalidState, "the car car2 is scrapped")
+}
+
+func TestRepairCar(t *testing.T) {
+	transactionContext, _ := newInitializedLedger(t)
+
+	carContract := SmartContract{}
+	err := carContract.RepairCar(transactionContext, "car5")
+	require.NoError(t, err)
+
+	// fixed malfunctions stay in the service history
+	car, err := carContract.QueryCar(transactionContext, "car5")
+	require.NoError(t, err)
+	require.Len(t, car.MalfunctionList, 2)
+	require.False(t, hasOpenMalfunctions(car.MalfunctionList))
+
+	owner, err := carContract.QueryPerson(transactionContext, "person3")
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(330833, defaultCurrency), owner.Money)
+
+	// a repaired car has nothing left to pay for
+	err = carContract.RepairCar(transactionContext, "car5")
+	requireContractError(t, err, codeNoMalfunctions, "the car car5 has no quoted malfunctions to repair")
+
+	withPersonDetails(t, transactionContext, PersonDetails{Name: "Nikola", Surname: "Tesla", Email: "tesla@gmail.com", Money: NewMoney(100, defaultCurrency)})
+	err = carContract.CreatePerson(transactionContext, "person4")
+	require.NoError(t, err)
+	err = carContract.CreateCar(transactionContext, "car7", "Skoda", "Octavia", 2015, "white", "person4", 150000, defaultCurrency)
+	require.NoError(t, err)
+	err = carContract.AddMalfunction(transactionContext, "car7", "Broken Mirror", 3000)
+	require.NoError(t, err)
+
+	err = carContract.RepairCar(transactionContext, "car7")
+	requireContractError(t, err, codeInsufficientFunds, "The owner has no enough money to repair the car.")
+
+	car, err = carContract.QueryCar(transactionContext, "car7")
+	require.NoError(t, err)
+	require.Len(t, car.MalfunctionList, 1)
+}
+
+func TestGetCarHistory(t *testing.T) {
+	transactionContext, chaincodeStub := newMockTransactionContext()
+
+	carAsBytes, err := json.Marshal(Car{Id: "car1", Colour: "blue", OwnerId: "person2"})
+	require.NoError(t, err)
+
+	timestamp := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	chaincodeStub.GetHistoryForKeyReturns(&historyQueryIterator{results: []*queryresult.KeyModification{
+		{TxId: "tx2", Timestamp: timestamppb.New(timestamp), IsDelete: true},
+		{TxId: "tx1", Timestamp: timestamppb.New(timestamp), Value: carAsBytes},
+	}}, nil)
+
+	carContract := SmartContract{}
+	history, err := carContract.GetCarHistory(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	require.Equal(t, HistoryQueryResult{Record: &Car{Id: "car1"}, TxId: "tx2", Timestamp: timestamp, IsDelete: true}, history[0])
+	require.Equal(t, "person2", history[1].Record.OwnerId)
+
+	key, err := shim.CreateCompositeKey(carObjectType, []string{"car1"})
+	require.NoError(t, err)
+	require.Equal(t, key, chaincodeStub.GetHistoryForKeyArgsForCall(0))
+
+	chaincodeStub.GetHistoryForKeyReturns(nil, fmt.Errorf("history database disabled"))
+	_, err = carContract.GetCarHistory(transactionContext, "car1")
+	require.EqualError(t, err, "history database disabled")
+}
+
+func TestQueryCarsWithPagination(t *testing.T) {
+	_, ledger := newInitializedLedger(t)
+	transactionContext, stub := newMockTransactionContext()
+	stub.GetStateStub = ledger.GetState
+
+	car1Key, err := shim.CreateCompositeKey(carObjectType, []string{"car1"})
+	require.NoError(t, err)
+	car1, err := ledger.GetState(car1Key)
+	require.NoError(t, err)
+
+	stub.GetStateByPartialCompositeKeyWithPaginationReturns(
+		newStateQueryIterator(&queryresult.KV{Key: car1Key, Value: car1}),
+		&peer.QueryResponseMetadata{FetchedRecordsCount: 1, Bookmark: "car2"},
+		nil,
+	)
+
+	carContract := SmartContract{}
+	page, err := carContract.QueryAllCarsWithPagination(transactionContext, 1, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"car1"}, carIds(page.Records))
+	require.Equal(t, int32(1), page.FetchedRecordsCount)
+	require.Equal(t, "car2", page.Bookmark)
+
+	objectType, attributes, pageSize, bookmark := stub.GetStateByPartialCompositeKeyWithPaginationArgsForCall(0)
+	require.Equal(t, carObjectType, objectType)
+	require.Empty(t, attributes)
+	require.Equal(t, int32(1), pageSize)
+	require.Equal(t, "", bookmark)
+
+	ownerKey, err := shim.CreateCompositeKey(ownerIndex, []string{"person3", "car6"})
+	require.NoError(t, err)
+	stub.GetStateByPartialCompositeKeyWithPaginationReturns(
+		newStateQueryIterator(&queryresult.KV{Key: ownerKey, Value: indexValue}),
+		&peer.QueryResponseMetadata{FetchedRecordsCount: 1, Bookmark: ""},
+		nil,
+	)
+
+	page, err = carContract.QueryCarsByOwnerWithPagination(transactionContext, "person3", 1, "car5")
+	require.NoError(t, err)
+	require.Equal(t, []string{"car6"}, carIds(page.Records))
+
+	objectType, attributes, _, bookmark = stub.GetStateByPartialCompositeKeyWithPaginationArgsForCall(1)
+	require.Equal(t, ownerIndex, objectType)
+	require.Equal(t, []string{"person3"}, attributes)
+	require.Equal(t, "car5", bookmark)
+
+	stub.GetStateByPartialCompositeKeyWithPaginationReturns(nil, nil, fmt.Errorf("paginated queries are only valid for read only transactions"))
+	_, err = carContract.QueryCarsByColorWithPagination(transactionContext, "blue", 1, "")
+	require.EqualError(t, err, "paginated queries are only valid for read only transactions")
+}
+
+func TestQueryCars(t *testing.T) {
+	transactionContext, chaincodeStub := newMockTransactionContext()
+
+	carKey, err := shim.CreateCompositeKey(carObjectType, []string{"car1"})
+	require.NoError(t, err)
+	personKey, err := shim.CreateCompositeKey(personObjectType, []string{"person1"})
+	require.NoError(t, err)
+
+	carAsBytes, err := json.Marshal(Car{Id: "car1", Brand: "Toyota"})
+	require.NoError(t, err)
+	personAsBytes, err := json.Marshal(Person{Id: "person1"})
+	require.NoError(t, err)
+
+	chaincodeStub.GetQueryResultReturns(newStateQueryIterator(
+		&queryresult.KV{Key: carKey, Value: carAsBytes},
+		&queryresult.KV{Key: personKey, Value: personAsBytes},
+		&queryresult.KV{Key: "car2", Value: carAsBytes},
+	), nil)
+
+	carContract := SmartContract{}
+	cars, err := carContract.QueryCars(transactionContext, `{"selector":{"Brand":"Toyota"}}`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"car1"}, carIds(cars))
+	require.Equal(t, `{"selector":{"Brand":"Toyota"}}`, chaincodeStub.GetQueryResultArgsForCall(0))
+
+	chaincodeStub.GetQueryResultReturns(nil, fmt.Errorf("rich queries are not supported"))
+	_, err = carContract.QueryCars(transactionContext, `{"selector":{}}`)
+	require.EqualError(t, err, "rich queries are not supported")
+}
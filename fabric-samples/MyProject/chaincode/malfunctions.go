@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// A malfunction goes through the following statuses and is never removed from the car, so
+// that the malfunction list is the service history of the car:
+//
+//	reported   ReportMalfunction; the mechanic has not quoted the repair yet
+//	quoted     QuoteMalfunction, AddMalfunction or AddAssessedMalfunction; the repair
+//	           price is known
+//	in-repair  RepairMalfunctions; the owner has paid for the repair
+//	fixed      CompleteRepair, or RepairCar for all quoted malfunctions at once
+const (
+	malfunctionReported = "reported"
+	malfunctionQuoted   = "quoted"
+	malfunctionInRepair = "in-repair"
+	malfunctionFixed    = "fixed"
+)
+
+// Severity of a malfunction as assessed by the reporting mechanic
+const (
+	severityLow      = "low"
+	severityMedium   = "medium"
+	severityHigh     = "high"
+	severityCritical = "critical"
+)
+
+// ReportMalfunction records a malfunction of the car that still has to be quoted and
+// returns its ID. severity is one of low, medium, high or critical.
+func (s *SmartContract) ReportMalfunction(ctx contractapi.TransactionContextInterface, carId string, description string, severity string) (string, error) {
+	err := requireRole(ctx, roleMechanic, roleAdmin)
+	if err != nil {
+		return "", err
+	}
+
+	err = requireSeverity(severity)
+	if err != nil {
+		return "", err
+	}
+
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return "", err
+	}
+
+	err = requireInService(car)
+	if err != nil {
+		return "", err
+	}
+
+	before := copyCar(car)
+
+	malfunction, err := newMalfunction(ctx, car, description, severity)
+	if err != nil {
+		return "", err
+	}
+	car.MalfunctionList = append(car.MalfunctionList, malfunction)
+
+	err = putCar(ctx, car)
+	if err != nil {
+		return "", err
+	}
+
+	err = emitCarEvent(ctx, malfunctionReportedEvent, before, car)
+	if err != nil {
+		return "", err
+	}
+
+	return malfunction.Id, nil
+}
+
+// AddAssessedMalfunction adds a quoted malfunction like AddMalfunction, whose severity is
+// one of low, medium, high or critical
+func (s *SmartContract) AddAssessedMalfunction(ctx contractapi.TransactionContextInterface, carId string, description string, severity string, price int64) error {
+	err := requireSeverity(severity)
+	if err != nil {
+		return err
+	}
+
+	return s.addMalfunction(ctx, carId, description, severity, price)
+}
+
+// QuoteMalfunction sets the repair price of a reported or quoted malfunction in minor units
+// of the car's currency. A car whose unpaid repairs exceed its price is scrapped.
+func (s *SmartContract) QuoteMalfunction(ctx contractapi.TransactionContextInterface, carId string, malfunctionId string, repairPrice int64) error {
+	err := requireRole(ctx, roleMechanic, roleAdmin)
+	if err != nil {
+		return err
+	}
+
+	if repairPrice <= 0 {
+		return newError(codeInvalidArgument, "the repair price must be greater than zero")
+	}
+
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	err = requireInService(car)
+	if err != nil {
+		return err
+	}
+
+	before := copyCar(car)
+
+	malfunction, err := findMalfunction(car, malfunctionId)
+	if err != nil {
+		return err
+	}
+	if !malfunction.unpaid() {
+		return newError(codeInvalidState, "the malfunction %s of the car %s is %s", malfunctionId, carId, malfunction.Status)
+	}
+
+	malfunction.Status = malfunctionQuoted
+	malfunction.RepairPrice = NewMoney(repairPrice, car.Price.Currency)
+
+	scrapped, err := s.scrapIfUnrepairable(ctx, before, car)
+	if err != nil || scrapped {
+		return err
+	}
+
+	err = putCar(ctx, car)
+	if err != nil {
+		return err
+	}
+
+	return emitCarEvent(ctx, malfunctionQuotedEvent, before, car)
+}
+
+// RepairMalfunctions orders the repair of the quoted malfunctions of the car. The owner pays
+// the repair price of each of them, after which they are in repair until a mechanic
+// completes the repair.
+func (s *SmartContract) RepairMalfunctions(ctx contractapi.TransactionContextInterface, carId string, malfunctionIds []string) error {
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	err = requireInService(car)
+	if err != nil {
+		return err
+	}
+
+	owner, err := s.queryPersonRecord(ctx, car.OwnerId)
+	if err != nil {
+		return err
+	}
+
+	err = authorizePerson(ctx, owner)
+	if err != nil {
+		return err
+	}
+
+	if len(malfunctionIds) == 0 {
+		return newError(codeInvalidArgument, "at least one malfunction must be repaired")
+	}
+
+	before := copyCar(car)
+
+	price := NewMoney(0, car.Price.Currency)
+	for _, malfunctionId := range malfunctionIds {
+		malfunction, err := findMalfunction(car, malfunctionId)
+		if err != nil {
+			return err
+		}
+		// listing a malfunction twice would charge it twice
+		if malfunction.Status != malfunctionQuoted {
+			return newError(codeInvalidState, "the malfunction %s of the car %s is %s", malfunctionId, carId, malfunction.Status)
+		}
+
+		price, err = price.Add(malfunction.RepairPrice)
+		if err != nil {
+			return err
+		}
+		malfunction.Status = malfunctionInRepair
+	}
+
+	err = payRepair(ctx, owner, price, carId)
+	if err != nil {
+		return err
+	}
+
+	err = putCar(ctx, car)
+	if err != nil {
+		return err
+	}
+
+	err = putPerson(ctx, owner)
+	if err != nil {
+		return err
+	}
+
+	return emitCarEvent(ctx, repairOrderedEvent, before, car)
+}
+
+// CompleteRepair marks the malfunction in repair as fixed
+func (s *SmartContract) CompleteRepair(ctx contractapi.TransactionContextInterface, carId string, malfunctionId string) error {
+	err := requireRole(ctx, roleMechanic, roleAdmin)
+	if err != nil {
+		return err
+	}
+
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	before := copyCar(car)
+
+	malfunction, err := findMalfunction(car, malfunctionId)
+	if err != nil {
+		return err
+	}
+	if malfunction.Status != malfunctionInRepair {
+		return newError(codeInvalidState, "the malfunction %s of the car %s is %s", malfunctionId, carId, malfunction.Status)
+	}
+
+	fixedAt, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	malfunction.Status = malfunctionFixed
+	malfunction.FixedAt = &fixedAt
+
+	err = putCar(ctx, car)
+	if err != nil {
+		return err
+	}
+
+	return emitCarEvent(ctx, malfunctionFixedEvent, before, car)
+}
+
+// requireSeverity fails unless the severity is one of low, medium, high or critical
+func requireSeverity(severity string) error {
+	switch severity {
+	case severityLow, severityMedium, severityHigh, severityCritical:
+		return nil
+	default:
+		return newError(codeInvalidArgument, "the severity must be low, medium, high or critical")
+	}
+}
+
+// newMalfunction returns a reported malfunction of the car. Malfunctions are never removed,
+// so their position in the list makes a unique ID, e.g. m3 for the third one.
+func newMalfunction(ctx contractapi.TransactionContextInterface, car *Car, description string, severity string) (CarMalfunction, error) {
+	if description == "" {
+		return CarMalfunction{}, newError(codeInvalidArgument, "the description must not be empty")
+	}
+
+	reportedBy, _, err := submittingClient(ctx)
+	if err != nil {
+		return CarMalfunction{}, err
+	}
+
+	reportedAt, err := txTime(ctx)
+	if err != nil {
+		return CarMalfunction{}, err
+	}
+
+	return CarMalfunction{
+		Id:          malfunctionId(len(car.MalfunctionList)),
+		Description: description,
+		Severity:    severity,
+		Status:      malfunctionReported,
+		RepairPrice: NewMoney(0, car.Price.Currency),
+		ReportedBy:  reportedBy,
+		ReportedAt:  reportedAt,
+	}, nil
+}
+
+// malfunctionId returns the ID of the malfunction at the index of the malfunction list
+func malfunctionId(index int) string {
+	return fmt.Sprintf("m%d", index+1)
+}
+
+// findMalfunction returns the malfunction of the car with the ID, which may be changed in place
+func findMalfunction(car *Car, malfunctionId string) (*CarMalfunction, error) {
+	for i := range car.MalfunctionList {
+		if car.MalfunctionList[i].Id == malfunctionId {
+			return &car.MalfunctionList[i], nil
+		}
+	}
+
+	return nil, newError(codeNotFound, "the car %s has no malfunction %s", car.Id, malfunctionId)
+}
+
+// unpaid reports whether the repair of the malfunction has not been paid for yet
+func (m CarMalfunction) unpaid() bool {
+	return m.Status == malfunctionReported || m.Status == malfunctionQuoted
+}
+
+// hasOpenMalfunctions reports whether any of the malfunctions is not fixed yet
+func hasOpenMalfunctions(malfunctions []CarMalfunction) bool {
+	for _, malfunction := range malfunctions {
+		if malfunction.Status != malfunctionFixed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasQuotedMalfunctions reports whether any of the malfunctions is quoted and not yet paid for
+func hasQuotedMalfunctions(malfunctions []CarMalfunction) bool {
+	for _, malfunction := range malfunctions {
+		if malfunction.Status == malfunctionQuoted {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMalfunctionLifecycle(t *testing.T) {
+	transactionContext, stub := newBoundLedger(t)
+	carContract := SmartContract{}
+	reported := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(mechanicClientId, roleMechanic))
+	_, err := carContract.ReportMalfunction(transactionContext, "car2", "Broken Mirror", "cosmetic")
+	requireContractError(t, err, codeInvalidArgument, "the severity must be low, medium, high or critical")
+
+	stub.StartTx("tx2", reported)
+	mirrorId, err := carContract.ReportMalfunction(transactionContext, "car2", "Broken Mirror", severityLow)
+	require.NoError(t, err)
+	require.Equal(t, "m2", mirrorId)
+	require.Equal(t, "MalfunctionReported", stub.Event().EventName)
+
+	brakesId, err := carContract.ReportMalfunction(transactionContext, "car2", "Worn Brakes", severityHigh)
+	require.NoError(t, err)
+
+	car, err := carContract.QueryCar(transactionContext, "car2")
+	require.NoError(t, err)
+	require.Equal(t, CarMalfunction{
+		Id:          "m2",
+		Description: "Broken Mirror",
+		Severity:    severityLow,
+		Status:      malfunctionReported,
+		RepairPrice: NewMoney(0, defaultCurrency),
+		ReportedBy:  mechanicClientId,
+		ReportedAt:  reported,
+	}, car.MalfunctionList[1])
+
+	// a reported malfunction has no price yet
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err = carContract.RepairMalfunctions(transactionContext, "car2", []string{mirrorId})
+	requireContractError(t, err, codeInvalidState, "the malfunction m2 of the car car2 is reported")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(mechanicClientId, roleMechanic))
+	err = carContract.QuoteMalfunction(transactionContext, "car2", mirrorId, 3000)
+	require.NoError(t, err)
+	require.Equal(t, "MalfunctionQuoted", stub.Event().EventName)
+	err = carContract.QuoteMalfunction(transactionContext, "car2", brakesId, 6000)
+	require.NoError(t, err)
+
+	err = carContract.QuoteMalfunction(transactionContext, "car2", "m9", 6000)
+	requireContractError(t, err, codeNotFound, "the car car2 has no malfunction m9")
+
+	// only the owner orders repairs, paying per malfunction
+	err = carContract.RepairMalfunctions(transactionContext, "car2", []string{mirrorId})
+	requireContractError(t, err, codeForbidden, "submitting client not authorized to act for person1")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err = carContract.RepairMalfunctions(transactionContext, "car2", []string{mirrorId, mirrorId})
+	requireContractError(t, err, codeInvalidState, "the malfunction m2 of the car car2 is in-repair")
+
+	err = carContract.RepairMalfunctions(transactionContext, "car2", []string{mirrorId, "m1"})
+	require.NoError(t, err)
+	require.Equal(t, "RepairOrdered", stub.Event().EventName)
+
+	owner, err := carContract.QueryPerson(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(883099, defaultCurrency), owner.Money)
+
+	// a paid repair is not charged again
+	err = carContract.RepairCar(transactionContext, "car2")
+	require.NoError(t, err)
+
+	owner, err = carContract.QueryPerson(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(877099, defaultCurrency), owner.Money)
+
+	err = carContract.CompleteRepair(transactionContext, "car2", mirrorId)
+	requireContractError(t, err, codeForbidden, "submitting client not authorized, requires one of the mechanic, admin roles")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(mechanicClientId, roleMechanic))
+	fixed := reported.Add(24 * time.Hour)
+	stub.StartTx("tx3", fixed)
+	err = carContract.CompleteRepair(transactionContext, "car2", mirrorId)
+	require.NoError(t, err)
+	require.Equal(t, "MalfunctionFixed", stub.Event().EventName)
+
+	err = carContract.CompleteRepair(transactionContext, "car2", mirrorId)
+	requireContractError(t, err, codeInvalidState, "the malfunction m2 of the car car2 is fixed")
+
+	car, err = carContract.QueryCar(transactionContext, "car2")
+	require.NoError(t, err)
+	require.Len(t, car.MalfunctionList, 3)
+	require.Equal(t, malfunctionFixed, car.MalfunctionList[1].Status)
+	require.Equal(t, &fixed, car.MalfunctionList[1].FixedAt)
+	require.Equal(t, malfunctionFixed, car.MalfunctionList[2].Status)
+	// the bad fuel economy is paid but still in repair
+	require.True(t, hasOpenMalfunctions(car.MalfunctionList))
+}
+
+func TestAddAssessedMalfunction(t *testing.T) {
+	transactionContext, stub := newBoundLedger(t)
+	carContract := SmartContract{}
+	reported := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(mechanicClientId, roleMechanic))
+	err := carContract.AddAssessedMalfunction(transactionContext, "car2", "Broken Mirror", "cosmetic", 3000)
+	requireContractError(t, err, codeInvalidArgument, "the severity must be low, medium, high or critical")
+	err = carContract.AddAssessedMalfunction(transactionContext, "car2", "Broken Mirror", severityLow, 0)
+	requireContractError(t, err, codeInvalidArgument, "the repair price must be greater than zero")
+
+	stub.StartTx("tx2", reported)
+	err = carContract.AddAssessedMalfunction(transactionContext, "car2", "Broken Mirror", severityLow, 3000)
+	require.NoError(t, err)
+	require.Equal(t, "MalfunctionAdded", stub.Event().EventName)
+
+	car, err := carContract.QueryCar(transactionContext, "car2")
+	require.NoError(t, err)
+	require.Equal(t, CarMalfunction{
+		Id:          "m2",
+		Description: "Broken Mirror",
+		Severity:    severityLow,
+		Status:      malfunctionQuoted,
+		RepairPrice: NewMoney(3000, defaultCurrency),
+		ReportedBy:  mechanicClientId,
+		ReportedAt:  reported,
+	}, car.MalfunctionList[1])
+}
+
+func TestQuoteMalfunctionScrapsCar(t *testing.T) {
+	transactionContext, stub := newInitializedLedger(t)
+	carContract := SmartContract{}
+
+	malfunctionId, err := carContract.ReportMalfunction(transactionContext, "car2", "Engine Failure", severityCritical)
+	require.NoError(t, err)
+
+	// car2 costs 200.00 and already needs 40.00 of repairs
+	err = carContract.QuoteMalfunction(transactionContext, "car2", malfunctionId, 16001)
+	require.NoError(t, err)
+	require.Equal(t, "CarScrapped", stub.Event().EventName)
+
+	car, err := carContract.QueryCar(transactionContext, "car2")
+	require.NoError(t, err)
+	require.Equal(t, carScrapped, car.Status)
+}
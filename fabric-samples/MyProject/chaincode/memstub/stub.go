@@ -0,0 +1,592 @@
+// Package memstub provides an in-memory implementation of shim.ChaincodeStubInterface
+// for testing contracts against realistic world state instead of scripted mock returns.
+//
+// Writes are applied immediately and every write is recorded in the history of its key
+// under the current transaction ID and timestamp. Range and partial composite key queries
+// iterate the keys in sorted order and keep simple and composite keys apart, like the peer does.
+// Rich queries are not supported.
+package memstub
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	compositeKeyNamespace = "\x00"
+	emptyKeySubstitute    = "\x01"
+	maxUnicodeRune        = string(utf8.MaxRune)
+)
+
+// ErrRichQueryNotSupported is returned by the rich query functions
+var ErrRichQueryNotSupported = errors.New("rich queries are not supported by the in-memory stub")
+
+// InvokeFunc handles a chaincode-to-chaincode call made with InvokeChaincode
+type InvokeFunc func(args [][]byte, channel string) peer.Response
+
+// Stub is an in-memory shim.ChaincodeStubInterface. The zero value is not usable, use New.
+type Stub struct {
+	channelID string
+	txID      string
+	timestamp time.Time
+	args      [][]byte
+	creator   []byte
+	transient map[string][]byte
+
+	state       *namespace
+	collections map[string]*namespace
+
+	event      *peer.ChaincodeEvent
+	chaincodes map[string]InvokeFunc
+}
+
+// namespace is the key/value store of the world state or of one private data collection.
+// hashes are the hashes of the private data committed to the channel.
+type namespace struct {
+	values               map[string][]byte
+	hashes               map[string][]byte
+	validationParameters map[string][]byte
+	history              map[string][]*queryresult.KeyModification
+}
+
+func newNamespace() *namespace {
+	return &namespace{
+		values:               map[string][]byte{},
+		hashes:               map[string][]byte{},
+		validationParameters: map[string][]byte{},
+		history:              map[string][]*queryresult.KeyModification{},
+	}
+}
+
+// New returns an empty stub on channel "mychannel" with transaction ID "tx1"
+// and a fixed transaction timestamp
+func New() *Stub {
+	return &Stub{
+		channelID:   "mychannel",
+		txID:        "tx1",
+		timestamp:   time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		transient:   map[string][]byte{},
+		state:       newNamespace(),
+		collections: map[string]*namespace{},
+		chaincodes:  map[string]InvokeFunc{},
+	}
+}
+
+// StartTx begins a new transaction with the given ID and timestamp. The event,
+// arguments and transient data of the previous transaction are cleared.
+func (s *Stub) StartTx(txID string, timestamp time.Time) {
+	s.txID = txID
+	s.timestamp = timestamp
+	s.event = nil
+	s.args = nil
+	s.transient = map[string][]byte{}
+}
+
+// SetChannelID sets the channel returned by GetChannelID
+func (s *Stub) SetChannelID(channelID string) {
+	s.channelID = channelID
+}
+
+// SetTxID sets the transaction ID of the current transaction
+func (s *Stub) SetTxID(txID string) {
+	s.txID = txID
+}
+
+// SetTxTimestamp sets the timestamp of the current transaction
+func (s *Stub) SetTxTimestamp(timestamp time.Time) {
+	s.timestamp = timestamp
+}
+
+// SetArgs sets the function name and parameters of the current transaction
+func (s *Stub) SetArgs(args ...string) {
+	s.args = nil
+	for _, arg := range args {
+		s.args = append(s.args, []byte(arg))
+	}
+}
+
+// SetCreator sets the serialized identity returned by GetCreator
+func (s *Stub) SetCreator(creator []byte) {
+	s.creator = creator
+}
+
+// SetTransient sets the transient data of the current transaction
+func (s *Stub) SetTransient(transient map[string][]byte) {
+	s.transient = transient
+}
+
+// SetChaincode registers the handler called when another chaincode is invoked by name
+func (s *Stub) SetChaincode(name string, invoke InvokeFunc) {
+	s.chaincodes[name] = invoke
+}
+
+// Event returns the event set by the current transaction or nil
+func (s *Stub) Event() *peer.ChaincodeEvent {
+	return s.event
+}
+
+// GetArgs returns the arguments of the current transaction
+func (s *Stub) GetArgs() [][]byte {
+	return s.args
+}
+
+// GetStringArgs returns the arguments of the current transaction as strings
+func (s *Stub) GetStringArgs() []string {
+	args := make([]string, 0, len(s.args))
+	for _, arg := range s.args {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+// GetFunctionAndParameters returns the first argument as the function name and the rest as parameters
+func (s *Stub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+// GetArgsSlice returns the arguments of the current transaction concatenated
+func (s *Stub) GetArgsSlice() ([]byte, error) {
+	var slice []byte
+	for _, arg := range s.args {
+		slice = append(slice, arg...)
+	}
+	return slice, nil
+}
+
+// GetTxID returns the ID of the current transaction
+func (s *Stub) GetTxID() string {
+	return s.txID
+}
+
+// GetChannelID returns the channel of the current transaction
+func (s *Stub) GetChannelID() string {
+	return s.channelID
+}
+
+// InvokeChaincode calls the handler registered with SetChaincode
+func (s *Stub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) peer.Response {
+	invoke, ok := s.chaincodes[chaincodeName]
+	if !ok {
+		return shim.Error(fmt.Sprintf("chaincode %s is not registered", chaincodeName))
+	}
+	return invoke(args, channel)
+}
+
+// GetState returns the value of the key or nil if it does not exist
+func (s *Stub) GetState(key string) ([]byte, error) {
+	return s.state.get(key), nil
+}
+
+// PutState writes the value of the key and records it in the history of the key
+func (s *Stub) PutState(key string, value []byte) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	return s.state.put(key, value, s.modification())
+}
+
+// DelState deletes the key and records the deletion in the history of the key
+func (s *Stub) DelState(key string) error {
+	return s.state.del(key, s.modification())
+}
+
+// SetStateValidationParameter sets the key-level endorsement policy of the key
+func (s *Stub) SetStateValidationParameter(key string, ep []byte) error {
+	s.state.validationParameters[key] = ep
+	return nil
+}
+
+// GetStateValidationParameter returns the key-level endorsement policy of the key
+func (s *Stub) GetStateValidationParameter(key string) ([]byte, error) {
+	return s.state.validationParameters[key], nil
+}
+
+// GetStateByRange iterates the simple keys in [startKey, endKey). Empty keys leave the range open.
+func (s *Stub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if err := validateSimpleKeys(startKey, endKey); err != nil {
+		return nil, err
+	}
+	iterator, _ := s.state.rangeQuery(simpleRange(startKey, endKey))
+	return iterator, nil
+}
+
+// GetStateByRangeWithPagination iterates at most pageSize simple keys in [startKey, endKey)
+// starting at the bookmark
+func (s *Stub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	if err := validateSimpleKeys(startKey, endKey); err != nil {
+		return nil, nil, err
+	}
+	startKey, endKey = simpleRange(startKey, endKey)
+	return s.state.paginatedRangeQuery(startKey, endKey, pageSize, bookmark)
+}
+
+// GetStateByPartialCompositeKey iterates the composite keys starting with the object type and attributes
+func (s *Stub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	startKey, endKey, err := compositeRange(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	iterator, _ := s.state.rangeQuery(startKey, endKey)
+	return iterator, nil
+}
+
+// GetStateByPartialCompositeKeyWithPagination iterates at most pageSize composite keys starting
+// with the object type and attributes, beginning at the bookmark
+func (s *Stub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	startKey, endKey, err := compositeRange(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.state.paginatedRangeQuery(startKey, endKey, pageSize, bookmark)
+}
+
+// CreateCompositeKey combines the object type and attributes into a composite key
+func (s *Stub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return shim.CreateCompositeKey(objectType, attributes)
+}
+
+// SplitCompositeKey splits a composite key into its object type and attributes
+func (s *Stub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	if !strings.HasPrefix(compositeKey, compositeKeyNamespace) || !strings.HasSuffix(compositeKey, "\x00") || len(compositeKey) < 2 {
+		return "", nil, fmt.Errorf("%q is not a composite key", compositeKey)
+	}
+	components := strings.Split(compositeKey[1:len(compositeKey)-1], "\x00")
+	return components[0], components[1:], nil
+}
+
+// GetQueryResult is not supported
+func (s *Stub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, ErrRichQueryNotSupported
+}
+
+// GetQueryResultWithPagination is not supported
+func (s *Stub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	return nil, nil, ErrRichQueryNotSupported
+}
+
+// GetHistoryForKey returns every write of the key, newest first
+func (s *Stub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	history := s.state.history[key]
+	results := make([]*queryresult.KeyModification, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		results = append(results, history[i])
+	}
+	return &HistoryQueryIterator{results: results}, nil
+}
+
+// GetPrivateData returns the value of the key in the collection or nil if it does not exist
+func (s *Stub) GetPrivateData(collection, key string) ([]byte, error) {
+	if collection == "" {
+		return nil, errors.New("collection must not be an empty string")
+	}
+	return s.collection(collection).get(key), nil
+}
+
+// GetPrivateDataHash returns the SHA-256 hash of the value of the key in the collection as
+// committed to the channel, or nil if it does not exist
+func (s *Stub) GetPrivateDataHash(collection, key string) ([]byte, error) {
+	if collection == "" {
+		return nil, errors.New("collection must not be an empty string")
+	}
+	hash, ok := s.collection(collection).hashes[key]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte{}, hash...), nil
+}
+
+// TamperPrivateData replaces the value of the key in the collection without changing its
+// hash, like a peer whose copy of the collection no longer matches the channel
+func (s *Stub) TamperPrivateData(collection, key string, value []byte) {
+	s.collection(collection).values[key] = append([]byte{}, value...)
+}
+
+// PutPrivateData writes the value of the key in the collection
+func (s *Stub) PutPrivateData(collection string, key string, value []byte) error {
+	if collection == "" {
+		return errors.New("collection must not be an empty string")
+	}
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	ns := s.collection(collection)
+	if value != nil {
+		hash := sha256.Sum256(value)
+		ns.hashes[key] = hash[:]
+	}
+	return ns.put(key, value, s.modification())
+}
+
+// DelPrivateData deletes the key from the collection
+func (s *Stub) DelPrivateData(collection, key string) error {
+	if collection == "" {
+		return errors.New("collection must not be an empty string")
+	}
+	ns := s.collection(collection)
+	delete(ns.hashes, key)
+	return ns.del(key, s.modification())
+}
+
+// PurgePrivateData deletes the key from the collection
+func (s *Stub) PurgePrivateData(collection, key string) error {
+	return s.DelPrivateData(collection, key)
+}
+
+// SetPrivateDataValidationParameter sets the key-level endorsement policy of the key in the collection
+func (s *Stub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	s.collection(collection).validationParameters[key] = ep
+	return nil
+}
+
+// GetPrivateDataValidationParameter returns the key-level endorsement policy of the key in the collection
+func (s *Stub) GetPrivateDataValidationParameter(collection, key string) ([]byte, error) {
+	return s.collection(collection).validationParameters[key], nil
+}
+
+// GetPrivateDataByRange iterates the simple keys of the collection in [startKey, endKey)
+func (s *Stub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if collection == "" {
+		return nil, errors.New("collection must not be an empty string")
+	}
+	if err := validateSimpleKeys(startKey, endKey); err != nil {
+		return nil, err
+	}
+	iterator, _ := s.collection(collection).rangeQuery(simpleRange(startKey, endKey))
+	return iterator, nil
+}
+
+// GetPrivateDataByPartialCompositeKey iterates the composite keys of the collection starting
+// with the object type and attributes
+func (s *Stub) GetPrivateDataByPartialCompositeKey(collection, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	if collection == "" {
+		return nil, errors.New("collection must not be an empty string")
+	}
+	startKey, endKey, err := compositeRange(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	iterator, _ := s.collection(collection).rangeQuery(startKey, endKey)
+	return iterator, nil
+}
+
+// GetPrivateDataQueryResult is not supported
+func (s *Stub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, ErrRichQueryNotSupported
+}
+
+// GetCreator returns the identity set with SetCreator
+func (s *Stub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+// GetTransient returns the transient data set with SetTransient
+func (s *Stub) GetTransient() (map[string][]byte, error) {
+	return s.transient, nil
+}
+
+// GetBinding is not supported and returns nil
+func (s *Stub) GetBinding() ([]byte, error) {
+	return nil, nil
+}
+
+// GetDecorations returns no decorations
+func (s *Stub) GetDecorations() map[string][]byte {
+	return map[string][]byte{}
+}
+
+// GetSignedProposal is not supported and returns an empty proposal
+func (s *Stub) GetSignedProposal() (*peer.SignedProposal, error) {
+	return &peer.SignedProposal{}, nil
+}
+
+// GetTxTimestamp returns the timestamp of the current transaction
+func (s *Stub) GetTxTimestamp() (*timestamppb.Timestamp, error) {
+	return timestamppb.New(s.timestamp), nil
+}
+
+// SetEvent sets the event of the current transaction, replacing any earlier one
+func (s *Stub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return errors.New("event name can not be empty string")
+	}
+	s.event = &peer.ChaincodeEvent{TxId: s.txID, EventName: name, Payload: payload}
+	return nil
+}
+
+func (s *Stub) collection(name string) *namespace {
+	if _, ok := s.collections[name]; !ok {
+		s.collections[name] = newNamespace()
+	}
+	return s.collections[name]
+}
+
+func (s *Stub) modification() *queryresult.KeyModification {
+	return &queryresult.KeyModification{TxId: s.txID, Timestamp: timestamppb.New(s.timestamp)}
+}
+
+func (ns *namespace) get(key string) []byte {
+	value, ok := ns.values[key]
+	if !ok {
+		return nil
+	}
+	return append([]byte{}, value...)
+}
+
+func (ns *namespace) put(key string, value []byte, modification *queryresult.KeyModification) error {
+	if value == nil {
+		return ns.del(key, modification)
+	}
+	ns.values[key] = append([]byte{}, value...)
+	modification.Value = ns.values[key]
+	ns.history[key] = append(ns.history[key], modification)
+	return nil
+}
+
+func (ns *namespace) del(key string, modification *queryresult.KeyModification) error {
+	delete(ns.values, key)
+	modification.IsDelete = true
+	ns.history[key] = append(ns.history[key], modification)
+	return nil
+}
+
+// rangeQuery returns an iterator over the sorted keys in [startKey, endKey) and the keys themselves
+func (ns *namespace) rangeQuery(startKey, endKey string) (*StateQueryIterator, []string) {
+	keys := []string{}
+	for key := range ns.values {
+		if key >= startKey && (endKey == "" || key < endKey) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	results := make([]*queryresult.KV, 0, len(keys))
+	for _, key := range keys {
+		results = append(results, &queryresult.KV{Key: key, Value: ns.get(key)})
+	}
+	return &StateQueryIterator{results: results}, keys
+}
+
+// paginatedRangeQuery returns at most pageSize keys starting at the bookmark. The bookmark of
+// the next page is the first key not returned or empty when the range is exhausted.
+func (ns *namespace) paginatedRangeQuery(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	if pageSize <= 0 {
+		return nil, nil, fmt.Errorf("pageSize must be greater than zero")
+	}
+	if bookmark != "" && bookmark > startKey {
+		startKey = bookmark
+	}
+
+	iterator, keys := ns.rangeQuery(startKey, endKey)
+	next := ""
+	if len(keys) > int(pageSize) {
+		next = keys[pageSize]
+		iterator.results = iterator.results[:pageSize]
+	}
+
+	return iterator, &peer.QueryResponseMetadata{FetchedRecordsCount: int32(len(iterator.results)), Bookmark: next}, nil
+}
+
+// simpleRange substitutes an empty start key so that the composite key namespace is excluded
+func simpleRange(startKey, endKey string) (string, string) {
+	if startKey == "" {
+		startKey = emptyKeySubstitute
+	}
+	return startKey, endKey
+}
+
+func compositeRange(objectType string, attributes []string) (string, string, error) {
+	partialKey, err := shim.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return "", "", err
+	}
+	return partialKey, partialKey + maxUnicodeRune, nil
+}
+
+func validateKey(key string) error {
+	if key == "" {
+		return errors.New("key must not be an empty string")
+	}
+	if !utf8.ValidString(key) {
+		return fmt.Errorf("key %x is not a valid utf8 string", key)
+	}
+	return nil
+}
+
+func validateSimpleKeys(keys ...string) error {
+	for _, key := range keys {
+		if strings.HasPrefix(key, compositeKeyNamespace) {
+			return fmt.Errorf("first character of the key [%s] contains a null character which is not allowed", key)
+		}
+	}
+	return nil
+}
+
+// StateQueryIterator iterates a snapshot of key/value pairs
+type StateQueryIterator struct {
+	results []*queryresult.KV
+	closed  bool
+}
+
+// HasNext returns true while the iterator is open and has results left
+func (it *StateQueryIterator) HasNext() bool {
+	return !it.closed && len(it.results) > 0
+}
+
+// Next returns the next key/value pair
+func (it *StateQueryIterator) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, errors.New("no more results")
+	}
+	result := it.results[0]
+	it.results = it.results[1:]
+	return result, nil
+}
+
+// Close closes the iterator
+func (it *StateQueryIterator) Close() error {
+	it.closed = true
+	return nil
+}
+
+// HistoryQueryIterator iterates a snapshot of the history of a key
+type HistoryQueryIterator struct {
+	results []*queryresult.KeyModification
+	closed  bool
+}
+
+// HasNext returns true while the iterator is open and has results left
+func (it *HistoryQueryIterator) HasNext() bool {
+	return !it.closed && len(it.results) > 0
+}
+
+// Next returns the next modification of the key
+func (it *HistoryQueryIterator) Next() (*queryresult.KeyModification, error) {
+	if !it.HasNext() {
+		return nil, errors.New("no more results")
+	}
+	result := it.results[0]
+	it.results = it.results[1:]
+	return result, nil
+}
+
+// Close closes the iterator
+func (it *HistoryQueryIterator) Close() error {
+	it.closed = true
+	return nil
+}
+
+var _ shim.ChaincodeStubInterface = (*Stub)(nil)
@@ -0,0 +1,201 @@
+package memstub_test
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/first-blockchain/golang-blockchain/memstub"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func keys(t *testing.T, iterator shim.StateQueryIteratorInterface) []string {
+	defer iterator.Close()
+
+	result := []string{}
+	for iterator.HasNext() {
+		response, err := iterator.Next()
+		require.NoError(t, err)
+		result = append(result, response.Key)
+	}
+	return result
+}
+
+func TestState(t *testing.T) {
+	stub := memstub.New()
+
+	value, err := stub.GetState("car1")
+	require.NoError(t, err)
+	require.Nil(t, value)
+
+	err = stub.PutState("car1", []byte("blue"))
+	require.NoError(t, err)
+	value, err = stub.GetState("car1")
+	require.NoError(t, err)
+	require.Equal(t, []byte("blue"), value)
+
+	err = stub.DelState("car1")
+	require.NoError(t, err)
+	value, err = stub.GetState("car1")
+	require.NoError(t, err)
+	require.Nil(t, value)
+
+	err = stub.PutState("", []byte("blue"))
+	require.EqualError(t, err, "key must not be an empty string")
+}
+
+func TestRangeQueries(t *testing.T) {
+	stub := memstub.New()
+	for _, key := range []string{"car3", "car1", "person1", "car2"} {
+		require.NoError(t, stub.PutState(key, []byte(key)))
+	}
+	colourKey, err := stub.CreateCompositeKey("Colour~Id", []string{"blue", "car1"})
+	require.NoError(t, err)
+	require.NoError(t, stub.PutState(colourKey, []byte{0x00}))
+
+	iterator, err := stub.GetStateByRange("", "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"car1", "car2", "car3", "person1"}, keys(t, iterator))
+
+	iterator, err = stub.GetStateByRange("car2", "person1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"car2", "car3"}, keys(t, iterator))
+
+	iterator, err = stub.GetStateByPartialCompositeKey("Colour~Id", []string{"blue"})
+	require.NoError(t, err)
+	require.Equal(t, []string{colourKey}, keys(t, iterator))
+
+	_, err = stub.GetStateByRange(colourKey, "")
+	require.Error(t, err)
+
+	objectType, attributes, err := stub.SplitCompositeKey(colourKey)
+	require.NoError(t, err)
+	require.Equal(t, "Colour~Id", objectType)
+	require.Equal(t, []string{"blue", "car1"}, attributes)
+
+	_, _, err = stub.SplitCompositeKey("car1")
+	require.Error(t, err)
+
+	_, err = stub.GetQueryResult(`{"selector":{}}`)
+	require.ErrorIs(t, err, memstub.ErrRichQueryNotSupported)
+}
+
+func TestPagination(t *testing.T) {
+	stub := memstub.New()
+	for _, key := range []string{"car1", "car2", "car3"} {
+		require.NoError(t, stub.PutState(key, []byte(key)))
+	}
+
+	iterator, metadata, err := stub.GetStateByRangeWithPagination("", "", 2, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"car1", "car2"}, keys(t, iterator))
+	require.Equal(t, int32(2), metadata.FetchedRecordsCount)
+	require.Equal(t, "car3", metadata.Bookmark)
+
+	iterator, metadata, err = stub.GetStateByRangeWithPagination("", "", 2, metadata.Bookmark)
+	require.NoError(t, err)
+	require.Equal(t, []string{"car3"}, keys(t, iterator))
+	require.Equal(t, int32(1), metadata.FetchedRecordsCount)
+	require.Empty(t, metadata.Bookmark)
+
+	_, _, err = stub.GetStateByRangeWithPagination("", "", 0, "")
+	require.Error(t, err)
+}
+
+func TestHistory(t *testing.T) {
+	stub := memstub.New()
+	created := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	stub.StartTx("tx1", created)
+	require.NoError(t, stub.PutState("car1", []byte("blue")))
+	stub.StartTx("tx2", created.Add(time.Hour))
+	require.NoError(t, stub.PutState("car1", []byte("red")))
+	stub.StartTx("tx3", created.Add(2*time.Hour))
+	require.NoError(t, stub.DelState("car1"))
+
+	require.Equal(t, "tx3", stub.GetTxID())
+	timestamp, err := stub.GetTxTimestamp()
+	require.NoError(t, err)
+	require.Equal(t, created.Add(2*time.Hour), timestamp.AsTime())
+
+	iterator, err := stub.GetHistoryForKey("car1")
+	require.NoError(t, err)
+	defer iterator.Close()
+
+	expected := []struct {
+		txID     string
+		value    string
+		isDelete bool
+	}{{"tx3", "", true}, {"tx2", "red", false}, {"tx1", "blue", false}}
+	for _, modification := range expected {
+		require.True(t, iterator.HasNext())
+		response, err := iterator.Next()
+		require.NoError(t, err)
+		require.Equal(t, modification.txID, response.TxId)
+		require.Equal(t, modification.value, string(response.Value))
+		require.Equal(t, modification.isDelete, response.IsDelete)
+	}
+	require.False(t, iterator.HasNext())
+}
+
+func TestPrivateData(t *testing.T) {
+	stub := memstub.New()
+
+	err := stub.PutPrivateData("balances", "person1", []byte("100"))
+	require.NoError(t, err)
+
+	value, err := stub.GetPrivateData("balances", "person1")
+	require.NoError(t, err)
+	require.Equal(t, []byte("100"), value)
+
+	value, err = stub.GetState("person1")
+	require.NoError(t, err)
+	require.Nil(t, value)
+
+	hash, err := stub.GetPrivateDataHash("balances", "person1")
+	require.NoError(t, err)
+	expected := sha256.Sum256([]byte("100"))
+	require.Equal(t, expected[:], hash)
+
+	stub.TamperPrivateData("balances", "person1", []byte("1000"))
+	hash, err = stub.GetPrivateDataHash("balances", "person1")
+	require.NoError(t, err)
+	require.Equal(t, expected[:], hash)
+
+	err = stub.DelPrivateData("balances", "person1")
+	require.NoError(t, err)
+	value, err = stub.GetPrivateData("balances", "person1")
+	require.NoError(t, err)
+	require.Nil(t, value)
+	hash, err = stub.GetPrivateDataHash("balances", "person1")
+	require.NoError(t, err)
+	require.Nil(t, hash)
+
+	_, err = stub.GetPrivateData("", "person1")
+	require.Error(t, err)
+}
+
+func TestEventsAndInvokeChaincode(t *testing.T) {
+	stub := memstub.New()
+	require.Nil(t, stub.Event())
+
+	require.NoError(t, stub.SetEvent("CarCreated", []byte("car1")))
+	require.NoError(t, stub.SetEvent("CarTransferred", []byte("car1")))
+	require.Equal(t, "CarTransferred", stub.Event().EventName)
+
+	stub.StartTx("tx2", time.Now())
+	require.Nil(t, stub.Event())
+	require.Error(t, stub.SetEvent("", nil))
+
+	stub.SetChaincode("token", func(args [][]byte, channel string) peer.Response {
+		return shim.Success(append([]byte(channel+":"), args[0]...))
+	})
+	response := stub.InvokeChaincode("token", [][]byte{[]byte("BalanceOf")}, "mychannel")
+	require.Equal(t, int32(shim.OK), response.Status)
+	require.Equal(t, "mychannel:BalanceOf", string(response.Payload))
+
+	response = stub.InvokeChaincode("missing", nil, "")
+	require.Equal(t, int32(shim.ERROR), response.Status)
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// MigrateOwnerIndex backfills the OwnerId~Id index for cars that were created before
+// the index existed. The Colour~OwnerId~Id index already holds the owner of every car,
+// so it is used as the source instead of reading each car. Running it more than once is harmless.
+func (s *SmartContract) MigrateOwnerIndex(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := requireRole(ctx, roleAdmin)
+	if err != nil {
+		return 0, err
+	}
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(colourOwnerIndex, []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer iterator.Close()
+
+	migrated := 0
+	for iterator.HasNext() {
+		responseRange, err := iterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return 0, err
+		}
+
+		ownerKey, err := ownerIndexKey(ctx, &Car{OwnerId: compositeKeyParts[1], Id: compositeKeyParts[2]})
+		if err != nil {
+			return 0, err
+		}
+
+		existing, err := ctx.GetStub().GetState(ownerKey)
+		if err != nil {
+			return 0, err
+		}
+		if existing != nil {
+			continue
+		}
+
+		err = ctx.GetStub().PutState(ownerKey, indexValue)
+		if err != nil {
+			return 0, err
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// MigrateKeys moves cars and persons stored under bare keys (e.g. "car1", "person1")
+// into their typed composite key namespaces. Composite keys are never returned by
+// range queries, so only records that still need migrating are visited and
+// running it more than once is harmless.
+func (s *SmartContract) MigrateKeys(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := requireRole(ctx, roleAdmin)
+	if err != nil {
+		return 0, err
+	}
+
+	iterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return 0, err
+	}
+	defer iterator.Close()
+
+	migrated := 0
+	for iterator.HasNext() {
+		response, err := iterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var fields map[string]json.RawMessage
+		err = json.Unmarshal(response.Value, &fields)
+		if err != nil {
+			return 0, fmt.Errorf("failed to unmarshal JSON of %s: %v", response.Key, err)
+		}
+
+		var newKey string
+		if _, isCar := fields["OwnerId"]; isCar {
+			newKey, err = carKey(ctx, response.Key)
+		} else if _, isPerson := fields["Email"]; isPerson {
+			newKey, err = personKey(ctx, response.Key)
+		} else {
+			return 0, fmt.Errorf("unable to determine the type of %s", response.Key)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		err = ctx.GetStub().PutState(newKey, response.Value)
+		if err != nil {
+			return 0, err
+		}
+		err = ctx.GetStub().DelState(response.Key)
+		if err != nil {
+			return 0, err
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// MigrateMoney converts the float32 prices and balances of cars and persons written by
+// earlier versions of the contract into Money amounts in minor units. The stored decimal
+// text is converted with exact rational arithmetic, rounding half away from zero, so every
+// peer produces the same amounts. Records that were already migrated are left untouched.
+func (s *SmartContract) MigrateMoney(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := requireRole(ctx, roleAdmin)
+	if err != nil {
+		return 0, err
+	}
+
+	migratedCars, err := migrateNamespace(ctx, carObjectType, migrateCarMoney)
+	if err != nil {
+		return 0, err
+	}
+
+	migratedPersons, err := migrateNamespace(ctx, personObjectType, migratePersonMoney)
+	if err != nil {
+		return 0, err
+	}
+
+	return migratedCars + migratedPersons, nil
+}
+
+// MigrateMalfunctions gives the malfunctions written by earlier versions of the contract an
+// ID and the quoted status, since every one of them came with a repair price. Their reporter
+// and reporting time are unknown. Run it after MigrateMoney; cars that were already migrated
+// are left untouched.
+func (s *SmartContract) MigrateMalfunctions(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := requireRole(ctx, roleAdmin)
+	if err != nil {
+		return 0, err
+	}
+
+	return migrateNamespace(ctx, carObjectType, migrateCarMalfunctions)
+}
+
+// MigratePrivateData moves the details and balances of persons written by earlier versions
+// of the contract out of the world state into the collection of their organization. Persons
+// that do not belong to an organization yet are assigned to the submitter's. Run it after
+// MigrateMoney; persons that were already migrated are left untouched.
+func (s *SmartContract) MigratePrivateData(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := requireRole(ctx, roleAdmin)
+	if err != nil {
+		return 0, err
+	}
+
+	_, submitterMSPID, err := submittingClient(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(personObjectType, []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer iterator.Close()
+
+	migrated := 0
+	for iterator.HasNext() {
+		response, err := iterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var fields map[string]json.RawMessage
+		err = json.Unmarshal(response.Value, &fields)
+		if err != nil {
+			return 0, fmt.Errorf("failed to unmarshal JSON of %s: %v", response.Key, err)
+		}
+		if _, public := fields["Email"]; !public {
+			continue
+		}
+
+		var person Person
+		err = json.Unmarshal(response.Value, &person)
+		if err != nil {
+			return 0, fmt.Errorf("failed to migrate %s, run MigrateMoney first: %v", response.Key, err)
+		}
+		if person.MSPID == "" {
+			person.MSPID = submitterMSPID
+		}
+
+		err = putPerson(ctx, &person)
+		if err != nil {
+			return 0, err
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// MigrateJournal opens the journal of the persons of the submitter's organization that were
+// created before balances were journaled, with an entry for their current balance if it is
+// not zero. Only the submitter's organization can read the collection, so every organization
+// runs it once after MigratePrivateData; persons that already have a journal are left untouched.
+func (s *SmartContract) MigrateJournal(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := requireRole(ctx, roleAdmin)
+	if err != nil {
+		return 0, err
+	}
+
+	_, submitterMSPID, err := submittingClient(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(personObjectType, []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer iterator.Close()
+
+	migrated := 0
+	for iterator.HasNext() {
+		response, err := iterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var record personRecord
+		err = json.Unmarshal(response.Value, &record)
+		if err != nil {
+			return 0, fmt.Errorf("failed to unmarshal JSON of %s: %v", response.Key, err)
+		}
+		if record.MSPID != submitterMSPID {
+			continue
+		}
+
+		person, err := s.queryPersonRecord(ctx, record.Id)
+		if err != nil {
+			return 0, err
+		}
+
+		records, err := journalRecords(ctx, personCollection(person.MSPID), person.Id)
+		if err != nil {
+			return 0, err
+		}
+		if len(records) > 0 || person.Money.Amount == 0 {
+			continue
+		}
+
+		err = postEntry(ctx, cashAccount, person.Id, person.Money, "opening balance", person)
+		if err != nil {
+			return 0, err
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// migrateNamespace rewrites every record of the object type for which migrate reports a change
+func migrateNamespace(ctx contractapi.TransactionContextInterface, objectType string, migrate func(fields map[string]json.RawMessage) (bool, error)) (int, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer iterator.Close()
+
+	migrated := 0
+	for iterator.HasNext() {
+		response, err := iterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var fields map[string]json.RawMessage
+		err = json.Unmarshal(response.Value, &fields)
+		if err != nil {
+			return 0, fmt.Errorf("failed to unmarshal JSON of %s: %v", response.Key, err)
+		}
+
+		changed, err := migrate(fields)
+		if err != nil {
+			return 0, fmt.Errorf("failed to migrate %s: %v", response.Key, err)
+		}
+		if !changed {
+			continue
+		}
+
+		value, err := json.Marshal(fields)
+		if err != nil {
+			return 0, err
+		}
+
+		err = ctx.GetStub().PutState(response.Key, value)
+		if err != nil {
+			return 0, err
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+func migrateCarMoney(fields map[string]json.RawMessage) (bool, error) {
+	changed, err := migrateMoneyField(fields, "Price")
+	if err != nil {
+		return false, err
+	}
+
+	var malfunctions []map[string]json.RawMessage
+	if raw, ok := fields["MalfunctionList"]; ok {
+		err = json.Unmarshal(raw, &malfunctions)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	malfunctionsChanged := false
+	for _, malfunction := range malfunctions {
+		c, err := migrateMoneyField(malfunction, "RepairPrice")
+		if err != nil {
+			return false, err
+		}
+		malfunctionsChanged = malfunctionsChanged || c
+	}
+	if malfunctionsChanged {
+		fields["MalfunctionList"], err = json.Marshal(malfunctions)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return changed || malfunctionsChanged, nil
+}
+
+func migrateCarMalfunctions(fields map[string]json.RawMessage) (bool, error) {
+	raw, ok := fields["MalfunctionList"]
+	if !ok {
+		return false, nil
+	}
+
+	var malfunctions []map[string]json.RawMessage
+	err := json.Unmarshal(raw, &malfunctions)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	for i, malfunction := range malfunctions {
+		if _, ok := malfunction["Id"]; ok {
+			continue
+		}
+
+		malfunction["Id"], err = json.Marshal(malfunctionId(i))
+		if err != nil {
+			return false, err
+		}
+		malfunction["Status"], err = json.Marshal(malfunctionQuoted)
+		if err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	if !changed {
+		return false, nil
+	}
+
+	fields["MalfunctionList"], err = json.Marshal(malfunctions)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func migratePersonMoney(fields map[string]json.RawMessage) (bool, error) {
+	return migrateMoneyField(fields, "Money")
+}
+
+// migrateMoneyField replaces a legacy JSON number stored under name with a Money object
+func migrateMoneyField(fields map[string]json.RawMessage, name string) (bool, error) {
+	raw, ok := fields[name]
+	if !ok {
+		return false, nil
+	}
+
+	var number json.Number
+	if json.Unmarshal(raw, &number) != nil {
+		// not a number, the field already holds a Money object
+		return false, nil
+	}
+
+	amount, err := legacyAmountToMinorUnits(number)
+	if err != nil {
+		return false, err
+	}
+
+	fields[name], err = json.Marshal(NewMoney(amount, defaultCurrency))
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// legacyAmountToMinorUnits converts a decimal amount such as 8900.99 into 890099
+func legacyAmountToMinorUnits(number json.Number) (int64, error) {
+	amount, ok := new(big.Rat).SetString(number.String())
+	if !ok {
+		return 0, fmt.Errorf("invalid amount %s", number)
+	}
+	amount.Mul(amount, big.NewRat(100, 1))
+
+	quotient, remainder := new(big.Int).QuoRem(amount.Num(), amount.Denom(), new(big.Int))
+	// round half away from zero
+	if new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2)).Cmp(amount.Denom()) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(remainder.Sign())))
+	}
+
+	if !quotient.IsInt64() {
+		return 0, fmt.Errorf("amount %s is out of range", number)
+	}
+
+	return quotient.Int64(), nil
+}
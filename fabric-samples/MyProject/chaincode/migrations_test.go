@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateOwnerIndex(t *testing.T) {
+	colourOwnerKey, err := shim.CreateCompositeKey(colourOwnerIndex, []string{"blue", "person1", "car1"})
+	require.NoError(t, err)
+	transactionContext, stub := newTransactionContext()
+	err = stub.PutState(colourOwnerKey, indexValue)
+	require.NoError(t, err)
+
+	carContract := SmartContract{}
+	migrated, err := carContract.MigrateOwnerIndex(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 1, migrated)
+
+	ownerKey, err := shim.CreateCompositeKey(ownerIndex, []string{"person1", "car1"})
+	require.NoError(t, err)
+	value, err := stub.GetState(ownerKey)
+	require.NoError(t, err)
+	require.Equal(t, indexValue, value)
+
+	migrated, err = carContract.MigrateOwnerIndex(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 0, migrated)
+}
+
+func TestMigrateKeysAndMoney(t *testing.T) {
+	transactionContext, stub := newTransactionContext()
+	err := stub.PutState("car1", []byte(`{"Id":"car1","Brand":"Toyota","Colour":"blue","OwnerId":"person1","Price":100.5,"MalfunctionList":[{"Description":"Rusting","RepairPrice":40}]}`))
+	require.NoError(t, err)
+	err = stub.PutState("person1", []byte(`{"Id":"person1","Name":"Marco","Email":"polo@gmail.com","Money":3230.33}`))
+	require.NoError(t, err)
+
+	carContract := SmartContract{}
+	migrated, err := carContract.MigrateKeys(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 2, migrated)
+	for _, key := range []string{"car1", "person1"} {
+		value, err := stub.GetState(key)
+		require.NoError(t, err)
+		require.Nil(t, value)
+	}
+
+	migrated, err = carContract.MigrateMoney(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 2, migrated)
+
+	car, err := carContract.QueryCar(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(10050, defaultCurrency), car.Price)
+	require.Equal(t, []CarMalfunction{{Description: "Rusting", RepairPrice: NewMoney(4000, defaultCurrency)}}, car.MalfunctionList)
+
+	person, err := carContract.QueryPerson(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(323033, defaultCurrency), person.Money)
+
+	migrated, err = carContract.MigrateMoney(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 0, migrated)
+
+	migrated, err = carContract.MigrateMalfunctions(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 1, migrated)
+
+	car, err = carContract.QueryCar(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, []CarMalfunction{{Id: "m1", Description: "Rusting", Status: malfunctionQuoted, RepairPrice: NewMoney(4000, defaultCurrency)}}, car.MalfunctionList)
+
+	migrated, err = carContract.MigrateMalfunctions(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 0, migrated)
+
+	err = stub.PutState("garage1", []byte(`{"Id":"garage1"}`))
+	require.NoError(t, err)
+	_, err = carContract.MigrateKeys(transactionContext)
+	require.EqualError(t, err, "unable to determine the type of garage1")
+}
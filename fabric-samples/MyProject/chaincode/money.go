@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// defaultCurrency is used for amounts that were stored before currencies were recorded
+const defaultCurrency = "EUR"
+
+// Money is an amount in the minor unit of its currency, e.g. cents for EUR.
+// Balances are never kept as floating point numbers, so every endorsing peer
+// computes byte-for-byte identical results.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// NewMoney returns the amount given in minor units in the given currency
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Add returns the sum of both amounts. Both amounts must be in the same currency.
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, newError(codeCurrencyMismatch, "currency mismatch: %s and %s", m.Currency, other.Currency)
+	}
+	if (other.Amount > 0 && m.Amount > math.MaxInt64-other.Amount) || (other.Amount < 0 && m.Amount < math.MinInt64-other.Amount) {
+		return Money{}, newError(codeAmountOverflow, "amount overflow")
+	}
+
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+// Sub returns the difference of both amounts. Both amounts must be in the same currency.
+func (m Money) Sub(other Money) (Money, error) {
+	if other.Amount == math.MinInt64 {
+		return Money{}, newError(codeAmountOverflow, "amount overflow")
+	}
+
+	return m.Add(Money{Amount: -other.Amount, Currency: other.Currency})
+}
+
+// Cmp compares both amounts and returns -1, 0 or +1. Both amounts must be in the same currency.
+func (m Money) Cmp(other Money) (int, error) {
+	if m.Currency != other.Currency {
+		return 0, newError(codeCurrencyMismatch, "currency mismatch: %s and %s", m.Currency, other.Currency)
+	}
+
+	switch {
+	case m.Amount < other.Amount:
+		return -1, nil
+	case m.Amount > other.Amount:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+// String formats the amount with two decimal places, e.g. "100.50 EUR"
+func (m Money) String() string {
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	return fmt.Sprintf("%s%d.%02d %s", sign, amount/100, amount%100, m.Currency)
+}
+
+// totalRepairPrice sums the repair prices of the malfunctions that are not paid yet, i.e.
+// reported or quoted ones, in the given currency
+func totalRepairPrice(currency string, malfunctions []CarMalfunction) (Money, error) {
+	total := NewMoney(0, currency)
+	for _, malfunction := range malfunctions {
+		if !malfunction.unpaid() {
+			continue
+		}
+		var err error
+		total, err = total.Add(malfunction.RepairPrice)
+		if err != nil {
+			return Money{}, err
+		}
+	}
+
+	return total, nil
+}
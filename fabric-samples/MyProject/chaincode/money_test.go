@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMoneyArithmetic(t *testing.T) {
+	sum, err := NewMoney(1050, "EUR").Add(NewMoney(250, "EUR"))
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(1300, "EUR"), sum)
+
+	difference, err := NewMoney(1050, "EUR").Sub(NewMoney(2000, "EUR"))
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(-950, "EUR"), difference)
+	require.Equal(t, "-9.50 EUR", difference.String())
+
+	cmp, err := NewMoney(1050, "EUR").Cmp(NewMoney(250, "EUR"))
+	require.NoError(t, err)
+	require.Equal(t, 1, cmp)
+
+	_, err = NewMoney(1050, "EUR").Add(NewMoney(250, "USD"))
+	requireContractError(t, err, codeCurrencyMismatch, "currency mismatch: EUR and USD")
+
+	_, err = NewMoney(1050, "EUR").Cmp(NewMoney(250, "USD"))
+	requireContractError(t, err, codeCurrencyMismatch, "currency mismatch: EUR and USD")
+
+	_, err = NewMoney(math.MaxInt64, "EUR").Add(NewMoney(1, "EUR"))
+	requireContractError(t, err, codeAmountOverflow, "amount overflow")
+}
+
+func TestLegacyAmountToMinorUnits(t *testing.T) {
+	for number, expected := range map[string]int64{
+		"8900.99": 890099,
+		"100":     10000,
+		"0.1":     10,
+		"0.005":   1,
+		"-0.005":  -1,
+		"1e3":     100000,
+	} {
+		amount, err := legacyAmountToMinorUnits(json.Number(number))
+		require.NoError(t, err)
+		require.Equal(t, expected, amount, number)
+	}
+
+	_, err := legacyAmountToMinorUnits(json.Number("1e30"))
+	require.EqualError(t, err, "amount 1e30 is out of range")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// PaginatedQueryResult structure used for returning paginated query results and metadata
+type PaginatedQueryResult struct {
+	Records             []*Car
+	FetchedRecordsCount int32
+	Bookmark            string
+}
+
+// QueryAllCarsWithPagination returns a page of at most pageSize cars starting at the bookmark.
+// The bookmark of the returned page is passed in to fetch the next one, an empty bookmark
+// starts at the first car. Paginated queries are only valid for read only transactions.
+func (s *SmartContract) QueryAllCarsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(carObjectType, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	cars, err := carsFromIterator(resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PaginatedQueryResult{
+		Records:             cars,
+		FetchedRecordsCount: responseMetadata.FetchedRecordsCount,
+		Bookmark:            responseMetadata.Bookmark,
+	}, nil
+}
+
+// QueryCarsByColorWithPagination returns a page of the cars of the given colour
+func (s *SmartContract) QueryCarsByColorWithPagination(ctx contractapi.TransactionContextInterface, color string, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	return s.queryCarsByIndexWithPagination(ctx, colourOwnerIndex, []string{color}, pageSize, bookmark)
+}
+
+// QueryCarsByOwnerWithPagination returns a page of the cars owned by the person with given id
+func (s *SmartContract) QueryCarsByOwnerWithPagination(ctx contractapi.TransactionContextInterface, OwnerId string, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	return s.queryCarsByIndexWithPagination(ctx, ownerIndex, []string{OwnerId}, pageSize, bookmark)
+}
+
+// QueryCarsByColorAndOwnerWithPagination returns a page of the cars of the given colour owned by the person with given id
+func (s *SmartContract) QueryCarsByColorAndOwnerWithPagination(ctx contractapi.TransactionContextInterface, Colour string, OwnerId string, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	return s.queryCarsByIndexWithPagination(ctx, colourOwnerIndex, []string{Colour, OwnerId}, pageSize, bookmark)
+}
+
+// queryCarsByIndexWithPagination pages through the index entries matching the partial key
+// and looks up the cars they reference
+func (s *SmartContract) queryCarsByIndexWithPagination(ctx contractapi.TransactionContextInterface, indexName string, attributes []string, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	iterator, responseMetadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(indexName, attributes, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	cars, err := s.carsFromIndexIterator(ctx, iterator)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PaginatedQueryResult{
+		Records:             cars,
+		FetchedRecordsCount: responseMetadata.FetchedRecordsCount,
+		Bookmark:            responseMetadata.Bookmark,
+	}, nil
+}
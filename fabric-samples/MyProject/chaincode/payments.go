@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// The endorsing peers of a transaction can read only the collection of their own
+// organization. Money owed to other persons than the parties of the transaction, e.g. the
+// refunds of the other offers when a car is sold, is therefore recorded in the world state
+// as a Payment, which holds no more than the amount and its reason. The person collects it
+// with CollectPayments on the peers of the person's own organization.
+
+// Payment is an amount owed to the payee by the transaction TxId, which moves it from the
+// Debit account to the payee
+type Payment struct {
+	PayeeId   string
+	Debit     string
+	Amount    Money
+	Memo      string
+	TxId      string
+	Timestamp time.Time
+}
+
+// CollectPayments credits the balance of the person with all payments owed to the person
+// and returns their number
+func (s *SmartContract) CollectPayments(ctx contractapi.TransactionContextInterface, personId string) (int, error) {
+	person, err := s.queryPersonRecord(ctx, personId)
+	if err != nil {
+		return 0, err
+	}
+
+	err = authorizePerson(ctx, person)
+	if err != nil {
+		return 0, err
+	}
+
+	payments, keys, err := readPayments(ctx, personId)
+	if err != nil {
+		return 0, err
+	}
+	if len(payments) == 0 {
+		return 0, nil
+	}
+
+	// the payments out of the same account are posted together, as a transaction posts at
+	// most one amount between two accounts
+	totals := map[string]Money{}
+	memos := map[string][]string{}
+	accounts := []string{}
+	for i, payment := range payments {
+		total, ok := totals[payment.Debit]
+		if !ok {
+			total = NewMoney(0, payment.Amount.Currency)
+			accounts = append(accounts, payment.Debit)
+		}
+		totals[payment.Debit], err = total.Add(payment.Amount)
+		if err != nil {
+			return 0, err
+		}
+		memos[payment.Debit] = append(memos[payment.Debit], payment.Memo)
+
+		err = ctx.GetStub().DelState(keys[i])
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	before := *person
+	sort.Strings(accounts)
+	for _, account := range accounts {
+		err = payOut(ctx, account, person, totals[account], strings.Join(memos[account], "; "))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = putPerson(ctx, person)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(payments), emitPersonEvent(ctx, paymentsCollectedEvent, &before, person)
+}
+
+// QueryPayments returns the payments owed to the person that have not been collected yet
+func (s *SmartContract) QueryPayments(ctx contractapi.TransactionContextInterface, personId string) ([]*Payment, error) {
+	person, err := readPerson(ctx, personId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = administerPerson(ctx, person)
+	if err != nil {
+		return nil, err
+	}
+
+	payments, _, err := readPayments(ctx, personId)
+	return payments, err
+}
+
+// owePayment records that the transaction owes the amount from the debit account to the payee
+func owePayment(ctx contractapi.TransactionContextInterface, payeeId string, debit string, amount Money, memo string) error {
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	payment := &Payment{
+		PayeeId:   payeeId,
+		Debit:     debit,
+		Amount:    amount,
+		Memo:      memo,
+		TxId:      ctx.GetStub().GetTxID(),
+		Timestamp: timestamp,
+	}
+
+	key, err := paymentKey(ctx, payeeId, payment.TxId)
+	if err != nil {
+		return err
+	}
+
+	paymentAsBytes, err := json.Marshal(payment)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, paymentAsBytes)
+}
+
+// readPayments reads the payments owed to the person in the order of their keys together
+// with the keys
+func readPayments(ctx contractapi.TransactionContextInterface, personId string) ([]*Payment, []string, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(paymentObjectType, []string{personId})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer iterator.Close()
+
+	payments := []*Payment{}
+	keys := []string{}
+	for iterator.HasNext() {
+		response, err := iterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		payment := new(Payment)
+		err = json.Unmarshal(response.Value, payment)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+		}
+		payments = append(payments, payment)
+		keys = append(keys, response.Key)
+	}
+
+	return payments, keys, nil
+}
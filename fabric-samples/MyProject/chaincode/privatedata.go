@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// The personal details and the balance of a person are kept in the private data collection
+// of the person's organization, e.g. Org1MSPPrivateCollection of collections_config.json.
+// The world state holds only the public part of the person and the channel only the hash
+// of the private part.
+
+// personTransientKey is the transient field holding the PersonDetails of CreatePerson and
+// UpdatePerson, so that they never appear in the transaction arguments
+const personTransientKey = "person"
+
+// PersonDetails is the private part of a person
+type PersonDetails struct {
+	Name    string
+	Surname string
+	Email   string
+	Money   Money
+}
+
+// personRecord is the public part of a person stored in the world state
+type personRecord struct {
+	Id           string
+	ClientId     string
+	MSPID        string
+	TokenAccount string `json:",omitempty"`
+}
+
+// personCollection returns the private data collection of the organization
+func personCollection(mspId string) string {
+	return mspId + "PrivateCollection"
+}
+
+// detailsTransientKey is the transient field through which the submitter passes the details
+// of a person of another organization, e.g. details:person2
+func detailsTransientKey(personId string) string {
+	return "details:" + personId
+}
+
+// readPersonDetails returns the details of the person or nil if there are none. Peers of other
+// organizations cannot read the collection of the person, e.g. the seller's peers during
+// ChangeOwner, so the submitter passes the details in the transient field details:<id>.
+// Either way the details are accepted only if their hash equals the hash on the channel,
+// so neither a submitter nor a peer whose copy of the collection was altered can change a
+// balance before money moves.
+func readPersonDetails(ctx contractapi.TransactionContextInterface, person *Person) (*PersonDetails, error) {
+	key, err := personKey(ctx, person.Id)
+	if err != nil {
+		return nil, err
+	}
+	collection := personCollection(person.MSPID)
+
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the transient map. %v", err)
+	}
+	if detailsAsBytes, ok := transient[detailsTransientKey(person.Id)]; ok {
+		return verifyPersonDetails(ctx, collection, key, person.Id, detailsAsBytes)
+	}
+
+	detailsAsBytes, err := ctx.GetStub().GetPrivateData(collection, key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from collection %s. %v", collection, err)
+	}
+	if detailsAsBytes == nil {
+		return nil, nil
+	}
+	err = requireAnchoredHash(ctx, collection, key, person.Id, detailsAsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	details := new(PersonDetails)
+	err = json.Unmarshal(detailsAsBytes, details)
+	if err != nil {
+		return nil, err
+	}
+
+	return details, nil
+}
+
+// addPersonDetails completes the public part of the person read from the world state with
+// the details, if there are any
+func addPersonDetails(ctx contractapi.TransactionContextInterface, person *Person) error {
+	details, err := readPersonDetails(ctx, person)
+	if err != nil {
+		return err
+	}
+	if details != nil {
+		person.Name = details.Name
+		person.Surname = details.Surname
+		person.Email = details.Email
+		person.Money = details.Money
+	}
+
+	return nil
+}
+
+// verifyPersonDetails checks details passed by the submitter against the hash of the details
+// in the collection. The details are compared in the encoding written by putPersonDetails,
+// so the order and spacing of the submitted JSON do not matter.
+func verifyPersonDetails(ctx contractapi.TransactionContextInterface, collection string, key string, personId string, detailsAsBytes []byte) (*PersonDetails, error) {
+	details := new(PersonDetails)
+	err := json.Unmarshal(detailsAsBytes, details)
+	if err != nil {
+		return nil, newError(codeInvalidArgument, "the details of %s are not valid JSON", personId)
+	}
+
+	canonical, err := json.Marshal(details)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requireAnchoredHash(ctx, collection, key, personId, canonical)
+	if err != nil {
+		return nil, err
+	}
+
+	return details, nil
+}
+
+// requireAnchoredHash checks that the hash of the details equals the hash of the key that
+// the channel holds for the collection
+func requireAnchoredHash(ctx contractapi.TransactionContextInterface, collection string, key string, personId string, detailsAsBytes []byte) error {
+	hash, err := ctx.GetStub().GetPrivateDataHash(collection, key)
+	if err != nil {
+		return fmt.Errorf("Failed to read the hash from collection %s. %v", collection, err)
+	}
+	if hash == nil {
+		return newError(codeNotFound, "the details of %s do not exist", personId)
+	}
+
+	actual := sha256.Sum256(detailsAsBytes)
+	if !bytes.Equal(hash, actual[:]) {
+		return newError(codeHashMismatch, "the details of %s do not match the hash on the channel", personId)
+	}
+
+	return nil
+}
+
+// putPersonDetails writes the details of the person to the collection of its organization
+func putPersonDetails(ctx contractapi.TransactionContextInterface, person *Person) error {
+	if person.MSPID == "" {
+		return fmt.Errorf("the person %s does not belong to an organization", person.Id)
+	}
+
+	key, err := personKey(ctx, person.Id)
+	if err != nil {
+		return err
+	}
+
+	detailsAsBytes, err := json.Marshal(PersonDetails{
+		Name:    person.Name,
+		Surname: person.Surname,
+		Email:   person.Email,
+		Money:   person.Money,
+	})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutPrivateData(personCollection(person.MSPID), key, detailsAsBytes)
+}
+
+// purgePersonDetails removes the details of the person from the collection including their
+// history, so that no peer keeps the personal data of a deleted person
+func purgePersonDetails(ctx contractapi.TransactionContextInterface, person *Person) error {
+	key, err := personKey(ctx, person.Id)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PurgePrivateData(personCollection(person.MSPID), key)
+}
+
+// transientPersonDetails reads the details passed to CreatePerson or UpdatePerson
+func transientPersonDetails(ctx contractapi.TransactionContextInterface) (*PersonDetails, error) {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the transient map. %v", err)
+	}
+
+	detailsAsBytes, ok := transient[personTransientKey]
+	if !ok {
+		return nil, newError(codeInvalidArgument, "the person details must be passed in the transient field %s", personTransientKey)
+	}
+
+	details := new(PersonDetails)
+	err = json.Unmarshal(detailsAsBytes, details)
+	if err != nil {
+		return nil, newError(codeInvalidArgument, "the person details are not valid JSON")
+	}
+
+	return details, nil
+}
+
+// publicPerson returns the public part of the person, e.g. for events, which every member
+// of the channel receives
+func publicPerson(person *Person) *Person {
+	if person == nil {
+		return nil
+	}
+	return &Person{Id: person.Id, ClientId: person.ClientId, MSPID: person.MSPID, TokenAccount: person.TokenAccount}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const org1Collection = "Org1MSPPrivateCollection"
+
+func TestPersonDetailsArePrivate(t *testing.T) {
+	transactionContext, stub := newTransactionContext()
+
+	carContract := SmartContract{}
+	err := carContract.CreatePerson(transactionContext, "person4")
+	requireContractError(t, err, codeInvalidArgument, "the person details must be passed in the transient field person")
+
+	withPersonDetails(t, transactionContext, PersonDetails{Name: "Nikola", Surname: "Tesla", Email: "tesla@gmail.com", Money: NewMoney(100, defaultCurrency)})
+	err = carContract.CreatePerson(transactionContext, "person4")
+	require.NoError(t, err)
+
+	key, err := personKey(transactionContext, "person4")
+	require.NoError(t, err)
+
+	public, err := stub.GetState(key)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"Id":"person4","ClientId":"`+adminClientId+`","MSPID":"Org1MSP"}`, string(public))
+
+	private, err := stub.GetPrivateData(org1Collection, key)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"Name":"Nikola","Surname":"Tesla","Email":"tesla@gmail.com","Money":{"Amount":100,"Currency":"EUR"}}`, string(private))
+
+	person, err := carContract.QueryPerson(transactionContext, "person4")
+	require.NoError(t, err)
+	require.Equal(t, "tesla@gmail.com", person.Email)
+	require.Equal(t, NewMoney(100, defaultCurrency), person.Money)
+
+	// deleting a person purges the details from the collection
+	err = carContract.DeletePerson(transactionContext, "person4")
+	require.NoError(t, err)
+
+	private, err = stub.GetPrivateData(org1Collection, key)
+	require.NoError(t, err)
+	require.Nil(t, private)
+}
+
+func TestChangeOwnerVerifiesDetailsOfOtherOrganizations(t *testing.T) {
+	transactionContext, stub := newInitializedLedger(t)
+	carContract := SmartContract{}
+
+	org2Admin := newClientIdentity(adminClientId, roleAdmin)
+	org2Admin.GetMSPIDReturns("Org2MSP", nil)
+	transactionContext.GetClientIdentityReturns(org2Admin)
+	withPersonDetails(t, transactionContext, PersonDetails{Name: "Nikola", Surname: "Tesla", Email: "tesla@gmail.com", Money: NewMoney(50000, defaultCurrency)})
+	err := carContract.CreatePerson(transactionContext, "person4")
+	require.NoError(t, err)
+
+	// a peer of Org1 cannot read the collection of Org2, so the submitter passes the details
+	transactionContext.GetClientIdentityReturns(newClientIdentity(adminClientId, roleAdmin))
+	forged, err := json.Marshal(PersonDetails{Name: "Nikola", Surname: "Tesla", Email: "tesla@gmail.com", Money: NewMoney(9000000, defaultCurrency)})
+	require.NoError(t, err)
+	stub.SetTransient(map[string][]byte{detailsTransientKey("person4"): forged})
+	err = carContract.ChangeOwner(transactionContext, "car5", "person4", true)
+	requireContractError(t, err, codeHashMismatch, "the details of person4 do not match the hash on the channel")
+
+	// the order of the fields does not matter
+	stub.SetTransient(map[string][]byte{detailsTransientKey("person4"): []byte(
+		`{"Money":{"Currency":"EUR","Amount":50000},"Email":"tesla@gmail.com","Surname":"Tesla","Name":"Nikola"}`)})
+	err = carContract.ChangeOwner(transactionContext, "car5", "person4", true)
+	require.NoError(t, err)
+
+	key, err := personKey(transactionContext, "person4")
+	require.NoError(t, err)
+	private, err := stub.GetPrivateData("Org2MSPPrivateCollection", key)
+	require.NoError(t, err)
+	require.Contains(t, string(private), `"Money":{"Amount":2500,"Currency":"EUR"}`)
+}
+
+func TestChangeOwnerRejectsTamperedDetails(t *testing.T) {
+	transactionContext, stub := newBoundLedger(t)
+	carContract := SmartContract{}
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+
+	// the copy of the collection on the peer no longer matches the hash on the channel
+	for _, personId := range []string{"person2", "person1"} {
+		key, err := personKey(transactionContext, personId)
+		require.NoError(t, err)
+		original, err := stub.GetPrivateData(org1Collection, key)
+		require.NoError(t, err)
+
+		tampered, err := json.Marshal(PersonDetails{Name: "Forged", Money: NewMoney(9000000, defaultCurrency)})
+		require.NoError(t, err)
+		stub.TamperPrivateData(org1Collection, key, tampered)
+
+		err = carContract.ChangeOwner(transactionContext, "car1", "person2", true)
+		requireContractError(t, err, codeHashMismatch, "the details of "+personId+" do not match the hash on the channel")
+
+		stub.TamperPrivateData(org1Collection, key, original)
+	}
+
+	// no money moved
+	requireBalance(t, transactionContext, "person1", 890099)
+	requireBalance(t, transactionContext, "person2", 323033)
+
+	err := carContract.ChangeOwner(transactionContext, "car1", "person2", true)
+	require.NoError(t, err)
+}
+
+func TestMigratePrivateData(t *testing.T) {
+	transactionContext, stub := newTransactionContext()
+
+	key, err := personKey(transactionContext, "person1")
+	require.NoError(t, err)
+	err = stub.PutState(key, []byte(`{"Id":"person1","Name":"Jean-Jacques","Surname":"Rousseau","Email":"rousseau@gmail.com","Money":{"Amount":890099,"Currency":"EUR"}}`))
+	require.NoError(t, err)
+
+	carContract := SmartContract{}
+	migrated, err := carContract.MigratePrivateData(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 1, migrated)
+
+	public, err := stub.GetState(key)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"Id":"person1","ClientId":"","MSPID":"Org1MSP"}`, string(public))
+
+	person, err := carContract.QueryPerson(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, "rousseau@gmail.com", person.Email)
+	require.Equal(t, NewMoney(890099, defaultCurrency), person.Money)
+
+	migrated, err = carContract.MigratePrivateData(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 0, migrated)
+}
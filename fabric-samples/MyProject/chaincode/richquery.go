@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// QueryCars uses a query string to perform a rich query for cars, e.g.
+// {"selector":{"Brand":"Toyota","Year":{"$gte":2000}}}
+// Query string matching state database syntax is passed in and executed as is.
+// Only records stored in the car namespace are returned, so a selector does not
+// have to tell cars and persons apart.
+// Only available on state databases that support rich query (e.g. CouchDB).
+// The indexes packaged in META-INF/statedb/couchdb/indexes cover queries by
+// brand and model, year and price.
+func (s *SmartContract) QueryCars(ctx contractapi.TransactionContextInterface, queryString string) ([]*Car, error) {
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	retList := []*Car{}
+
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		// keys written before MigrateKeys are not composite keys, and the shim panics
+		// splitting them
+		if !strings.HasPrefix(response.Key, "\x00") {
+			continue
+		}
+		objectType, _, err := ctx.GetStub().SplitCompositeKey(response.Key)
+		if err != nil || objectType != carObjectType {
+			continue
+		}
+
+		var car *Car
+		err = json.Unmarshal(response.Value, &car)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+		}
+
+		retList = append(retList, car)
+	}
+
+	return retList, nil
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// A car changes hands in two phases, so that neither side can force the sale: the owner
+// lists the car with an asking price, buyers make offers and the owner transfers the car
+// to a buyer whose offer matches the asking price and has not expired. Buyers escrow the
+// price of their offer, which pays for the car or is refunded when the offer ends. Offers
+// refunded by another transaction than CancelOffer are owed to the buyer as payments.
+// Buyers linked to a token account escrow nothing and pay in tokens when the car is
+// transferred.
+
+// maxOfferValidity is the longest time an offer may be valid for
+const maxOfferValidity = 30 * 24 * time.Hour
+
+// SaleListing is the owner's agreement to sell the car at the asking price
+type SaleListing struct {
+	CarId       string
+	SellerId    string
+	AskingPrice Money
+	ListedAt    time.Time
+}
+
+// Offer is the buyer's agreement to pay the price until the offer expires. The price stays
+// in escrow until the car is sold or the offer is refunded, even once the offer expired,
+// unless the offer is paid InTokens.
+type Offer struct {
+	CarId     string
+	BuyerId   string
+	Price     Money
+	OfferedAt time.Time
+	ExpiresAt time.Time
+	InTokens  bool `json:",omitempty"`
+}
+
+// Sale is a listed car with the offers made for it, as returned by QuerySale
+type Sale struct {
+	Listing *SaleListing
+	Offers  []*Offer
+}
+
+// ListCarForSale lists the car for sale at the asking price in minor units of the car's
+// currency. Listing a car again changes the asking price.
+func (s *SmartContract) ListCarForSale(ctx contractapi.TransactionContextInterface, carId string, askingPrice int64) error {
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	err = requireInService(car)
+	if err != nil {
+		return err
+	}
+
+	err = s.authorizeOwner(ctx, car)
+	if err != nil {
+		return err
+	}
+
+	if askingPrice <= 0 {
+		return newError(codeInvalidArgument, "the asking price must be greater than zero")
+	}
+
+	err = requireNoAuction(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	listedAt, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	listing := &SaleListing{
+		CarId:       carId,
+		SellerId:    car.OwnerId,
+		AskingPrice: NewMoney(askingPrice, car.Price.Currency),
+		ListedAt:    listedAt,
+	}
+
+	err = putListing(ctx, listing)
+	if err != nil {
+		return err
+	}
+
+	return emitSaleEvent(ctx, carListedEvent, listing, nil)
+}
+
+// CancelSale withdraws the car from sale and refunds all offers made for it as payments
+func (s *SmartContract) CancelSale(ctx contractapi.TransactionContextInterface, carId string) error {
+	listing, err := s.queryListing(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	err = s.authorizeOwner(ctx, car)
+	if err != nil {
+		return err
+	}
+
+	_, err = deleteSale(ctx, carId, "")
+	if err != nil {
+		return err
+	}
+
+	return emitSaleEvent(ctx, saleCancelledEvent, listing, nil)
+}
+
+// MakeOffer offers to buy the listed car for the price in minor units of the car's currency.
+// The offer expires validForSeconds after the transaction, at most 30 days. The price moves
+// from the buyer's balance into escrow unless the buyer is linked to a token account. A new
+// offer of the same buyer replaces the previous one and its escrow.
+func (s *SmartContract) MakeOffer(ctx contractapi.TransactionContextInterface, carId string, buyerId string, price int64, validForSeconds int64) error {
+	listing, err := s.queryListing(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	buyer, err := s.queryPersonRecord(ctx, buyerId)
+	if err != nil {
+		return err
+	}
+
+	err = authorizePerson(ctx, buyer)
+	if err != nil {
+		return err
+	}
+
+	if buyerId == listing.SellerId {
+		return newError(codeAlreadyOwner, "the person %s already owns the car %s", buyerId, carId)
+	}
+	if price <= 0 {
+		return newError(codeInvalidArgument, "the price must be greater than zero")
+	}
+	if validForSeconds <= 0 {
+		return newError(codeInvalidArgument, "the offer must be valid for at least one second")
+	}
+	if validForSeconds > int64(maxOfferValidity/time.Second) {
+		return newError(codeInvalidArgument, "the offer must be valid for at most %d seconds", int64(maxOfferValidity/time.Second))
+	}
+
+	offeredAt, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	offer := &Offer{
+		CarId:     carId,
+		BuyerId:   buyerId,
+		Price:     NewMoney(price, listing.AskingPrice.Currency),
+		OfferedAt: offeredAt,
+		ExpiresAt: offeredAt.Add(time.Duration(validForSeconds) * time.Second),
+		InTokens:  buyer.TokenAccount != "",
+	}
+
+	previous, err := readOffer(ctx, carId, buyerId)
+	if err != nil {
+		return err
+	}
+
+	err = escrowOffer(ctx, buyer, previous, offer)
+	if err != nil {
+		return err
+	}
+
+	err = putOffer(ctx, offer)
+	if err != nil {
+		return err
+	}
+
+	return emitSaleEvent(ctx, offerMadeEvent, listing, offer)
+}
+
+// CancelOffer withdraws the offer of the buyer and refunds its escrow
+func (s *SmartContract) CancelOffer(ctx contractapi.TransactionContextInterface, carId string, buyerId string) error {
+	offer, err := s.queryOffer(ctx, carId, buyerId)
+	if err != nil {
+		return err
+	}
+
+	buyer, err := s.queryPersonRecord(ctx, buyerId)
+	if err != nil {
+		return err
+	}
+
+	err = authorizePerson(ctx, buyer)
+	if err != nil {
+		return err
+	}
+
+	err = escrowOffer(ctx, buyer, offer, nil)
+	if err != nil {
+		return err
+	}
+
+	key, err := offerKey(ctx, carId, buyerId)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return err
+	}
+
+	return emitSaleEvent(ctx, offerCancelledEvent, nil, offer)
+}
+
+// TransferCar sells the listed car to the buyer. The seller submits the transfer, which
+// succeeds only when the buyer's offer matches the asking price and has not expired. The
+// seller is paid out of the escrow of the offer, or in the buyer's tokens for offers paid in
+// tokens, and the other offers are refunded, so the balance of the buyer, which may belong
+// to another organization, is not read.
+func (s *SmartContract) TransferCar(ctx contractapi.TransactionContextInterface, carId string, buyerId string) error {
+	listing, err := s.queryListing(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	offer, err := s.queryOffer(ctx, carId, buyerId)
+	if err != nil {
+		return err
+	}
+
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	seller, err := s.queryPersonRecord(ctx, car.OwnerId)
+	if err != nil {
+		return err
+	}
+
+	err = authorizePerson(ctx, seller)
+	if err != nil {
+		return err
+	}
+
+	err = requireNoAuction(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	if !now.Before(offer.ExpiresAt) {
+		return newError(codeOfferExpired, "the offer of %s for the car %s expired at %s", buyerId, carId, offer.ExpiresAt.Format(time.RFC3339))
+	}
+
+	cmp, err := offer.Price.Cmp(listing.AskingPrice)
+	if err != nil {
+		return err
+	}
+	if cmp != 0 {
+		return newError(codePriceMismatch, "the offer of %s does not match the asking price of %s", offer.Price, listing.AskingPrice)
+	}
+
+	buyer, err := readPerson(ctx, buyerId)
+	if err != nil {
+		return err
+	}
+
+	_, err = deleteSale(ctx, carId, buyerId)
+	if err != nil {
+		return err
+	}
+
+	if offer.InTokens {
+		err = payTokens(ctx, buyer, seller, offer.Price)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = payOut(ctx, escrowAccount, seller, offer.Price, "sale of "+carId)
+		if err != nil {
+			return err
+		}
+
+		err = putPerson(ctx, seller)
+		if err != nil {
+			return err
+		}
+	}
+
+	return handOverCar(ctx, car, buyerId, offer.Price)
+}
+
+// QuerySale returns the listing of the car and all offers made for it, including expired ones
+func (s *SmartContract) QuerySale(ctx contractapi.TransactionContextInterface, carId string) (*Sale, error) {
+	listing, err := s.queryListing(ctx, carId)
+	if err != nil {
+		return nil, err
+	}
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(offerObjectType, []string{carId})
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	sale := &Sale{Listing: listing, Offers: []*Offer{}}
+	for iterator.HasNext() {
+		response, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var offer *Offer
+		err = json.Unmarshal(response.Value, &offer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+		}
+		sale.Offers = append(sale.Offers, offer)
+	}
+
+	return sale, nil
+}
+
+// queryListing reads the listing of the car
+func (s *SmartContract) queryListing(ctx contractapi.TransactionContextInterface, carId string) (*SaleListing, error) {
+	key, err := saleKey(ctx, carId)
+	if err != nil {
+		return nil, err
+	}
+
+	listingAsBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+	if listingAsBytes == nil {
+		return nil, newError(codeNotFound, "the car %s is not listed for sale", carId)
+	}
+
+	listing := new(SaleListing)
+	err = json.Unmarshal(listingAsBytes, listing)
+	if err != nil {
+		return nil, err
+	}
+
+	return listing, nil
+}
+
+// queryOffer reads the offer of the buyer for the car
+func (s *SmartContract) queryOffer(ctx contractapi.TransactionContextInterface, carId string, buyerId string) (*Offer, error) {
+	offer, err := readOffer(ctx, carId, buyerId)
+	if err != nil {
+		return nil, err
+	}
+	if offer == nil {
+		return nil, newError(codeNotFound, "%s has not made an offer for the car %s", buyerId, carId)
+	}
+
+	return offer, nil
+}
+
+// escrowOffer refunds the escrow of the previous offer of the buyer and escrows the price of
+// the next one. Either is nil when the buyer makes the first offer or cancels it. Offers paid
+// in tokens have no escrow.
+func escrowOffer(ctx contractapi.TransactionContextInterface, buyer *Person, previous *Offer, next *Offer) error {
+	if previous != nil && previous.InTokens {
+		previous = nil
+	}
+	if next != nil && next.InTokens {
+		next = nil
+	}
+	if previous == nil && next == nil {
+		return nil
+	}
+
+	if previous != nil {
+		err := payOut(ctx, escrowAccount, buyer, previous.Price, "refund of the offer for "+previous.CarId)
+		if err != nil {
+			return err
+		}
+	}
+
+	if next != nil {
+		cmp, err := buyer.Money.Cmp(next.Price)
+		if err != nil {
+			return err
+		}
+		if cmp < 0 {
+			return newError(codeInsufficientFunds, "the balance of %s is too low to offer %s", buyer.Id, next.Price)
+		}
+
+		err = payIn(ctx, buyer, escrowAccount, next.Price, "offer for "+next.CarId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return putPerson(ctx, buyer)
+}
+
+// readOffer reads the offer of the buyer for the car or returns nil if there is none
+func readOffer(ctx contractapi.TransactionContextInterface, carId string, buyerId string) (*Offer, error) {
+	key, err := offerKey(ctx, carId, buyerId)
+	if err != nil {
+		return nil, err
+	}
+
+	offerAsBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+	if offerAsBytes == nil {
+		return nil, nil
+	}
+
+	offer := new(Offer)
+	err = json.Unmarshal(offerAsBytes, offer)
+	if err != nil {
+		return nil, err
+	}
+
+	return offer, nil
+}
+
+// requireNotForSale fails while the car is listed for sale, so that it cannot be auctioned
+// at the same time
+func requireNotForSale(ctx contractapi.TransactionContextInterface, carId string) error {
+	key, err := saleKey(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	listingAsBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+	if listingAsBytes != nil {
+		return newError(codeInvalidState, "the car %s is listed for sale", carId)
+	}
+
+	return nil
+}
+
+// putListing writes the listing to the world state under its typed key
+func putListing(ctx contractapi.TransactionContextInterface, listing *SaleListing) error {
+	key, err := saleKey(ctx, listing.CarId)
+	if err != nil {
+		return err
+	}
+
+	listingAsBytes, err := json.Marshal(listing)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, listingAsBytes)
+}
+
+// putOffer writes the offer to the world state under its typed key
+func putOffer(ctx contractapi.TransactionContextInterface, offer *Offer) error {
+	key, err := offerKey(ctx, offer.CarId, offer.BuyerId)
+	if err != nil {
+		return err
+	}
+
+	offerAsBytes, err := json.Marshal(offer)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, offerAsBytes)
+}
+
+// deleteSale removes the listing of the car and all offers made for it, if there are any.
+// The escrowed offers are refunded as payments except the offer of buyerId, if not empty,
+// which is returned so that the caller settles its escrow.
+func deleteSale(ctx contractapi.TransactionContextInterface, carId string, buyerId string) (*Offer, error) {
+	key, err := saleKey(ctx, carId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return nil, err
+	}
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(offerObjectType, []string{carId})
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	var accepted *Offer
+	for iterator.HasNext() {
+		response, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		offer := new(Offer)
+		err = json.Unmarshal(response.Value, offer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+		}
+		if offer.BuyerId == buyerId {
+			accepted = offer
+		} else if !offer.InTokens {
+			err = owePayment(ctx, offer.BuyerId, escrowAccount, offer.Price, "refund of the offer for "+carId)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = ctx.GetStub().DelState(response.Key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return accepted, nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/first-blockchain/golang-blockchain/mocks"
+	"github.com/stretchr/testify/require"
+)
+
+// requireBalance asserts the balance of the person in minor units of the default currency
+func requireBalance(t *testing.T, transactionContext *mocks.TransactionContext, personId string, amount int64) {
+	person, err := new(SmartContract).queryPersonRecord(transactionContext, personId)
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(amount, defaultCurrency), person.Money)
+}
+
+func TestSale(t *testing.T) {
+	transactionContext, stub := newBoundLedger(t)
+	carContract := SmartContract{}
+	listed := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	stub.StartTx("tx2", listed)
+	err := carContract.ListCarForSale(transactionContext, "car1", 15000)
+	require.NoError(t, err)
+	require.Equal(t, "CarListed", stub.Event().EventName)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	stub.StartTx("tx3", listed.Add(time.Minute))
+	err = carContract.MakeOffer(transactionContext, "car1", "person2", 15000, 3600)
+	require.NoError(t, err)
+
+	var event SaleEvent
+	require.Equal(t, "OfferMade", stub.Event().EventName)
+	err = json.Unmarshal(stub.Event().Payload, &event)
+	require.NoError(t, err)
+	require.Equal(t, "car1", event.CarId)
+	require.Equal(t, listed.Add(time.Hour+time.Minute), event.Offer.ExpiresAt)
+
+	sale, err := carContract.QuerySale(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, &SaleListing{CarId: "car1", SellerId: "person1", AskingPrice: NewMoney(15000, defaultCurrency), ListedAt: listed}, sale.Listing)
+	require.Len(t, sale.Offers, 1)
+
+	// only the seller completes the sale
+	err = carContract.TransferCar(transactionContext, "car1", "person2")
+	requireContractError(t, err, codeForbidden, "submitting client not authorized to act for person1")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	stub.StartTx("tx4", listed.Add(time.Hour))
+	err = carContract.TransferCar(transactionContext, "car1", "person2")
+	require.NoError(t, err)
+	require.Equal(t, "CarTransferred", stub.Event().EventName)
+
+	car, err := carContract.QueryCar(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, "person2", car.OwnerId)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(adminClientId, roleAdmin))
+	buyer, err := carContract.QueryPerson(transactionContext, "person2")
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(308033, defaultCurrency), buyer.Money)
+
+	seller, err := carContract.QueryPerson(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(905099, defaultCurrency), seller.Money)
+
+	// the transfer ends the sale
+	_, err = carContract.QuerySale(transactionContext, "car1")
+	requireContractError(t, err, codeNotFound, "the car car1 is not listed for sale")
+	require.Empty(t, indexedCarIds(t, stub, offerObjectType))
+}
+
+func TestSaleRequiresMatchingOffer(t *testing.T) {
+	transactionContext, stub := newBoundLedger(t)
+	carContract := SmartContract{}
+	listed := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	err := carContract.ListCarForSale(transactionContext, "car1", 15000)
+	requireContractError(t, err, codeForbidden, "submitting client not authorized to act for person1")
+	err = carContract.MakeOffer(transactionContext, "car1", "person2", 15000, 3600)
+	requireContractError(t, err, codeNotFound, "the car car1 is not listed for sale")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	stub.StartTx("tx2", listed)
+	err = carContract.ListCarForSale(transactionContext, "car1", 15000)
+	require.NoError(t, err)
+	err = carContract.MakeOffer(transactionContext, "car1", "person1", 15000, 3600)
+	requireContractError(t, err, codeAlreadyOwner, "the person person1 already owns the car car1")
+	err = carContract.MakeOffer(transactionContext, "car1", "person2", 15000, 3600)
+	requireContractError(t, err, codeForbidden, "submitting client not authorized to act for person2")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	err = carContract.MakeOffer(transactionContext, "car1", "person2", 15000, 0)
+	requireContractError(t, err, codeInvalidArgument, "the offer must be valid for at least one second")
+	err = carContract.MakeOffer(transactionContext, "car1", "person2", 15000, 30*24*3600+1)
+	requireContractError(t, err, codeInvalidArgument, "the offer must be valid for at most 2592000 seconds")
+	err = carContract.MakeOffer(transactionContext, "car1", "person2", 15000, math.MaxInt64)
+	requireContractError(t, err, codeInvalidArgument, "the offer must be valid for at most 2592000 seconds")
+	err = carContract.MakeOffer(transactionContext, "car1", "person2", 12000, 3600)
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err = carContract.TransferCar(transactionContext, "car1", "person2")
+	requireContractError(t, err, codePriceMismatch, "the offer of 120.00 EUR does not match the asking price of 150.00 EUR")
+
+	// an offer past its expiry cannot be accepted even if the prices match
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	err = carContract.MakeOffer(transactionContext, "car1", "person2", 15000, 3600)
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	stub.StartTx("tx3", listed.Add(time.Hour))
+	err = carContract.TransferCar(transactionContext, "car1", "person2")
+	requireContractError(t, err, codeOfferExpired, "the offer of person2 for the car car1 expired at 2023-01-02T01:00:00Z")
+}
+
+func TestCancelSale(t *testing.T) {
+	transactionContext, stub := newBoundLedger(t)
+	carContract := SmartContract{}
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err := carContract.ListCarForSale(transactionContext, "car1", 15000)
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	err = carContract.MakeOffer(transactionContext, "car1", "person2", 15000, 3600)
+	require.NoError(t, err)
+	err = carContract.CancelOffer(transactionContext, "car1", "person2")
+	require.NoError(t, err)
+	require.Equal(t, "OfferCancelled", stub.Event().EventName)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err = carContract.TransferCar(transactionContext, "car1", "person2")
+	requireContractError(t, err, codeNotFound, "person2 has not made an offer for the car car1")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	err = carContract.MakeOffer(transactionContext, "car1", "person2", 15000, 3600)
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err = carContract.CancelSale(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, "SaleCancelled", stub.Event().EventName)
+
+	// the offer is refunded as a payment, which the buyer collects
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	requireBalance(t, transactionContext, "person2", 308033)
+	payments, err := carContract.QueryPayments(transactionContext, "person2")
+	require.NoError(t, err)
+	require.Len(t, payments, 1)
+	require.Equal(t, &Payment{PayeeId: "person2", Debit: escrowAccount, Amount: NewMoney(15000, defaultCurrency), Memo: "refund of the offer for car1", TxId: payments[0].TxId, Timestamp: payments[0].Timestamp}, payments[0])
+
+	collected, err := carContract.CollectPayments(transactionContext, "person2")
+	require.NoError(t, err)
+	require.Equal(t, 1, collected)
+	require.Equal(t, "PaymentsCollected", stub.Event().EventName)
+	requireBalance(t, transactionContext, "person2", 323033)
+
+	collected, err = carContract.CollectPayments(transactionContext, "person2")
+	require.NoError(t, err)
+	require.Equal(t, 0, collected)
+
+	_, err = carContract.QuerySale(transactionContext, "car1")
+	requireContractError(t, err, codeNotFound, "the car car1 is not listed for sale")
+	require.Empty(t, indexedCarIds(t, stub, offerObjectType))
+}
+
+func TestOfferIsEscrowed(t *testing.T) {
+	transactionContext, stub := newBoundLedger(t)
+	carContract := SmartContract{}
+	listed := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	stub.StartTx("tx2", listed)
+	err := carContract.ListCarForSale(transactionContext, "car1", 15000)
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	err = carContract.MakeOffer(transactionContext, "car1", "person2", 400000, 3600)
+	requireContractError(t, err, codeInsufficientFunds, "the balance of person2 is too low to offer 4000.00 EUR")
+
+	stub.StartTx("tx3", listed.Add(time.Minute))
+	err = carContract.MakeOffer(transactionContext, "car1", "person2", 15000, 3600)
+	require.NoError(t, err)
+	requireBalance(t, transactionContext, "person2", 308033)
+
+	// a new offer replaces the escrow of the previous one
+	stub.StartTx("tx4", listed.Add(2*time.Minute))
+	err = carContract.MakeOffer(transactionContext, "car1", "person2", 12000, 3600)
+	require.NoError(t, err)
+	requireBalance(t, transactionContext, "person2", 311033)
+
+	stub.StartTx("tx5", listed.Add(3*time.Minute))
+	err = carContract.CancelOffer(transactionContext, "car1", "person2")
+	require.NoError(t, err)
+	requireBalance(t, transactionContext, "person2", 323033)
+
+	stub.StartTx("tx6", listed.Add(4*time.Minute))
+	err = carContract.MakeOffer(transactionContext, "car1", "person2", 15000, 3600)
+	require.NoError(t, err)
+
+	// person3 is bound to the admin
+	transactionContext.GetClientIdentityReturns(newClientIdentity(adminClientId, roleAdmin))
+	err = carContract.MakeOffer(transactionContext, "car1", "person3", 15000, 3600)
+	require.NoError(t, err)
+	requireBalance(t, transactionContext, "person3", 318333)
+
+	// the seller is paid out of the escrow of the accepted offer, the other one is refunded
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	stub.StartTx("tx7", listed.Add(5*time.Minute))
+	err = carContract.TransferCar(transactionContext, "car1", "person2")
+	require.NoError(t, err)
+	requireBalance(t, transactionContext, "person1", 905099)
+	requireBalance(t, transactionContext, "person2", 308033)
+	requireBalance(t, transactionContext, "person3", 318333)
+
+	_, err = carContract.QueryPayments(transactionContext, "person3")
+	requireContractError(t, err, codeForbidden, "submitting client not authorized to act for person3")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(adminClientId, roleAdmin))
+	collected, err := carContract.CollectPayments(transactionContext, "person3")
+	require.NoError(t, err)
+	require.Equal(t, 1, collected)
+	requireBalance(t, transactionContext, "person3", 333333)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// carScrapped is the Status of a car whose repairs exceeded its price. A scrapped car is
+// kept in the world state and the indexes, so that it can still be queried and its owner
+// cannot be deleted, but it can no longer be sold, changed or repaired.
+const carScrapped = "scrapped"
+
+// scrapValuePercent is the share of its price paid to the owner of a scrapped car
+const scrapValuePercent = 10
+
+// scrapValue returns the scrap value of a car with the price, rounded down to the minor unit.
+// The whole and the fractional hundredths are scaled separately, so large prices cannot overflow.
+func scrapValue(price Money) Money {
+	return NewMoney(price.Amount/100*scrapValuePercent+price.Amount%100*scrapValuePercent/100, price.Currency)
+}
+
+// requireInService fails for a scrapped car
+func requireInService(car *Car) error {
+	if car.Status == carScrapped {
+		return newError(codeInvalidState, "the car %s is scrapped", car.Id)
+	}
+
+	return nil
+}
+
+// scrapIfUnrepairable scraps the car when its unpaid repairs exceed its price. before is the
+// car as it was before the transaction. It reports whether the car was scrapped.
+func (s *SmartContract) scrapIfUnrepairable(ctx contractapi.TransactionContextInterface, before *Car, car *Car) (bool, error) {
+	repairPrice, err := totalRepairPrice(car.Price.Currency, car.MalfunctionList)
+	if err != nil {
+		return false, err
+	}
+	cmp, err := repairPrice.Cmp(car.Price)
+	if err != nil {
+		return false, err
+	}
+	if cmp <= 0 {
+		return false, nil
+	}
+
+	return true, s.scrapCar(ctx, before, car)
+}
+
+// scrapCar marks the car as scrapped and owes its scrap value to the owner as a payment,
+// since the mechanic's peers need not belong to the owner's organization. The car is
+// removed from its sale and its auction.
+func (s *SmartContract) scrapCar(ctx contractapi.TransactionContextInterface, before *Car, car *Car) error {
+	scrappedAt, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	value := scrapValue(car.Price)
+	err = owePayment(ctx, car.OwnerId, scrapAccount, value, "scrap value of "+car.Id)
+	if err != nil {
+		return err
+	}
+
+	car.Status = carScrapped
+	car.ScrappedAt = &scrappedAt
+	car.ScrapValue = &value
+
+	err = putCar(ctx, car)
+	if err != nil {
+		return err
+	}
+
+	err = withdrawCar(ctx, car.Id)
+	if err != nil {
+		return err
+	}
+
+	return emitCarEvent(ctx, carScrappedEvent, before, car)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrapValue(t *testing.T) {
+	require.Equal(t, NewMoney(2000, defaultCurrency), scrapValue(NewMoney(20000, defaultCurrency)))
+	require.Equal(t, NewMoney(1234, defaultCurrency), scrapValue(NewMoney(12349, defaultCurrency)))
+	require.Equal(t, NewMoney(922337203685477580, defaultCurrency), scrapValue(NewMoney(9223372036854775807, defaultCurrency)))
+}
+
+func TestScrappedCarCannotBeSold(t *testing.T) {
+	transactionContext, stub := newBoundLedger(t)
+	carContract := SmartContract{}
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err := carContract.ListCarForSale(transactionContext, "car2", 25000)
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(mechanicClientId, roleMechanic))
+	err = carContract.AddMalfunction(transactionContext, "car2", "Engine Failure", 17000)
+	require.NoError(t, err)
+	require.Equal(t, "CarScrapped", stub.Event().EventName)
+	require.NotContains(t, string(stub.Event().Payload), "Money")
+
+	// the listing ends with the car
+	_, err = carContract.QuerySale(transactionContext, "car2")
+	requireContractError(t, err, codeNotFound, "the car car2 is not listed for sale")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err = carContract.ListCarForSale(transactionContext, "car2", 25000)
+	requireContractError(t, err, codeInvalidState, "the car car2 is scrapped")
+	err = carContract.CreateAuction(transactionContext, "car2", 25000)
+	requireContractError(t, err, codeInvalidState, "the car car2 is scrapped")
+	err = carContract.ChangeOwner(transactionContext, "car2", "person2", true)
+	requireContractError(t, err, codeInvalidState, "the car car2 is scrapped")
+	err = carContract.ChangeCarColour(transactionContext, "car2", "green")
+	requireContractError(t, err, codeInvalidState, "the car car2 is scrapped")
+
+	// the scrapped car stays with its owner
+	cars, err := carContract.QueryCarsByOwner(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"car1", "car2", "car3"}, carIds(cars))
+	require.Equal(t, carScrapped, cars[1].Status)
+}
+
+func TestOwnerOfScrappedCarCannotBeDeleted(t *testing.T) {
+	transactionContext, _ := newBoundLedger(t)
+	carContract := SmartContract{}
+
+	// car4 is the only car of person2
+	transactionContext.GetClientIdentityReturns(newClientIdentity(mechanicClientId, roleMechanic))
+	err := carContract.AddMalfunction(transactionContext, "car4", "Engine Failure", 31000)
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	err = carContract.DeletePerson(transactionContext, "person2")
+	requireContractError(t, err, codeOwnsCars, "the person person2 still owns cars")
+
+	car, err := carContract.QueryCar(transactionContext, "car4")
+	require.NoError(t, err)
+	require.Equal(t, "person2", car.OwnerId)
+}
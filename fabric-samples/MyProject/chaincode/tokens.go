@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// TokenSettlement configures payments in an ERC-20 token chaincode on the same channel, e.g.
+// token-erc-20/chaincode-go deployed as token_erc20. One token pays one minor unit of
+// Currency. RepairAccount is the token account receiving the repair prices.
+//
+// Buyers and owners linked to a token account pay in tokens instead of from their balance
+// for every car they buy, through ChangeOwner, TransferCar or EndAuction, and for every
+// repair, through RepairCar or RepairMalfunctions. Those payments are recorded by the token
+// chaincode rather than in the journal.
+type TokenSettlement struct {
+	Chaincode     string
+	Currency      string
+	RepairAccount string
+}
+
+// SetTokenSettlement configures the token chaincode that persons linked to a token account
+// pay with
+func (s *SmartContract) SetTokenSettlement(ctx contractapi.TransactionContextInterface, chaincode string, currency string, repairAccount string) error {
+	err := requireRole(ctx, roleAdmin)
+	if err != nil {
+		return err
+	}
+
+	if chaincode == "" || currency == "" || repairAccount == "" {
+		return newError(codeInvalidArgument, "the chaincode, the currency and the repair account are required")
+	}
+
+	settlement := &TokenSettlement{Chaincode: chaincode, Currency: currency, RepairAccount: repairAccount}
+
+	key, err := tokenSettlementKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	settlementAsBytes, err := json.Marshal(settlement)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(key, settlementAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, tokenSettlementSetEvent, settlement)
+}
+
+// QueryTokenSettlement returns the configured token chaincode
+func (s *SmartContract) QueryTokenSettlement(ctx contractapi.TransactionContextInterface) (*TokenSettlement, error) {
+	settlement, err := readTokenSettlement(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if settlement == nil {
+		return nil, newError(codeNotFound, "payments in tokens are not configured")
+	}
+
+	return settlement, nil
+}
+
+// LinkTokenAccount links the person to the account of the token chaincode the person pays
+// with, e.g. the client ID returned by its ClientAccountID. An empty account unlinks it.
+// Buyers must approve the seller's client to spend the price with the token chaincode
+// before the seller submits ChangeOwner, TransferCar or EndAuction.
+func (s *SmartContract) LinkTokenAccount(ctx contractapi.TransactionContextInterface, personId string, account string) error {
+	person, err := s.queryPersonRecord(ctx, personId)
+	if err != nil {
+		return err
+	}
+
+	err = authorizePerson(ctx, person)
+	if err != nil {
+		return err
+	}
+
+	before := *person
+	person.TokenAccount = account
+
+	err = putPerson(ctx, person)
+	if err != nil {
+		return err
+	}
+
+	return emitPersonEvent(ctx, personUpdatedEvent, &before, person)
+}
+
+// payTokens pays the price from the token account of the payer to the one of the payee
+func payTokens(ctx contractapi.TransactionContextInterface, payer *Person, payee *Person, price Money) error {
+	for _, person := range []*Person{payer, payee} {
+		if person.TokenAccount == "" {
+			return newError(codeInvalidState, "the person %s has no token account", person.Id)
+		}
+	}
+
+	settlement, err := requireTokenSettlement(ctx, price)
+	if err != nil {
+		return err
+	}
+
+	return transferTokens(ctx, settlement, payer, payee.TokenAccount, price.Amount)
+}
+
+// payRepairTokens pays the price from the token account of the owner to the repair account
+func payRepairTokens(ctx contractapi.TransactionContextInterface, owner *Person, price Money) error {
+	settlement, err := requireTokenSettlement(ctx, price)
+	if err != nil {
+		return err
+	}
+
+	return transferTokens(ctx, settlement, owner, settlement.RepairAccount, price.Amount)
+}
+
+// requireTokenSettlement returns the configured token chaincode, which must pay in the
+// currency of the price
+func requireTokenSettlement(ctx contractapi.TransactionContextInterface, price Money) (*TokenSettlement, error) {
+	settlement, err := readTokenSettlement(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if settlement == nil {
+		return nil, newError(codeInvalidState, "payments in tokens are not configured")
+	}
+	if settlement.Currency != price.Currency {
+		return nil, newError(codeCurrencyMismatch, "currency mismatch: %s and %s", price.Currency, settlement.Currency)
+	}
+
+	return settlement, nil
+}
+
+// transferTokens moves the amount from the token account of the payer to the account. The
+// balance is checked with BalanceOf first. The token chaincode runs as the submitter, so
+// tokens of the submitter's own account move with Transfer and those of any other account
+// with TransferFrom, which needs the approval of the payer.
+func transferTokens(ctx contractapi.TransactionContextInterface, settlement *TokenSettlement, payer *Person, account string, amount int64) error {
+	if amount == 0 {
+		return nil
+	}
+
+	balance, err := tokenBalance(ctx, settlement, payer)
+	if err != nil {
+		return err
+	}
+	if balance < amount {
+		return newError(codeInsufficientFunds, "the token account of %s holds %d tokens, %d are needed", payer.Id, balance, amount)
+	}
+
+	// the token chaincode identifies accounts by the client ID as returned by GetID, which
+	// unlike submittingClient is not decoded
+	clientId, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("Failed to read clientID: %v", err)
+	}
+
+	value := strconv.FormatInt(amount, 10)
+	if payer.TokenAccount == clientId {
+		_, err = invokeToken(ctx, settlement, "Transfer", account, value)
+	} else {
+		_, err = invokeToken(ctx, settlement, "TransferFrom", payer.TokenAccount, account, value)
+	}
+	return err
+}
+
+// tokenBalance returns the number of tokens held by the token account of the person
+func tokenBalance(ctx contractapi.TransactionContextInterface, settlement *TokenSettlement, person *Person) (int64, error) {
+	balanceAsBytes, err := invokeToken(ctx, settlement, "BalanceOf", person.TokenAccount)
+	if err != nil {
+		return 0, err
+	}
+	balance, err := strconv.ParseInt(string(balanceAsBytes), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("the token chaincode %s returned an invalid balance %q", settlement.Chaincode, balanceAsBytes)
+	}
+
+	return balance, nil
+}
+
+// holdsTokens reports whether the person is linked to a token account holding at least the price
+func holdsTokens(ctx contractapi.TransactionContextInterface, person *Person, price Money) (bool, error) {
+	if person.TokenAccount == "" {
+		return false, nil
+	}
+
+	settlement, err := requireTokenSettlement(ctx, price)
+	if err != nil {
+		return false, err
+	}
+	balance, err := tokenBalance(ctx, settlement, person)
+	if err != nil {
+		return false, err
+	}
+
+	return balance >= price.Amount, nil
+}
+
+// invokeToken calls the function of the token chaincode on the same channel
+func invokeToken(ctx contractapi.TransactionContextInterface, settlement *TokenSettlement, function string, args ...string) ([]byte, error) {
+	invokeArgs := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		invokeArgs = append(invokeArgs, []byte(arg))
+	}
+
+	response := ctx.GetStub().InvokeChaincode(settlement.Chaincode, invokeArgs, "")
+	if response.Status != shim.OK {
+		return nil, fmt.Errorf("%s of the token chaincode %s failed: %s", function, settlement.Chaincode, response.Message)
+	}
+
+	return response.Payload, nil
+}
+
+// readTokenSettlement returns the configured token chaincode or nil if there is none
+func readTokenSettlement(ctx contractapi.TransactionContextInterface) (*TokenSettlement, error) {
+	key, err := tokenSettlementKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	settlementAsBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+	if settlementAsBytes == nil {
+		return nil, nil
+	}
+
+	settlement := new(TokenSettlement)
+	err = json.Unmarshal(settlementAsBytes, settlement)
+	if err != nil {
+		return nil, err
+	}
+
+	return settlement, nil
+}
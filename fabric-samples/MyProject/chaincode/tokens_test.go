@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/require"
+)
+
+// tokenLedger is an ERC-20 token chaincode keeping the balances and allowances in memory.
+// It runs as the client, like a chaincode called by InvokeChaincode runs as the submitter,
+// and identifies accounts by the client ID as returned by GetID.
+type tokenLedger struct {
+	client     string
+	balances   map[string]int64
+	allowances map[string]int64
+	calls      []string
+}
+
+// account returns the token account of the client, i.e. its base64 encoded X.509 ID
+func account(clientId string) string {
+	return base64.StdEncoding.EncodeToString([]byte(clientId))
+}
+
+func (l *tokenLedger) invoke(args [][]byte, channel string) peer.Response {
+	call := []string{}
+	for _, arg := range args {
+		call = append(call, string(arg))
+	}
+	l.calls = append(l.calls, strings.Join(call, " "))
+
+	switch call[0] {
+	case "BalanceOf":
+		balance, ok := l.balances[call[1]]
+		if !ok {
+			return shim.Error("the account " + call[1] + " does not exist")
+		}
+		return shim.Success([]byte(strconv.FormatInt(balance, 10)))
+	case "Transfer":
+		return l.transfer(l.client, call[1], call[2])
+	case "TransferFrom":
+		amount, _ := strconv.ParseInt(call[3], 10, 64)
+		allowance := call[1] + " " + l.client
+		if l.allowances[allowance] < amount {
+			return shim.Error("spender does not have enough allowance for transfer")
+		}
+		l.allowances[allowance] -= amount
+		return l.transfer(call[1], call[2], call[3])
+	default:
+		return shim.Error("unknown function " + call[0])
+	}
+}
+
+func (l *tokenLedger) transfer(from string, to string, value string) peer.Response {
+	amount, _ := strconv.ParseInt(value, 10, 64)
+	l.balances[from] -= amount
+	l.balances[to] += amount
+	return shim.Success(nil)
+}
+
+func TestPayInTokens(t *testing.T) {
+	transactionContext, stub := newBoundLedger(t)
+	carContract := SmartContract{}
+
+	rousseauAccount := account(rousseauClientId)
+	poloAccount := account(poloClientId)
+	tokens := &tokenLedger{
+		client:     rousseauAccount,
+		balances:   map[string]int64{rousseauAccount: 5000, poloAccount: 500},
+		allowances: map[string]int64{},
+	}
+	stub.SetChaincode("token_erc20", tokens.invoke)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	err := carContract.LinkTokenAccount(transactionContext, "person2", poloAccount)
+	require.NoError(t, err)
+	require.Equal(t, "PersonUpdated", stub.Event().EventName)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err = carContract.ChangeOwner(transactionContext, "car1", "person2", true)
+	requireContractError(t, err, codeInvalidState, "the person person1 has no token account")
+
+	err = carContract.LinkTokenAccount(transactionContext, "person1", rousseauAccount)
+	require.NoError(t, err)
+
+	err = carContract.ChangeOwner(transactionContext, "car1", "person2", true)
+	requireContractError(t, err, codeInvalidState, "payments in tokens are not configured")
+
+	err = carContract.SetTokenSettlement(transactionContext, "token_erc20", defaultCurrency, "repairs")
+	requireContractError(t, err, codeForbidden, "submitting client not authorized, requires the admin role")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(adminClientId, roleAdmin))
+	err = carContract.SetTokenSettlement(transactionContext, "token_erc20", defaultCurrency, "repairs")
+	require.NoError(t, err)
+	require.Equal(t, "TokenSettlementSet", stub.Event().EventName)
+
+	// car1 sells at its price less the unpaid repairs, 1000 tokens
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err = carContract.ChangeOwner(transactionContext, "car1", "person2", true)
+	requireContractError(t, err, codeInsufficientFunds, "the token account of person2 holds 500 tokens, 1000 are needed")
+
+	// the seller pulls the price from the buyer's account once the buyer approved it
+	tokens.balances[poloAccount] = 2000
+	err = carContract.ChangeOwner(transactionContext, "car1", "person2", true)
+	require.EqualError(t, err, "TransferFrom of the token chaincode token_erc20 failed: spender does not have enough allowance for transfer")
+
+	tokens.allowances[poloAccount+" "+rousseauAccount] = 1000
+	err = carContract.ChangeOwner(transactionContext, "car1", "person2", true)
+	require.NoError(t, err)
+	require.Equal(t, "CarTransferred", stub.Event().EventName)
+
+	// the balances stay the same
+	require.Equal(t, "TransferFrom "+poloAccount+" "+rousseauAccount+" 1000", tokens.calls[len(tokens.calls)-1])
+	require.Equal(t, map[string]int64{rousseauAccount: 6000, poloAccount: 1000}, tokens.balances)
+	require.Equal(t, int64(0), tokens.allowances[poloAccount+" "+rousseauAccount])
+
+	requireBalance(t, transactionContext, "person2", 323033)
+
+	// the owner pays the repairs from the own account without an allowance
+	err = carContract.RepairCar(transactionContext, "car2")
+	require.NoError(t, err)
+	require.Equal(t, "Transfer repairs 4000", tokens.calls[len(tokens.calls)-1])
+	require.Equal(t, int64(2000), tokens.balances[rousseauAccount])
+	require.Equal(t, int64(4000), tokens.balances["repairs"])
+
+	requireBalance(t, transactionContext, "person1", 890099)
+
+	settlement, err := carContract.QueryTokenSettlement(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, &TokenSettlement{Chaincode: "token_erc20", Currency: defaultCurrency, RepairAccount: "repairs"}, settlement)
+}
+
+func TestSellAndRepairInTokens(t *testing.T) {
+	transactionContext, stub := newBoundLedger(t)
+	onPeerOf(t, "Org1MSP")
+	carContract := SmartContract{}
+
+	rousseauAccount := account(rousseauClientId)
+	poloAccount := account(poloClientId)
+	tokens := &tokenLedger{
+		client:     poloAccount,
+		balances:   map[string]int64{rousseauAccount: 0, poloAccount: 20000},
+		allowances: map[string]int64{},
+	}
+	stub.SetChaincode("token_erc20", tokens.invoke)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(adminClientId, roleAdmin))
+	err := carContract.SetTokenSettlement(transactionContext, "token_erc20", defaultCurrency, "repairs")
+	require.NoError(t, err)
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err = carContract.LinkTokenAccount(transactionContext, "person1", rousseauAccount)
+	require.NoError(t, err)
+
+	// ordered repairs are paid in tokens
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	err = carContract.LinkTokenAccount(transactionContext, "person2", poloAccount)
+	require.NoError(t, err)
+	err = carContract.RepairMalfunctions(transactionContext, "car4", []string{"m1"})
+	require.NoError(t, err)
+	require.Equal(t, "Transfer repairs 10000", tokens.calls[len(tokens.calls)-1])
+
+	// so are sales
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err = carContract.ListCarForSale(transactionContext, "car3", 5000)
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	err = carContract.MakeOffer(transactionContext, "car3", "person2", 5000, 3600)
+	require.NoError(t, err)
+
+	tokens.client = rousseauAccount
+	tokens.allowances[poloAccount+" "+rousseauAccount] = 5000
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err = carContract.TransferCar(transactionContext, "car3", "person2")
+	require.NoError(t, err)
+	require.Equal(t, "TransferFrom "+poloAccount+" "+rousseauAccount+" 5000", tokens.calls[len(tokens.calls)-1])
+
+	// and auctions, which a bidder whose account holds too few tokens does not win
+	err = carContract.CreateAuction(transactionContext, "car2", 1000)
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	bid := AuctionBid{CarId: "car2", BidderId: "person2", Price: NewMoney(4000, defaultCurrency), Salt: "77e1"}
+	withBid(t, transactionContext, bid)
+	bidId, err := carContract.SubmitBid(transactionContext, "car2")
+	require.NoError(t, err)
+	err = carContract.CommitBid(transactionContext, "car2", "person2", bidId)
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err = carContract.CloseAuction(transactionContext, "car2")
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	withBid(t, transactionContext, bid)
+	err = carContract.RevealBid(transactionContext, "car2", bidId)
+	require.NoError(t, err)
+
+	tokens.allowances[poloAccount+" "+rousseauAccount] = 4000
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err = carContract.EndAuction(transactionContext, "car2")
+	require.NoError(t, err)
+	require.Equal(t, "TransferFrom "+poloAccount+" "+rousseauAccount+" 4000", tokens.calls[len(tokens.calls)-1])
+
+	require.Equal(t, map[string]int64{rousseauAccount: 9000, poloAccount: 1000, "repairs": 10000}, tokens.balances)
+
+	// the balances stay the same and nothing is left in escrow
+	requireBalance(t, transactionContext, "person2", 323033)
+	requireBalance(t, transactionContext, "person1", 890099)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	payments, err := carContract.QueryPayments(transactionContext, "person2")
+	require.NoError(t, err)
+	require.Empty(t, payments)
+
+	cars, err := carContract.QueryCarsByOwner(transactionContext, "person2")
+	require.NoError(t, err)
+	require.Equal(t, []string{"car2", "car3", "car4"}, carIds(cars))
+}
+
+func TestAuctionSkipsBidderWithoutTokens(t *testing.T) {
+	transactionContext, stub := newBoundLedger(t)
+	onPeerOf(t, "Org1MSP")
+	carContract := SmartContract{}
+
+	poloAccount := account(poloClientId)
+	tokens := &tokenLedger{
+		client:     account(rousseauClientId),
+		balances:   map[string]int64{poloAccount: 3000},
+		allowances: map[string]int64{},
+	}
+	stub.SetChaincode("token_erc20", tokens.invoke)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(adminClientId, roleAdmin))
+	err := carContract.SetTokenSettlement(transactionContext, "token_erc20", defaultCurrency, "repairs")
+	require.NoError(t, err)
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	err = carContract.LinkTokenAccount(transactionContext, "person2", poloAccount)
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err = carContract.CreateAuction(transactionContext, "car2", 1000)
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	bid := AuctionBid{CarId: "car2", BidderId: "person2", Price: NewMoney(4000, defaultCurrency), Salt: "77e1"}
+	withBid(t, transactionContext, bid)
+	bidId, err := carContract.SubmitBid(transactionContext, "car2")
+	require.NoError(t, err)
+	err = carContract.CommitBid(transactionContext, "car2", "person2", bidId)
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err = carContract.CloseAuction(transactionContext, "car2")
+	require.NoError(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity(poloClientId, ""))
+	withBid(t, transactionContext, bid)
+	err = carContract.RevealBid(transactionContext, "car2", bidId)
+	require.NoError(t, err)
+
+	// the balance would cover the bid, the token account does not
+	transactionContext.GetClientIdentityReturns(newClientIdentity(rousseauClientId, ""))
+	err = carContract.EndAuction(transactionContext, "car2")
+	require.NoError(t, err)
+	require.Equal(t, "BalanceOf "+poloAccount, tokens.calls[len(tokens.calls)-1])
+
+	auction, err := carContract.QueryAuction(transactionContext, "car2")
+	require.NoError(t, err)
+	require.Empty(t, auction.WinnerId)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Depreciation rates are in basis points of the value per year, e.g. 1000 is 10% a year
+const (
+	defaultDepreciation = 1200
+	// maxDepreciationYears caps the age of a car, beyond it the value does not drop further
+	maxDepreciationYears = 30
+	// maxHistoryDeduction caps the deduction for the malfunction history at 30%
+	maxHistoryDeduction = 3000
+)
+
+// brandDepreciation holds the yearly depreciation of the brands that keep their value
+// better or worse than the default, keyed by the lower case brand
+var brandDepreciation = map[string]int64{
+	"toyota":     800,
+	"volkswagen": 1000,
+	"hyundai":    1100,
+	"ford":       1300,
+	"fiat":       1500,
+	"tesla":      1500,
+}
+
+// severityDeduction is the deduction in basis points for every malfunction in the history
+// of the car. Malfunctions that were not assessed count as medium.
+var severityDeduction = map[string]int64{
+	severityLow:      50,
+	severityMedium:   100,
+	severityHigh:     200,
+	severityCritical: 400,
+}
+
+// CarValuation is the value of a car at the time of the transaction and how it was derived.
+// DepreciatedPrice averages the depreciated price with the depreciated last transfer price,
+// if the car was sold before. HistoryDeduction is taken for the malfunction history and
+// RepairPrice for the repairs that are not paid yet. The value is never below the scrap value.
+type CarValuation struct {
+	CarId            string
+	ListPrice        Money
+	AgeYears         int
+	LastTransfer     *CarTransfer `json:",omitempty"`
+	DepreciatedPrice Money
+	HistoryDeduction Money
+	RepairPrice      Money
+	Value            Money
+	ValuedAt         time.Time
+}
+
+// GetCarValuation returns the valuation of the car. Only integer arithmetic on the car and
+// the transaction timestamp is used, so every endorsing peer computes the same value.
+func (s *SmartContract) GetCarValuation(ctx contractapi.TransactionContextInterface, carId string) (*CarValuation, error) {
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requireInService(car)
+	if err != nil {
+		return nil, err
+	}
+
+	return valueCar(ctx, car)
+}
+
+// valueCar returns the valuation of the car at the time of the transaction
+func valueCar(ctx contractapi.TransactionContextInterface, car *Car) (*CarValuation, error) {
+	valuedAt, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rate, ok := brandDepreciation[strings.ToLower(car.Brand)]
+	if !ok {
+		rate = defaultDepreciation
+	}
+
+	age := yearsBetween(car.Year, valuedAt.Year())
+	price := depreciate(car.Price, rate, age)
+
+	lastTransfer := car.LastTransfer
+	if lastTransfer != nil && lastTransfer.Price.Currency == car.Price.Currency {
+		transferPrice := depreciate(lastTransfer.Price, rate, yearsBetween(lastTransfer.At.Year(), valuedAt.Year()))
+		price = NewMoney(average(price.Amount, transferPrice.Amount), price.Currency)
+	} else {
+		lastTransfer = nil
+	}
+
+	var deduction int64
+	for _, malfunction := range car.MalfunctionList {
+		points, ok := severityDeduction[malfunction.Severity]
+		if !ok {
+			points = severityDeduction[severityMedium]
+		}
+		deduction += points
+	}
+	if deduction > maxHistoryDeduction {
+		deduction = maxHistoryDeduction
+	}
+	historyDeduction := scaleBasisPoints(price, deduction)
+
+	repairPrice, err := totalRepairPrice(car.Price.Currency, car.MalfunctionList)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := price.Sub(historyDeduction)
+	if err != nil {
+		return nil, err
+	}
+	value, err = value.Sub(repairPrice)
+	if err != nil {
+		return nil, err
+	}
+
+	// a car is always worth at least its scrap value
+	floor := scrapValue(car.Price)
+	if value.Amount < floor.Amount {
+		value = floor
+	}
+
+	return &CarValuation{
+		CarId:            car.Id,
+		ListPrice:        car.Price,
+		AgeYears:         age,
+		LastTransfer:     lastTransfer,
+		DepreciatedPrice: price,
+		HistoryDeduction: historyDeduction,
+		RepairPrice:      repairPrice,
+		Value:            value,
+		ValuedAt:         valuedAt,
+	}, nil
+}
+
+// yearsBetween returns the number of years from one year to the other, between zero
+// and maxDepreciationYears
+func yearsBetween(from int, to int) int {
+	years := to - from
+	if years < 0 {
+		return 0
+	}
+	if years > maxDepreciationYears {
+		return maxDepreciationYears
+	}
+	return years
+}
+
+// depreciate returns the amount after the years at the yearly rate in basis points, rounding
+// down every year
+func depreciate(amount Money, rate int64, years int) Money {
+	for i := 0; i < years; i++ {
+		amount = scaleBasisPoints(amount, 10000-rate)
+	}
+	return amount
+}
+
+// scaleBasisPoints returns the share of the amount in basis points, rounded toward zero.
+// The product is computed in arbitrary precision, so large amounts cannot overflow.
+func scaleBasisPoints(amount Money, points int64) Money {
+	scaled := new(big.Int).Mul(big.NewInt(amount.Amount), big.NewInt(points))
+	scaled.Quo(scaled, big.NewInt(10000))
+	return NewMoney(scaled.Int64(), amount.Currency)
+}
+
+// average returns the mean of both amounts rounded down, without overflowing
+func average(a int64, b int64) int64 {
+	return a/2 + b/2 + (a%2+b%2)/2
+}
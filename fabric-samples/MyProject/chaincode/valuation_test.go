@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDepreciate(t *testing.T) {
+	require.Equal(t, NewMoney(84640, defaultCurrency), depreciate(NewMoney(100000, defaultCurrency), 800, 2))
+	require.Equal(t, NewMoney(100000, defaultCurrency), depreciate(NewMoney(100000, defaultCurrency), 800, 0))
+	require.Equal(t, NewMoney(8301034833169298226, defaultCurrency), scaleBasisPoints(NewMoney(9223372036854775807, defaultCurrency), 9000))
+	require.Equal(t, 0, yearsBetween(2024, 2023))
+	require.Equal(t, maxDepreciationYears, yearsBetween(1950, 2023))
+}
+
+func TestGetCarValuation(t *testing.T) {
+	transactionContext, stub := newInitializedLedger(t)
+	carContract := SmartContract{}
+
+	// car1 needs more repairs than its depreciated price, so it is worth its scrap value
+	valuation, err := carContract.GetCarValuation(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, 22, valuation.AgeYears)
+	require.Equal(t, NewMoney(9000, defaultCurrency), valuation.RepairPrice)
+	require.Equal(t, NewMoney(1000, defaultCurrency), valuation.Value)
+
+	err = carContract.CreateCar(transactionContext, "car7", "Toyota", "Corolla", 2021, "grey", "person1", 100000, defaultCurrency)
+	require.NoError(t, err)
+
+	valuation, err = carContract.GetCarValuation(transactionContext, "car7")
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(84640, defaultCurrency), valuation.Value)
+
+	malfunctionId, err := carContract.ReportMalfunction(transactionContext, "car7", "Cracked Engine Block", severityCritical)
+	require.NoError(t, err)
+	err = carContract.QuoteMalfunction(transactionContext, "car7", malfunctionId, 4000)
+	require.NoError(t, err)
+
+	valuation, err = carContract.GetCarValuation(transactionContext, "car7")
+	require.NoError(t, err)
+	require.Equal(t, &CarValuation{
+		CarId:            "car7",
+		ListPrice:        NewMoney(100000, defaultCurrency),
+		AgeYears:         2,
+		DepreciatedPrice: NewMoney(84640, defaultCurrency),
+		HistoryDeduction: NewMoney(3385, defaultCurrency),
+		RepairPrice:      NewMoney(4000, defaultCurrency),
+		Value:            NewMoney(77255, defaultCurrency),
+		ValuedAt:         time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+	}, valuation)
+
+	err = carContract.ChangeOwnerAtValuation(transactionContext, "car7", "person2", false)
+	requireContractError(t, err, codeHasMalfunctions, "This car has malfunctions, purchase cannot be made! ")
+
+	// the valuation already deducts the unpaid repair
+	err = carContract.ChangeOwnerAtValuation(transactionContext, "car7", "person2", true)
+	require.NoError(t, err)
+	require.Equal(t, "CarTransferred", stub.Event().EventName)
+
+	buyer, err := carContract.QueryPerson(transactionContext, "person2")
+	require.NoError(t, err)
+	require.Equal(t, NewMoney(245778, defaultCurrency), buyer.Money)
+
+	// a year later the depreciated price and the depreciated transfer price are averaged
+	stub.StartTx("tx2", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	valuation, err = carContract.GetCarValuation(transactionContext, "car7")
+	require.NoError(t, err)
+	require.Equal(t, &CarTransfer{Price: NewMoney(77255, defaultCurrency), At: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}, valuation.LastTransfer)
+	require.Equal(t, NewMoney(74471, defaultCurrency), valuation.DepreciatedPrice)
+	require.Equal(t, NewMoney(67493, defaultCurrency), valuation.Value)
+
+	_, err = carContract.GetCarValuation(transactionContext, "car9")
+	requireContractError(t, err, codeNotFound, "car9 does not exist")
+}
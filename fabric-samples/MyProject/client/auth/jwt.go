@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Roles of the API. A role is granted by listing it in the roles claim of the token.
+const (
+	RoleOwner    = "owner"
+	RoleMechanic = "mechanic"
+	RoleDealer   = "dealer"
+	RoleAdmin    = "admin"
+)
+
+// MinSecretLength is the minimum length of the HS256 secret in bytes
+const MinSecretLength = 32
+
+var (
+	ErrMalformedToken   = errors.New("malformed token")
+	ErrUnsupportedToken = errors.New("only HS256 signed JWTs are supported")
+	ErrInvalidSignature = errors.New("invalid token signature")
+	ErrExpiredToken     = errors.New("token has expired")
+)
+
+// Claims are the claims of an API token. Subject is the id of the person calling the API,
+// e.g. person1. Identity selects the wallet identity the caller transacts as; only admins
+// may omit it and transact as the default identity.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Roles     []string `json:"roles"`
+	Identity  string   `json:"identity,omitempty"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf,omitempty"`
+}
+
+// HasRole returns true when the role is granted to the caller
+func (c *Claims) HasRole(role string) bool {
+	for _, granted := range c.Roles {
+		if granted == role {
+			return true
+		}
+	}
+	return false
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+}
+
+var encoding = base64.RawURLEncoding
+
+// Sign returns an HS256 JWT of the claims
+func Sign(claims *Claims, secret []byte) (string, error) {
+	headerAsBytes, err := json.Marshal(header{Algorithm: "HS256", Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claimsAsBytes, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encoding.EncodeToString(headerAsBytes) + "." + encoding.EncodeToString(claimsAsBytes)
+	return signingInput + "." + encoding.EncodeToString(signature(signingInput, secret)), nil
+}
+
+// Verify checks the signature and the validity period of an HS256 JWT and returns its claims
+func Verify(token string, secret []byte, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	headerAsBytes, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	h := header{}
+	err = json.Unmarshal(headerAsBytes, &h)
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	// the algorithm is fixed, so "none" or RS256 headers cannot downgrade the check
+	if h.Algorithm != "HS256" {
+		return nil, ErrUnsupportedToken
+	}
+
+	tokenSignature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	if !hmac.Equal(tokenSignature, signature(parts[0]+"."+parts[1], secret)) {
+		return nil, ErrInvalidSignature
+	}
+
+	claimsAsBytes, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	claims := &Claims{}
+	err = json.Unmarshal(claimsAsBytes, claims)
+	if err != nil || claims.Subject == "" || claims.ExpiresAt == 0 {
+		return nil, ErrMalformedToken
+	}
+
+	if now.Unix() >= claims.ExpiresAt || now.Unix() < claims.NotBefore {
+		return nil, ErrExpiredToken
+	}
+
+	return claims, nil
+}
+
+func signature(signingInput string, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
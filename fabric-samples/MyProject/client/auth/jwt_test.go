@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+func newClaims(now time.Time) *Claims {
+	return &Claims{
+		Subject:   "person1",
+		Roles:     []string{RoleOwner, RoleDealer},
+		ExpiresAt: now.Add(time.Hour).Unix(),
+		NotBefore: now.Add(-time.Minute).Unix(),
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+	claims := newClaims(now)
+
+	token, err := Sign(claims, secret)
+	require.NoError(t, err)
+
+	verified, err := Verify(token, secret, now)
+	require.NoError(t, err)
+	require.Equal(t, claims, verified)
+	require.True(t, verified.HasRole(RoleDealer))
+	require.False(t, verified.HasRole(RoleAdmin))
+}
+
+func TestVerifyChecksValidityPeriod(t *testing.T) {
+	now := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+	token, err := Sign(newClaims(now), secret)
+	require.NoError(t, err)
+
+	_, err = Verify(token, secret, now.Add(time.Hour))
+	require.Equal(t, ErrExpiredToken, err)
+
+	_, err = Verify(token, secret, now.Add(-2*time.Minute))
+	require.Equal(t, ErrExpiredToken, err)
+}
+
+func TestVerifyRejectsForgedTokens(t *testing.T) {
+	now := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+	token, err := Sign(newClaims(now), secret)
+	require.NoError(t, err)
+	parts := strings.Split(token, ".")
+
+	_, err = Verify(token, []byte("another secret of at least 32 bytes"), now)
+	require.Equal(t, ErrInvalidSignature, err)
+
+	// claims of another person with the signature of the original token
+	admin := newClaims(now)
+	admin.Roles = []string{RoleAdmin}
+	forged, err := Sign(admin, secret)
+	require.NoError(t, err)
+	forgedParts := strings.Split(forged, ".")
+	_, err = Verify(parts[0]+"."+forgedParts[1]+"."+parts[2], secret, now)
+	require.Equal(t, ErrInvalidSignature, err)
+
+	none := encoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	_, err = Verify(none+"."+parts[1]+".", secret, now)
+	require.Equal(t, ErrUnsupportedToken, err)
+}
+
+func TestVerifyRejectsMalformedTokens(t *testing.T) {
+	now := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	for _, token := range []string{"", "a.b", "a.b.c.d", "!.b.c"} {
+		_, err := Verify(token, secret, now)
+		require.Equal(t, ErrMalformedToken, err, token)
+	}
+
+	// a validly signed token must still name the subject and the expiry
+	token, err := Sign(&Claims{Roles: []string{RoleOwner}, ExpiresAt: now.Add(time.Hour).Unix()}, secret)
+	require.NoError(t, err)
+	_, err = Verify(token, secret, now)
+	require.Equal(t, ErrMalformedToken, err)
+
+	token, err = Sign(&Claims{Subject: "person1"}, secret)
+	require.NoError(t, err)
+	_, err = Verify(token, secret, now)
+	require.Equal(t, ErrMalformedToken, err)
+}
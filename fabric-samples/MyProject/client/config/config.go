@@ -0,0 +1,255 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"girhub.com/fist/chaincode/auth"
+	"gopkg.in/yaml.v2"
+)
+
+// envPrefix is the prefix of the environment variables read by Load, e.g. CARS_CHANNEL
+const envPrefix = "CARS_"
+
+// Config is the configuration of the REST client. Every setting is read from the
+// YAML file, then from the environment and finally from the command line flags,
+// each source overriding the previous one.
+type Config struct {
+	// ConnectionProfile is the path of the connection profile of the organisation
+	ConnectionProfile string `yaml:"connectionProfile"`
+	// MSPID is the MSP of the identity, e.g. Org4MSP
+	MSPID string `yaml:"mspId"`
+	// Credentials is the MSP directory of the user, holding signcerts/cert.pem and a
+	// single key in keystore. It is only read when the wallet lacks the identity.
+	Credentials string `yaml:"credentials"`
+	// Wallet is the directory of the file system wallet
+	Wallet string `yaml:"wallet"`
+	// Identity is the label of the identity in the wallet
+	Identity string `yaml:"identity"`
+	// Channel and Chaincode select the contract
+	Channel   string `yaml:"channel"`
+	Chaincode string `yaml:"chaincode"`
+	// Address is the address the HTTP server listens on, e.g. :9090
+	Address string `yaml:"address"`
+	// DiscoveryAsLocalhost maps discovered peers to localhost, as needed for the test network
+	DiscoveryAsLocalhost bool `yaml:"discoveryAsLocalhost"`
+	// InitLedger submits InitLedger on startup
+	InitLedger bool `yaml:"initLedger"`
+	// JWTSecret is the HS256 secret API tokens are signed with. Prefer CARS_JWT_SECRET
+	// over the file or the flag, so that it does not end up in version control or ps.
+	JWTSecret string `yaml:"jwtSecret"`
+	// Peers are the peers of the organisation of the default identity, which alone can read
+	// and write the private details and balances of its persons. They are read from the
+	// configuration file only.
+	Peers []string `yaml:"peers"`
+	// Identities are the identities callers can choose besides the default one given by
+	// the settings above. They are read from the configuration file only.
+	Identities []Identity `yaml:"identities"`
+}
+
+// Identity is a wallet identity with the connection profile and the peers of its organisation
+type Identity struct {
+	Label             string   `yaml:"label"`
+	MSPID             string   `yaml:"mspId"`
+	Credentials       string   `yaml:"credentials"`
+	ConnectionProfile string   `yaml:"connectionProfile"`
+	Peers             []string `yaml:"peers"`
+}
+
+// setting describes how a field of Config is named in the environment and on the command line
+type setting struct {
+	name  string
+	usage string
+	value func(c *Config) interface{}
+}
+
+var settings = []setting{
+	{"connection-profile", "path of the connection profile", func(c *Config) interface{} { return &c.ConnectionProfile }},
+	{"msp-id", "MSP of the identity", func(c *Config) interface{} { return &c.MSPID }},
+	{"credentials", "MSP directory of the user", func(c *Config) interface{} { return &c.Credentials }},
+	{"wallet", "directory of the wallet", func(c *Config) interface{} { return &c.Wallet }},
+	{"identity", "label of the identity in the wallet", func(c *Config) interface{} { return &c.Identity }},
+	{"channel", "channel of the contract", func(c *Config) interface{} { return &c.Channel }},
+	{"chaincode", "name of the chaincode", func(c *Config) interface{} { return &c.Chaincode }},
+	{"address", "address of the HTTP server", func(c *Config) interface{} { return &c.Address }},
+	{"discovery-as-localhost", "map discovered peers to localhost", func(c *Config) interface{} { return &c.DiscoveryAsLocalhost }},
+	{"init-ledger", "submit InitLedger on startup", func(c *Config) interface{} { return &c.InitLedger }},
+	{"jwt-secret", "HS256 secret of the API tokens", func(c *Config) interface{} { return &c.JWTSecret }},
+}
+
+// Default returns the configuration of Org4 in the test network
+func Default() Config {
+	org4 := filepath.Join("..", "..", "test-network", "organizations", "peerOrganizations", "org4.example.com")
+
+	return Config{
+		ConnectionProfile:    filepath.Join(org4, "connection-org4.yaml"),
+		MSPID:                "Org4MSP",
+		Credentials:          filepath.Join(org4, "users", "User1@org4.example.com", "msp"),
+		Wallet:               "wallet",
+		Identity:             "appUser",
+		Channel:              "mychannel",
+		Chaincode:            "basic",
+		Address:              ":9090",
+		DiscoveryAsLocalhost: true,
+		InitLedger:           true,
+		Peers:                []string{"peer0.org4.example.com"},
+	}
+}
+
+// Load reads the configuration from the file given by the -config flag or the CARS_CONFIG
+// environment variable, the environment and the command line arguments, and validates it
+func Load(args []string) (*Config, error) {
+	c := Default()
+
+	flags := flag.NewFlagSet("client", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv(envPrefix+"CONFIG"), "path of the YAML configuration file")
+	flagValues := Default()
+	for _, s := range settings {
+		switch value := s.value(&flagValues).(type) {
+		case *string:
+			flags.StringVar(value, s.name, *value, s.usage+" ("+envName(s.name)+")")
+		case *bool:
+			flags.BoolVar(value, s.name, *value, s.usage+" ("+envName(s.name)+")")
+		}
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		err = c.readFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = c.readEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	// only flags given on the command line override, the defaults of the others are ignored
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.name != f.Name {
+				continue
+			}
+			switch value := s.value(&c).(type) {
+			case *string:
+				*value = *s.value(&flagValues).(*string)
+			case *bool:
+				*value = *s.value(&flagValues).(*bool)
+			}
+		}
+	})
+
+	err = c.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *Config) readFile(path string) error {
+	content, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return fmt.Errorf("unable to read configuration file: %v", err)
+	}
+
+	err = yaml.UnmarshalStrict(content, c)
+	if err != nil {
+		return fmt.Errorf("invalid configuration file %s: %v", path, err)
+	}
+	return nil
+}
+
+func (c *Config) readEnv() error {
+	for _, s := range settings {
+		env, ok := os.LookupEnv(envName(s.name))
+		if !ok {
+			continue
+		}
+
+		switch value := s.value(c).(type) {
+		case *string:
+			*value = env
+		case *bool:
+			parsed, err := strconv.ParseBool(env)
+			if err != nil {
+				return fmt.Errorf("%s must be true or false", envName(s.name))
+			}
+			*value = parsed
+		}
+	}
+	return nil
+}
+
+// DefaultIdentity returns the identity used when a request does not choose one
+func (c *Config) DefaultIdentity() Identity {
+	return Identity{
+		Label:             c.Identity,
+		MSPID:             c.MSPID,
+		Credentials:       c.Credentials,
+		ConnectionProfile: c.ConnectionProfile,
+		Peers:             c.Peers,
+	}
+}
+
+// AllIdentities returns the default identity followed by the additional ones
+func (c *Config) AllIdentities() []Identity {
+	return append([]Identity{c.DefaultIdentity()}, c.Identities...)
+}
+
+// Validate checks that every setting is given and that the connection profiles exist
+func (c *Config) Validate() error {
+	problems := []string{}
+	for _, s := range settings {
+		if value, ok := s.value(c).(*string); ok && *value == "" {
+			problems = append(problems, s.name+" must be set")
+		}
+	}
+
+	labels := map[string]bool{}
+	for i, identity := range c.AllIdentities() {
+		if i > 0 && (identity.Label == "" || identity.MSPID == "" || identity.Credentials == "" || identity.ConnectionProfile == "") {
+			problems = append(problems, fmt.Sprintf("identity %d must set label, mspId, credentials and connectionProfile", i))
+			continue
+		}
+		if labels[identity.Label] {
+			problems = append(problems, fmt.Sprintf("identity %s is configured more than once", identity.Label))
+		}
+		labels[identity.Label] = true
+
+		if identity.ConnectionProfile != "" {
+			if _, err := os.Stat(identity.ConnectionProfile); err != nil {
+				problems = append(problems, fmt.Sprintf("connection profile %s does not exist", identity.ConnectionProfile))
+			}
+		}
+	}
+	if c.JWTSecret != "" && len(c.JWTSecret) < auth.MinSecretLength {
+		problems = append(problems, fmt.Sprintf("jwt-secret must be at least %d bytes", auth.MinSecretLength))
+	}
+	if c.Address != "" {
+		if _, _, err := net.SplitHostPort(c.Address); err != nil {
+			problems = append(problems, fmt.Sprintf("address %s is not a host:port address", c.Address))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// envName returns the environment variable of a setting, e.g. CARS_CONNECTION_PROFILE
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
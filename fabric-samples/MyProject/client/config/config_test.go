@@ -0,0 +1,121 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+// writeFile writes the content to the file in dir and returns its path
+func writeFile(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	require.NoError(t, err)
+	return path
+}
+
+// clearEnv unsets every setting in the environment for the duration of the test
+func clearEnv(t *testing.T) {
+	for _, name := range append([]string{"config"}, settingNames()...) {
+		env := envName(name)
+		if value, ok := os.LookupEnv(env); ok {
+			require.NoError(t, os.Unsetenv(env))
+			t.Cleanup(func() { os.Setenv(env, value) })
+		}
+	}
+}
+
+func settingNames() []string {
+	names := []string{}
+	for _, s := range settings {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+func TestLoadOverridesFileWithEnvAndFlags(t *testing.T) {
+	clearEnv(t)
+	dir := t.TempDir()
+	profile := writeFile(t, dir, "connection.yaml", "")
+	configFile := writeFile(t, dir, "config.yaml", `
+connectionProfile: `+profile+`
+channel: filechannel
+chaincode: filechaincode
+address: ":8080"
+initLedger: true
+identities:
+  - label: org1User
+    mspId: Org1MSP
+    credentials: msp
+    connectionProfile: `+profile+`
+    peers:
+      - peer0.org1.example.com
+`)
+	t.Setenv("CARS_CHAINCODE", "envchaincode")
+	t.Setenv("CARS_ADDRESS", ":7070")
+	t.Setenv("CARS_JWT_SECRET", testSecret)
+
+	c, err := Load([]string{"-config", configFile, "-address", ":6060", "-init-ledger=false"})
+	require.NoError(t, err)
+	require.Equal(t, "filechannel", c.Channel)
+	require.Equal(t, "envchaincode", c.Chaincode)
+	require.Equal(t, ":6060", c.Address)
+	require.False(t, c.InitLedger)
+	require.Equal(t, testSecret, c.JWTSecret)
+	// flags that are not given keep the value of the file and the environment
+	require.Equal(t, "Org4MSP", c.MSPID)
+
+	identities := c.AllIdentities()
+	require.Len(t, identities, 2)
+	require.Equal(t, Identity{Label: "appUser", MSPID: "Org4MSP", Credentials: c.Credentials, ConnectionProfile: profile, Peers: []string{"peer0.org4.example.com"}}, identities[0])
+	require.Equal(t, Identity{Label: "org1User", MSPID: "Org1MSP", Credentials: "msp", ConnectionProfile: profile, Peers: []string{"peer0.org1.example.com"}}, identities[1])
+}
+
+func TestLoadRejectsUnknownFileSettings(t *testing.T) {
+	clearEnv(t)
+	configFile := writeFile(t, t.TempDir(), "config.yaml", "chanel: mychannel\n")
+
+	_, err := Load([]string{"-config", configFile})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid configuration file")
+}
+
+func TestLoadRejectsInvalidBoolean(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("CARS_INIT_LEDGER", "maybe")
+
+	_, err := Load(nil)
+	require.EqualError(t, err, "CARS_INIT_LEDGER must be true or false")
+}
+
+func TestValidate(t *testing.T) {
+	profile := writeFile(t, t.TempDir(), "connection.yaml", "")
+
+	c := Default()
+	c.ConnectionProfile = profile
+	c.JWTSecret = testSecret
+	require.NoError(t, c.Validate())
+
+	c.Channel = ""
+	c.JWTSecret = "short"
+	c.Address = "9090"
+	c.Identities = []Identity{
+		{Label: "appUser", MSPID: "Org1MSP", Credentials: "msp", ConnectionProfile: profile},
+		{Label: "org2User"},
+	}
+	require.EqualError(t, c.Validate(), "invalid configuration: channel must be set; "+
+		"identity appUser is configured more than once; "+
+		"identity 2 must set label, mspId, credentials and connectionProfile; "+
+		"jwt-secret must be at least 32 bytes; "+
+		"address 9090 is not a host:port address")
+
+	c = Default()
+	c.ConnectionProfile = filepath.Join(t.TempDir(), "missing.yaml")
+	c.JWTSecret = testSecret
+	require.EqualError(t, c.Validate(), "invalid configuration: connection profile "+c.ConnectionProfile+" does not exist")
+}
@@ -0,0 +1,54 @@
+package data
+
+import "time"
+
+// Status of an auction
+const (
+	AuctionOpen   = "open"
+	AuctionClosed = "closed"
+	AuctionEnded  = "ended"
+)
+
+// Auction is the public state of the sealed-bid auction of a car. Bids are sealed until
+// the auction is closed; only their hashes are public until they are revealed.
+type Auction struct {
+	CarId        string
+	SellerId     string
+	ReservePrice Money
+	Status       string
+	SealedBids   map[string]SealedBid
+	RevealedBids map[string]RevealedBid
+	WinnerId     string `json:",omitempty"`
+	Price        *Money `json:",omitempty"`
+	CreatedAt    time.Time
+}
+
+// SealedBid is the hash of a bid and the organization whose collection holds the bid
+type SealedBid struct {
+	MSPID string
+	Hash  string
+}
+
+// RevealedBid is a bid revealed after the auction was closed. Bids paid InTokens are not
+// escrowed.
+type RevealedBid struct {
+	BidderId string
+	Price    Money
+	InTokens bool `json:",omitempty"`
+}
+
+// AuctionBid is the bid passed to the chaincode in the transient field bid. It must be
+// revealed exactly as it was submitted.
+type AuctionBid struct {
+	CarId    string
+	BidderId string
+	Price    Money
+	Salt     string
+}
+
+// SubmittedBid is the response to a submitted bid. The bidder needs the bid ID and the
+// bid itself to reveal the bid once the auction is closed.
+type SubmittedBid struct {
+	BidId string
+	Bid   AuctionBid
+}
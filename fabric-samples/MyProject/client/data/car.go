@@ -2,14 +2,60 @@ package data
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Money is an amount in the minor unit of its currency, e.g. cents for EUR
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// Status of a malfunction. Fixed malfunctions stay in the list as the service history.
+const (
+	MalfunctionReported = "reported"
+	MalfunctionQuoted   = "quoted"
+	MalfunctionInRepair = "in-repair"
+	MalfunctionFixed    = "fixed"
+)
+
+// Severity of a malfunction
+const (
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+// CarMalfunction is an entry of the service history of a car. Severity is empty when the
+// malfunction was not assessed.
 type CarMalfunction struct {
+	Id          string
 	Description string
-	RepairPrice float32
+	Severity    string `json:",omitempty"`
+	Status      string
+	RepairPrice Money
+	ReportedBy  string `json:",omitempty"`
+	ReportedAt  time.Time
+	FixedAt     *time.Time `json:",omitempty"`
 }
 
+// CarScrapped is the Status of a car whose repairs exceeded its price. The car can no longer
+// be sold, changed or repaired.
+const CarScrapped = "scrapped"
+
+// CarTransfer is the price a car was last sold at and the time of the sale
+type CarTransfer struct {
+	Price Money
+	At    time.Time
+}
+
+// Car is in service while Status is empty. LastTransfer is empty until the car is sold
+// for the first time.
 type Car struct {
 	Id              string
 	Brand           string
@@ -17,16 +63,96 @@ type Car struct {
 	Year            int
 	Colour          string
 	OwnerId         string
-	Price           float32
+	Price           Money
 	MalfunctionList []CarMalfunction
+	Status          string       `json:",omitempty"`
+	ScrappedAt      *time.Time   `json:",omitempty"`
+	ScrapValue      *Money       `json:",omitempty"`
+	LastTransfer    *CarTransfer `json:",omitempty"`
 }
 
+// CarValuation is the value of a car as returned by GetCarValuation. The price depreciated
+// by age and brand, averaged with the depreciated last transfer price, is reduced by the
+// malfunction history and the unpaid repairs, but never below the scrap value.
+type CarValuation struct {
+	CarId            string
+	ListPrice        Money
+	AgeYears         int
+	LastTransfer     *CarTransfer `json:",omitempty"`
+	DepreciatedPrice Money
+	HistoryDeduction Money
+	RepairPrice      Money
+	Value            Money
+	ValuedAt         time.Time
+}
+
+// Person as returned by QueryPerson. Name, Surname, Email and Money are private data of the
+// person's organization and are readable only through peers of that organization. A person
+// linked to a TokenAccount pays in tokens for every car the person buys and every repair.
 type Person struct {
-	Id      string
-	Name    string
-	Surname string
-	Email   string
-	Money   float32
+	Id           string
+	Name         string
+	Surname      string
+	Email        string
+	Money        Money
+	ClientId     string
+	MSPID        string
+	TokenAccount string `json:",omitempty"`
+}
+
+// Public returns the part of the person everyone may read, without the details and the
+// balance
+func (p *Person) Public() *Person {
+	return &Person{Id: p.Id, ClientId: p.ClientId, MSPID: p.MSPID, TokenAccount: p.TokenAccount}
+}
+
+// CarHistoryEntry is a single version of a car as returned by GetCarHistory
+type CarHistoryEntry struct {
+	Record    *Car
+	TxId      string
+	Timestamp time.Time
+	IsDelete  bool
+}
+
+// CarPage is a single page of cars. Bookmark is passed back to fetch the next page.
+type CarPage struct {
+	Records             []*Car
+	FetchedRecordsCount int32
+	Bookmark            string
+}
+
+// String formats the amount with two decimal places, e.g. "100.50 EUR"
+func (m Money) String() string {
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	return fmt.Sprintf("%s%d.%02d %s", sign, amount/100, amount%100, m.Currency)
+}
+
+// ParseAmount converts a decimal amount with at most two decimal places,
+// e.g. "40.5", into minor units (4050)
+func ParseAmount(s string) (int64, error) {
+	whole, fraction, _ := strings.Cut(s, ".")
+	if len(fraction) > 2 {
+		return 0, fmt.Errorf("amount %s has more than two decimal places", s)
+	}
+	if strings.Trim(whole, "+-") == "" || strings.Trim(fraction, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid amount %s", s)
+	}
+	for len(fraction) < 2 {
+		fraction += "0"
+	}
+
+	amount, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %s", s)
+	}
+
+	return amount, nil
 }
 
 func (p *Car) ToJSON(w io.Writer) error {
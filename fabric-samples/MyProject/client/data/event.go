@@ -0,0 +1,120 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Names of the events emitted by the chaincode, one per transaction
+const (
+	CarCreatedEvent          = "CarCreated"
+	CarUpdatedEvent          = "CarUpdated"
+	CarTransferredEvent      = "CarTransferred"
+	CarRecolouredEvent       = "CarRecoloured"
+	MalfunctionAddedEvent    = "MalfunctionAdded"
+	MalfunctionReportedEvent = "MalfunctionReported"
+	MalfunctionQuotedEvent   = "MalfunctionQuoted"
+	RepairOrderedEvent       = "RepairOrdered"
+	MalfunctionFixedEvent    = "MalfunctionFixed"
+	CarRepairedEvent         = "CarRepaired"
+	CarScrappedEvent         = "CarScrapped"
+	CarDeletedEvent          = "CarDeleted"
+	PersonCreatedEvent       = "PersonCreated"
+	PersonUpdatedEvent       = "PersonUpdated"
+	PersonDeletedEvent       = "PersonDeleted"
+	MoneyDepositedEvent      = "MoneyDeposited"
+	MoneyWithdrawnEvent      = "MoneyWithdrawn"
+	CarListedEvent           = "CarListed"
+	SaleCancelledEvent       = "SaleCancelled"
+	OfferMadeEvent           = "OfferMade"
+	OfferCancelledEvent      = "OfferCancelled"
+	AuctionCreatedEvent      = "AuctionCreated"
+	BidSubmittedEvent        = "BidSubmitted"
+	AuctionClosedEvent       = "AuctionClosed"
+	BidRevealedEvent         = "BidRevealed"
+	AuctionEndedEvent        = "AuctionEnded"
+	TokenSettlementSetEvent  = "TokenSettlementSet"
+)
+
+// BlockCommittedEvent is sent by the event feed for every block committed to the channel
+const BlockCommittedEvent = "BlockCommitted"
+
+// FeedEvent is a single message of the event feed. Chaincode events carry the payload
+// set by the chaincode, BlockCommitted events only the block number.
+type FeedEvent struct {
+	EventName   string
+	BlockNumber uint64
+	TxId        string          `json:",omitempty"`
+	CarId       string          `json:",omitempty"`
+	PersonId    string          `json:",omitempty"`
+	Payload     json.RawMessage `json:",omitempty"`
+}
+
+// CarEvent is the payload of car events. Before is nil for CarCreated and
+// After is nil for CarDeleted.
+type CarEvent struct {
+	CarId     string
+	Timestamp time.Time
+	Before    *Car `json:",omitempty"`
+	After     *Car `json:",omitempty"`
+}
+
+// PersonEvent is the payload of person events. Before is nil for PersonCreated
+// and After is nil for PersonDeleted. Events carry only the public part of the
+// person; the details, the balance and the amounts of deposits and withdrawals are
+// private data.
+type PersonEvent struct {
+	PersonId  string
+	Timestamp time.Time
+	Before    *Person `json:",omitempty"`
+	After     *Person `json:",omitempty"`
+}
+
+// SaleEvent is the payload of sale events. Offer is nil for CarListed and SaleCancelled
+// and Listing is nil for OfferCancelled.
+type SaleEvent struct {
+	CarId     string
+	Timestamp time.Time
+	Listing   *SaleListing `json:",omitempty"`
+	Offer     *Offer       `json:",omitempty"`
+}
+
+// AuctionEvent is the payload of auction events. Car is set only by AuctionEnded when the
+// auction ends with a winner and is the car transferred to the winner; no CarTransferred
+// event is sent for it.
+type AuctionEvent struct {
+	CarId     string
+	Timestamp time.Time
+	Auction   *Auction
+	Car       *Car `json:",omitempty"`
+}
+
+// DecodeEvent decodes the payload of the named event into a *CarEvent, a *PersonEvent,
+// a *SaleEvent, an *AuctionEvent or a *TokenSettlement
+func DecodeEvent(name string, payload []byte) (interface{}, error) {
+	var event interface{}
+	switch name {
+	case CarCreatedEvent, CarUpdatedEvent, CarTransferredEvent, CarRecolouredEvent,
+		MalfunctionAddedEvent, MalfunctionReportedEvent, MalfunctionQuotedEvent, RepairOrderedEvent,
+		MalfunctionFixedEvent, CarRepairedEvent, CarScrappedEvent, CarDeletedEvent:
+		event = &CarEvent{}
+	case PersonCreatedEvent, PersonUpdatedEvent, PersonDeletedEvent, MoneyDepositedEvent, MoneyWithdrawnEvent:
+		event = &PersonEvent{}
+	case CarListedEvent, SaleCancelledEvent, OfferMadeEvent, OfferCancelledEvent:
+		event = &SaleEvent{}
+	case AuctionCreatedEvent, BidSubmittedEvent, AuctionClosedEvent, BidRevealedEvent, AuctionEndedEvent:
+		event = &AuctionEvent{}
+	case TokenSettlementSetEvent:
+		event = &TokenSettlement{}
+	default:
+		return nil, fmt.Errorf("unknown event %s", name)
+	}
+
+	err := json.Unmarshal(payload, event)
+	if err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
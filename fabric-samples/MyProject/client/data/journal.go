@@ -0,0 +1,47 @@
+package data
+
+import "time"
+
+// JournalEntry is one leg of a posting, which debits one account and credits another by the
+// same amount under the same transaction. Accounts are person IDs or the external accounts
+// @cash, @escrow, @repairs and @scrap. Balance is the balance of a person after the leg; the
+// legs of external accounts have none.
+type JournalEntry struct {
+	TxId      string
+	Timestamp time.Time
+	Account   string
+	Side      string
+	Contra    string
+	Amount    Money
+	Balance   *Money `json:",omitempty"`
+	Memo      string
+}
+
+// Statement lists the journal entries of a person from From up to but excluding To
+type Statement struct {
+	PersonId       string
+	From           time.Time
+	To             time.Time
+	OpeningBalance Money
+	ClosingBalance Money
+	Entries        []JournalEntry
+}
+
+// Reconciliation compares the balance of a person with the sum of the person's journal.
+// Unbalanced lists the transactions of the person whose debits differ from their credits.
+type Reconciliation struct {
+	PersonId       string
+	Balance        Money
+	JournalBalance Money
+	Entries        int
+	Unbalanced     []string
+	Reconciled     bool
+}
+
+// AccountBalance is the balance of an external account in one currency, as journaled by the
+// organisation of the caller
+type AccountBalance struct {
+	Account string
+	Balance Money
+	Entries int
+}
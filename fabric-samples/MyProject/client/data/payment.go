@@ -0,0 +1,15 @@
+package data
+
+import "time"
+
+// Payment is an amount owed to the payee by a transaction that could not credit the
+// payee's balance, e.g. a refunded offer. The peers of the payee's organisation add it to
+// the balance once the payee collects it.
+type Payment struct {
+	PayeeId   string
+	Debit     string
+	Amount    Money
+	Memo      string
+	TxId      string
+	Timestamp time.Time
+}
@@ -0,0 +1,54 @@
+package data
+
+// Error codes of the chaincode, returned in the envelope of a rejected transaction
+const (
+	CodeNotFound          = "NOT_FOUND"
+	CodeAlreadyExists     = "ALREADY_EXISTS"
+	CodeAlreadyOwner      = "ALREADY_OWNER"
+	CodeHasMalfunctions   = "HAS_MALFUNCTIONS"
+	CodeNoMalfunctions    = "NO_MALFUNCTIONS"
+	CodeInsufficientFunds = "INSUFFICIENT_FUNDS"
+	CodeOwnsCars          = "OWNS_CARS"
+	CodeCurrencyMismatch  = "CURRENCY_MISMATCH"
+	CodeAmountOverflow    = "AMOUNT_OVERFLOW"
+	CodeInvalidArgument   = "INVALID_ARGUMENT"
+	CodeOfferExpired      = "OFFER_EXPIRED"
+	CodePriceMismatch     = "PRICE_MISMATCH"
+	CodeHashMismatch      = "HASH_MISMATCH"
+	CodeInvalidState      = "INVALID_STATE"
+	// CodeForbidden is also returned by the client when the role of the token does not
+	// allow the request
+	CodeForbidden = "FORBIDDEN"
+)
+
+// Error codes of the client itself
+const (
+	// CodeMVCCConflict is returned when the transaction was invalidated because another
+	// transaction changed the same keys first. Retrying the request usually succeeds.
+	CodeMVCCConflict     = "MVCC_CONFLICT"
+	CodeUnavailable      = "UNAVAILABLE"
+	CodeInvalidRequest   = "INVALID_REQUEST"
+	CodeValidationFailed = "VALIDATION_FAILED"
+	CodeUnsupportedMedia = "UNSUPPORTED_MEDIA_TYPE"
+	CodeUnknownIdentity  = "UNKNOWN_IDENTITY"
+	CodeUnauthenticated  = "UNAUTHENTICATED"
+	CodeInternal         = "INTERNAL"
+)
+
+// ContractError is the envelope of an error returned by the chaincode,
+// e.g. {"Code":"NOT_FOUND","Message":"car1 does not exist"}
+type ContractError struct {
+	Code    string
+	Message string
+}
+
+// Problem is the body of every error response, following RFC 7807 problem details.
+// Code and Errors are extension members.
+type Problem struct {
+	Type   string           `json:"type"`
+	Title  string           `json:"title"`
+	Status int              `json:"status"`
+	Detail string           `json:"detail,omitempty"`
+	Code   string           `json:"code"`
+	Errors ValidationErrors `json:"errors,omitempty"`
+}
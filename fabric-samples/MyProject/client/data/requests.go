@@ -0,0 +1,318 @@
+package data
+
+import (
+	"regexp"
+	"time"
+)
+
+// idPattern restricts ids to characters that are safe in URL paths and composite keys
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// currencyPattern matches ISO 4217 currency codes, e.g. EUR
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// FieldError describes why a single field of a request body is invalid
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// ValidationErrors collects the field errors of a request body
+type ValidationErrors []FieldError
+
+func (ve *ValidationErrors) add(field string, message string) {
+	*ve = append(*ve, FieldError{Field: field, Message: message})
+}
+
+func (ve *ValidationErrors) required(field string, value string) {
+	if value == "" {
+		ve.add(field, "is required")
+	}
+}
+
+func (ve *ValidationErrors) id(field string, value string) {
+	if !idPattern.MatchString(value) {
+		ve.add(field, "must be 1-64 letters, digits, '_', '.' or '-'")
+	}
+}
+
+func (ve *ValidationErrors) year(field string, year int) {
+	if year < 1886 || year > time.Now().Year()+1 {
+		ve.add(field, "is not a valid model year")
+	}
+}
+
+func (ve *ValidationErrors) money(field string, m Money) {
+	if m.Amount <= 0 {
+		ve.add(field+".Amount", "must be greater than zero")
+	}
+	if !currencyPattern.MatchString(m.Currency) {
+		ve.add(field+".Currency", "must be a three letter currency code")
+	}
+}
+
+func (ve *ValidationErrors) severity(field string, severity string) {
+	switch severity {
+	case SeverityLow, SeverityMedium, SeverityHigh, SeverityCritical:
+	default:
+		ve.add(field, "must be low, medium, high or critical")
+	}
+}
+
+// CreateCarRequest is the body of POST /cars
+type CreateCarRequest struct {
+	Id      string
+	Brand   string
+	Model   string
+	Year    int
+	Colour  string
+	OwnerId string
+	Price   Money
+}
+
+// Validate returns the field errors of the request
+func (r *CreateCarRequest) Validate() ValidationErrors {
+	errors := ValidationErrors{}
+	errors.id("Id", r.Id)
+	errors.required("Brand", r.Brand)
+	errors.required("Model", r.Model)
+	errors.year("Year", r.Year)
+	errors.required("Colour", r.Colour)
+	errors.id("OwnerId", r.OwnerId)
+	errors.money("Price", r.Price)
+	return errors
+}
+
+// UpdateCarRequest is the body of PATCH /cars/{id}. Only the fields present are changed.
+type UpdateCarRequest struct {
+	Brand  *string
+	Model  *string
+	Year   *int
+	Colour *string
+	Price  *Money
+}
+
+// Validate returns the field errors of the request
+func (r *UpdateCarRequest) Validate() ValidationErrors {
+	errors := ValidationErrors{}
+	if r.Brand == nil && r.Model == nil && r.Year == nil && r.Colour == nil && r.Price == nil {
+		errors.add("", "at least one field must be given")
+	}
+	if r.Brand != nil {
+		errors.required("Brand", *r.Brand)
+	}
+	if r.Model != nil {
+		errors.required("Model", *r.Model)
+	}
+	if r.Year != nil {
+		errors.year("Year", *r.Year)
+	}
+	if r.Colour != nil {
+		errors.required("Colour", *r.Colour)
+	}
+	if r.Price != nil {
+		errors.money("Price", *r.Price)
+	}
+	return errors
+}
+
+// Apply changes the car by the fields present in the request
+func (r *UpdateCarRequest) Apply(car *Car) {
+	if r.Brand != nil {
+		car.Brand = *r.Brand
+	}
+	if r.Model != nil {
+		car.Model = *r.Model
+	}
+	if r.Year != nil {
+		car.Year = *r.Year
+	}
+	if r.Colour != nil {
+		car.Colour = *r.Colour
+	}
+	if r.Price != nil {
+		car.Price = *r.Price
+	}
+}
+
+// TransferRequest is the body of POST /cars/{id}/transfers. With AtValuation the car is
+// sold at its valuation instead of its price less unpaid repairs.
+type TransferRequest struct {
+	NewOwnerId               string
+	AcceptCarWithMalfunction bool
+	AtValuation              bool
+}
+
+// Validate returns the field errors of the request
+func (r *TransferRequest) Validate() ValidationErrors {
+	errors := ValidationErrors{}
+	errors.id("NewOwnerId", r.NewOwnerId)
+	return errors
+}
+
+// MalfunctionRequest is the body of POST /cars/{id}/malfunctions. A malfunction with a
+// repair price is quoted at once and its severity may be omitted if it has not been
+// assessed; without a repair price it is only reported and its severity is required. The
+// repair price must be in the currency of the car.
+type MalfunctionRequest struct {
+	Description string
+	Severity    string
+	RepairPrice *Money
+}
+
+// Validate returns the field errors of the request
+func (r *MalfunctionRequest) Validate() ValidationErrors {
+	errors := ValidationErrors{}
+	errors.required("Description", r.Description)
+	if len(r.Description) > 500 {
+		errors.add("Description", "must be at most 500 characters")
+	}
+	if r.RepairPrice != nil {
+		errors.money("RepairPrice", *r.RepairPrice)
+	}
+	if r.RepairPrice == nil || r.Severity != "" {
+		errors.severity("Severity", r.Severity)
+	}
+	return errors
+}
+
+// QuoteRequest is the body of POST /cars/{id}/malfunctions/{malfunction}/quote. The repair
+// price must be in the currency of the car.
+type QuoteRequest struct {
+	RepairPrice Money
+}
+
+// Validate returns the field errors of the request
+func (r *QuoteRequest) Validate() ValidationErrors {
+	errors := ValidationErrors{}
+	errors.money("RepairPrice", r.RepairPrice)
+	return errors
+}
+
+// RepairRequest is the body of POST /cars/{id}/repairs
+type RepairRequest struct {
+	MalfunctionIds []string
+}
+
+// Validate returns the field errors of the request
+func (r *RepairRequest) Validate() ValidationErrors {
+	errors := ValidationErrors{}
+	if len(r.MalfunctionIds) == 0 {
+		errors.add("MalfunctionIds", "must not be empty")
+	}
+	for _, malfunctionId := range r.MalfunctionIds {
+		errors.id("MalfunctionIds", malfunctionId)
+	}
+	return errors
+}
+
+// ListCarRequest is the body of PUT /cars/{id}/sale. The asking price must be in the
+// currency of the car.
+type ListCarRequest struct {
+	AskingPrice Money
+}
+
+// Validate returns the field errors of the request
+func (r *ListCarRequest) Validate() ValidationErrors {
+	errors := ValidationErrors{}
+	errors.money("AskingPrice", r.AskingPrice)
+	return errors
+}
+
+// maxOfferValidForSeconds is the longest time an offer may be valid for, 30 days
+const maxOfferValidForSeconds = 30 * 24 * 60 * 60
+
+// OfferRequest is the body of POST /cars/{id}/sale/offers. The price must be in the
+// currency of the asking price.
+type OfferRequest struct {
+	BuyerId         string
+	Price           Money
+	ValidForSeconds int64
+}
+
+// Validate returns the field errors of the request
+func (r *OfferRequest) Validate() ValidationErrors {
+	errors := ValidationErrors{}
+	errors.id("BuyerId", r.BuyerId)
+	errors.money("Price", r.Price)
+	if r.ValidForSeconds <= 0 {
+		errors.add("ValidForSeconds", "must be greater than zero")
+	}
+	if r.ValidForSeconds > maxOfferValidForSeconds {
+		errors.add("ValidForSeconds", "must be at most 30 days")
+	}
+	return errors
+}
+
+// CreateAuctionRequest is the body of POST /cars/{id}/auction. The reserve price must be in
+// the currency of the car.
+type CreateAuctionRequest struct {
+	ReservePrice Money
+}
+
+// Validate returns the field errors of the request
+func (r *CreateAuctionRequest) Validate() ValidationErrors {
+	errors := ValidationErrors{}
+	errors.money("ReservePrice", r.ReservePrice)
+	return errors
+}
+
+// BidRequest is the body of POST /cars/{id}/auction/bids and of revealing the bid. The
+// salt keeps others from guessing the price from the hash of the bid, so it should be
+// random; revealing needs the same price and salt.
+type BidRequest struct {
+	BidderId string
+	Price    Money
+	Salt     string
+}
+
+// Validate returns the field errors of the request
+func (r *BidRequest) Validate() ValidationErrors {
+	errors := ValidationErrors{}
+	errors.id("BidderId", r.BidderId)
+	errors.money("Price", r.Price)
+	errors.required("Salt", r.Salt)
+	return errors
+}
+
+// MoneyRequest is the body of POST /persons/{id}/deposits and /persons/{id}/withdrawals.
+// The amount must be in the currency of the person's balance.
+type MoneyRequest struct {
+	Amount Money
+}
+
+// Validate returns the field errors of the request
+func (r *MoneyRequest) Validate() ValidationErrors {
+	errors := ValidationErrors{}
+	errors.money("Amount", r.Amount)
+	return errors
+}
+
+// TokenAccountRequest is the body of PUT /persons/{id}/token-account. An empty account
+// unlinks the person, who then pays from the balance again.
+type TokenAccountRequest struct {
+	TokenAccount string
+}
+
+// Validate returns the field errors of the request
+func (r *TokenAccountRequest) Validate() ValidationErrors {
+	return ValidationErrors{}
+}
+
+// TokenSettlementRequest is the body of PUT /settings/token
+type TokenSettlementRequest struct {
+	Chaincode     string
+	Currency      string
+	RepairAccount string
+}
+
+// Validate returns the field errors of the request
+func (r *TokenSettlementRequest) Validate() ValidationErrors {
+	errors := ValidationErrors{}
+	errors.required("Chaincode", r.Chaincode)
+	if !currencyPattern.MatchString(r.Currency) {
+		errors.add("Currency", "must be a three letter currency code")
+	}
+	errors.required("RepairAccount", r.RepairAccount)
+	return errors
+}
@@ -0,0 +1,29 @@
+package data
+
+import "time"
+
+// SaleListing is the owner's agreement to sell the car at the asking price
+type SaleListing struct {
+	CarId       string
+	SellerId    string
+	AskingPrice Money
+	ListedAt    time.Time
+}
+
+// Offer is the buyer's agreement to pay the price until the offer expires. The price stays
+// in escrow until the car is sold or the offer is refunded, as a Payment unless the buyer
+// cancels the offer. Offers paid InTokens are not escrowed.
+type Offer struct {
+	CarId     string
+	BuyerId   string
+	Price     Money
+	OfferedAt time.Time
+	ExpiresAt time.Time
+	InTokens  bool `json:",omitempty"`
+}
+
+// Sale is a listed car with the offers made for it, including expired ones
+type Sale struct {
+	Listing *SaleListing
+	Offers  []*Offer
+}
@@ -0,0 +1,86 @@
+package data
+
+import (
+	"encoding/json"
+)
+
+// CarSearch holds the criteria of a car search. Empty criteria are not applied.
+// Prices are in minor units of Currency.
+type CarSearch struct {
+	Brand           string
+	Model           string
+	MinYear         *int
+	MaxYear         *int
+	Currency        string
+	MinPrice        *int64
+	MaxPrice        *int64
+	HasMalfunctions *bool
+}
+
+// QueryString builds the CouchDB query of the search for the QueryCars transaction
+func (cs *CarSearch) QueryString() (string, error) {
+	selector := map[string]interface{}{}
+
+	if cs.Brand != "" {
+		selector["Brand"] = cs.Brand
+	}
+	if cs.Model != "" {
+		selector["Model"] = cs.Model
+	}
+	if year := rangeCondition(intPointer(cs.MinYear), intPointer(cs.MaxYear)); year != nil {
+		selector["Year"] = year
+	}
+	if cs.Currency != "" {
+		selector["Price.Currency"] = cs.Currency
+	}
+	if price := rangeCondition(cs.MinPrice, cs.MaxPrice); price != nil {
+		selector["Price.Amount"] = price
+	}
+	if cs.HasMalfunctions != nil {
+		// fixed malfunctions are service history, not malfunctions of the car
+		open := map[string]interface{}{
+			"$elemMatch": map[string]interface{}{"Status": map[string]interface{}{"$ne": MalfunctionFixed}},
+		}
+		if *cs.HasMalfunctions {
+			selector["MalfunctionList"] = open
+		} else {
+			selector["$or"] = []interface{}{
+				map[string]interface{}{"MalfunctionList": nil},
+				map[string]interface{}{"MalfunctionList": map[string]interface{}{"$not": open}},
+			}
+		}
+	}
+
+	query, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return "", err
+	}
+
+	return string(query), nil
+}
+
+// rangeCondition returns the $gte/$lte condition for the given bounds or nil if both are missing
+func rangeCondition(min *int64, max *int64) map[string]interface{} {
+	if min == nil && max == nil {
+		return nil
+	}
+
+	condition := map[string]interface{}{}
+	if min != nil {
+		condition["$gte"] = *min
+	}
+	if max != nil {
+		condition["$lte"] = *max
+	}
+
+	return condition
+}
+
+func intPointer(value *int) *int64 {
+	if value == nil {
+		return nil
+	}
+
+	converted := int64(*value)
+	return &converted
+}
@@ -0,0 +1,9 @@
+package data
+
+// TokenSettlement is the ERC-20 token chaincode that persons linked to a token account pay
+// with. One token pays one minor unit of Currency; repairs are paid to RepairAccount.
+type TokenSettlement struct {
+	Chaincode     string
+	Currency      string
+	RepairAccount string
+}
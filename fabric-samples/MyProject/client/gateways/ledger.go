@@ -0,0 +1,137 @@
+package gateways
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/event"
+	mspclient "github.com/hyperledger/fabric-sdk-go/pkg/client/msp"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/core"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
+	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fab/events/deliverclient/seek"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+)
+
+// Blocks delivers the blocks committed to the channel from fromBlock on, read as the
+// identity or as the default identity when label is empty, until the returned function is
+// called. Every call creates its own SDK, since an SDK shares one event client per channel
+// and that client keeps delivering from the block it first connected at.
+func (p *Pool) Blocks(label string, fromBlock uint64) (<-chan *fab.BlockEvent, func(), error) {
+	if label == "" {
+		label = p.defaultIdentity
+	}
+	configured, ok := p.identities[label]
+	if !ok {
+		return nil, nil, &UnknownIdentityError{Label: label}
+	}
+
+	credentials, err := p.wallet.Get(label)
+	if err != nil {
+		return nil, nil, err
+	}
+	identity, ok := credentials.(*gateway.X509Identity)
+	if !ok {
+		return nil, nil, fmt.Errorf("identity %s is not an X.509 identity", label)
+	}
+
+	sdk, err := fabsdk.New(gatewayProfile(filepath.Clean(configured.ConnectionProfile)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mspClient, err := mspclient.New(sdk.Context())
+	if err != nil {
+		sdk.Close()
+		return nil, nil, err
+	}
+	signingIdentity, err := mspClient.CreateSigningIdentity(
+		msp.WithCert([]byte(identity.Certificate())),
+		msp.WithPrivateKey([]byte(identity.Key())),
+	)
+	if err != nil {
+		sdk.Close()
+		return nil, nil, err
+	}
+
+	client, err := event.New(
+		sdk.ChannelContext(p.channel, fabsdk.WithIdentity(signingIdentity)),
+		event.WithBlockEvents(),
+		event.WithSeekType(seek.FromBlock),
+		event.WithBlockNum(fromBlock),
+	)
+	if err != nil {
+		sdk.Close()
+		return nil, nil, err
+	}
+
+	registration, blocks, err := client.RegisterBlockEvent()
+	if err != nil {
+		sdk.Close()
+		return nil, nil, err
+	}
+
+	return blocks, func() {
+		client.Unregister(registration)
+		sdk.Close()
+	}, nil
+}
+
+// gatewayProfile reads the connection profile and completes it the way the gateway does:
+// the peers of the client organization serve every channel and, with DISCOVERY_AS_LOCALHOST,
+// the discovered peers and orderers are reached on localhost
+func gatewayProfile(path string) core.ConfigProvider {
+	return func() ([]core.ConfigBackend, error) {
+		backends, err := config.FromFile(path)()
+		if err != nil {
+			return nil, err
+		}
+		if len(backends) != 1 {
+			return nil, fmt.Errorf("invalid connection profile %s", path)
+		}
+		return []core.ConfigBackend{&profileBackend{backends[0]}}, nil
+	}
+}
+
+type profileBackend struct {
+	core.ConfigBackend
+}
+
+func (b *profileBackend) Lookup(key string) (interface{}, bool) {
+	switch key {
+	case "entityMatchers":
+		if strings.ToUpper(os.Getenv("DISCOVERY_AS_LOCALHOST")) == "TRUE" {
+			mapping := map[string]string{
+				"pattern":                             `([^:]+):(\d+)`,
+				"urlSubstitutionExp":                  "localhost:${2}",
+				"sslTargetOverrideUrlSubstitutionExp": "${1}",
+				"mappedHost":                          "${1}",
+			}
+			return map[string][]map[string]string{"peer": {mapping}, "orderer": {mapping}}, true
+		}
+	case "channels":
+		if channels, ok := b.ConfigBackend.Lookup(key); ok {
+			return channels, true
+		}
+		organization, ok := b.ConfigBackend.Lookup("client.organization")
+		if !ok {
+			break
+		}
+		peers, ok := b.ConfigBackend.Lookup("organizations." + fmt.Sprint(organization) + ".peers")
+		if !ok {
+			break
+		}
+
+		roles := map[string]bool{"endorsingPeer": true, "chaincodeQuery": true, "ledgerQuery": true, "eventSource": true}
+		channelPeers := map[string]map[string]bool{}
+		for _, peer := range peers.([]interface{}) {
+			channelPeers[fmt.Sprint(peer)] = roles
+		}
+		return map[string]map[string]map[string]map[string]bool{"_default": {"peers": channelPeers}}, true
+	}
+	return b.ConfigBackend.Lookup(key)
+}
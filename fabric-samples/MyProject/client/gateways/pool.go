@@ -0,0 +1,179 @@
+package gateways
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	clientconfig "girhub.com/fist/chaincode/config"
+	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+)
+
+// UnknownIdentityError is returned for an identity that is not configured
+type UnknownIdentityError struct {
+	Label string
+}
+
+func (e *UnknownIdentityError) Error() string {
+	return fmt.Sprintf("unknown identity %s", e.Label)
+}
+
+// Pool keeps one gateway connection per identity. Connections are opened on first use
+// and shared by all requests of that identity.
+type Pool struct {
+	wallet          *gateway.Wallet
+	channel         string
+	chaincode       string
+	defaultIdentity string
+	identities      map[string]clientconfig.Identity
+
+	mu          sync.Mutex
+	connections map[string]*connection
+}
+
+type connection struct {
+	gateway  *gateway.Gateway
+	network  *gateway.Network
+	contract *gateway.Contract
+}
+
+// NewPool opens the wallet and adds every configured identity that it does not hold yet
+func NewPool(cfg *clientconfig.Config) (*Pool, error) {
+	wallet, err := gateway.NewFileSystemWallet(cfg.Wallet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create wallet: %v", err)
+	}
+
+	p := &Pool{
+		wallet:          wallet,
+		channel:         cfg.Channel,
+		chaincode:       cfg.Chaincode,
+		defaultIdentity: cfg.Identity,
+		identities:      map[string]clientconfig.Identity{},
+		connections:     map[string]*connection{},
+	}
+
+	for _, identity := range cfg.AllIdentities() {
+		if !wallet.Exists(identity.Label) {
+			err = populateWallet(wallet, identity)
+			if err != nil {
+				return nil, fmt.Errorf("failed to populate wallet with %s: %v", identity.Label, err)
+			}
+		}
+		p.identities[identity.Label] = identity
+	}
+
+	return p, nil
+}
+
+// Contract returns the contract as seen by the identity, or by the default identity
+// when label is empty
+func (p *Pool) Contract(label string) (*gateway.Contract, error) {
+	c, err := p.connect(label)
+	if err != nil {
+		return nil, err
+	}
+	return c.contract, nil
+}
+
+// Network returns the channel as seen by the identity, or by the default identity
+// when label is empty
+func (p *Pool) Network(label string) (*gateway.Network, error) {
+	c, err := p.connect(label)
+	if err != nil {
+		return nil, err
+	}
+	return c.network, nil
+}
+
+// Peers returns the peers of the organisation of the identity, or of the default identity
+// when label is empty
+func (p *Pool) Peers(label string) ([]string, error) {
+	if label == "" {
+		label = p.defaultIdentity
+	}
+	identity, ok := p.identities[label]
+	if !ok {
+		return nil, &UnknownIdentityError{Label: label}
+	}
+	return identity.Peers, nil
+}
+
+// Close closes every open connection
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for label, c := range p.connections {
+		c.gateway.Close()
+		delete(p.connections, label)
+	}
+}
+
+func (p *Pool) connect(label string) (*connection, error) {
+	if label == "" {
+		label = p.defaultIdentity
+	}
+	identity, ok := p.identities[label]
+	if !ok {
+		return nil, &UnknownIdentityError{Label: label}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if c, ok := p.connections[label]; ok {
+		return c, nil
+	}
+
+	gw, err := gateway.Connect(
+		gateway.WithConfig(config.FromFile(filepath.Clean(identity.ConnectionProfile))),
+		gateway.WithIdentity(p.wallet, label),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to gateway as %s: %v", label, err)
+	}
+
+	network, err := gw.GetNetwork(p.channel)
+	if err != nil {
+		gw.Close()
+		return nil, fmt.Errorf("failed to get network as %s: %v", label, err)
+	}
+
+	c := &connection{gateway: gw, network: network, contract: network.GetContract(p.chaincode)}
+	p.connections[label] = c
+	return c, nil
+}
+
+func populateWallet(wallet *gateway.Wallet, identity clientconfig.Identity) error {
+
+	credPath := identity.Credentials
+
+	certPath := filepath.Join(credPath, "signcerts", "cert.pem")
+	// read the certificate pem
+	cert, err := ioutil.ReadFile(filepath.Clean(certPath))
+	if err != nil {
+		return err
+	}
+
+	keyDir := filepath.Join(credPath, "keystore")
+	// there's a single file in this dir containing the private key
+	files, err := ioutil.ReadDir(keyDir)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		return fmt.Errorf("keystore folder should have contain one file")
+	}
+	keyPath := filepath.Join(keyDir, files[0].Name())
+	key, err := ioutil.ReadFile(filepath.Clean(keyPath))
+	if err != nil {
+		return err
+	}
+
+	x509Identity := gateway.NewX509Identity(identity.MSPID, string(cert), string(key))
+
+	return wallet.Put(identity.Label, x509Identity)
+}
@@ -0,0 +1,69 @@
+package gateways
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	clientconfig "girhub.com/fist/chaincode/config"
+	"github.com/stretchr/testify/require"
+)
+
+// newCredentials writes an MSP directory with the certificate and the keys
+func newCredentials(t *testing.T, keys ...string) string {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "signcerts"), 0700))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "keystore"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "signcerts", "cert.pem"), []byte("certificate"), 0600))
+	for _, key := range keys {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "keystore", key), []byte("key"), 0600))
+	}
+	return dir
+}
+
+func newConfig(t *testing.T) *clientconfig.Config {
+	cfg := clientconfig.Default()
+	cfg.Wallet = t.TempDir()
+	cfg.Credentials = newCredentials(t, "priv_sk")
+	cfg.Identities = []clientconfig.Identity{
+		{Label: "org1User", MSPID: "Org1MSP", Credentials: newCredentials(t, "priv_sk")},
+	}
+	return &cfg
+}
+
+func TestNewPoolPopulatesWallet(t *testing.T) {
+	cfg := newConfig(t)
+
+	p, err := NewPool(cfg)
+	require.NoError(t, err)
+	require.True(t, p.wallet.Exists("appUser"))
+	require.True(t, p.wallet.Exists("org1User"))
+
+	// identities already in the wallet are not imported again
+	cfg.Identities[0].Credentials = filepath.Join(t.TempDir(), "missing")
+	_, err = NewPool(cfg)
+	require.NoError(t, err)
+}
+
+func TestNewPoolRequiresSingleKey(t *testing.T) {
+	cfg := newConfig(t)
+	cfg.Identities[0].Credentials = newCredentials(t, "priv_sk", "other_sk")
+
+	_, err := NewPool(cfg)
+	require.EqualError(t, err, "failed to populate wallet with org1User: keystore folder should have contain one file")
+}
+
+func TestUnknownIdentity(t *testing.T) {
+	p, err := NewPool(newConfig(t))
+	require.NoError(t, err)
+
+	_, err = p.Contract("org2User")
+	require.Equal(t, &UnknownIdentityError{Label: "org2User"}, err)
+
+	_, err = p.Network("org2User")
+	require.EqualError(t, err, "unknown identity org2User")
+
+	_, _, err = p.Blocks("org2User", 0)
+	require.Equal(t, &UnknownIdentityError{Label: "org2User"}, err)
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"girhub.com/fist/chaincode/data"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+)
+
+// bidTransientKey is the transient field through which bids are passed to the chaincode,
+// so that the price never appears in the transaction arguments
+const bidTransientKey = "bid"
+
+// GetAuction returns the current or last auction of the car
+func (c *Cars) GetAuction(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	carId := mux.Vars(r)["id"]
+
+	c.l.Println("Handle GET car auction")
+
+	auction, err := c.queryAuction(contract, carId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, auction)
+}
+
+// CreateAuction opens a sealed-bid auction of the car with the reserve price of the JSON
+// body and returns the auction
+func (c *Cars) CreateAuction(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	carId := mux.Vars(r)["id"]
+
+	request := data.CreateAuctionRequest{}
+	if !decodeJSON(rw, r, &request) || !validate(rw, &request) {
+		return
+	}
+
+	c.l.Println("Handle POST car auction")
+
+	car, err := c.queryCar(contract, carId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+	if request.ReservePrice.Currency != car.Price.Currency {
+		writeCurrencyError(rw, "ReservePrice.Currency", car.Price.Currency, "the car")
+		return
+	}
+
+	_, err = contract.SubmitTransaction("CreateAuction", carId, strconv.FormatInt(request.ReservePrice.Amount, 10))
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Location", "/cars/"+carId+"/auction")
+	c.writeAuction(contract, rw, http.StatusCreated, carId)
+}
+
+// SubmitBid seals the bid of the JSON body in the open auction and returns the bid ID,
+// which is needed to reveal the bid. Callers other than admins may only bid for themselves.
+// The bid is kept by the peers of the identity's organisation only, before its hash is
+// committed to the auction.
+func (c *Cars) SubmitBid(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	carId := mux.Vars(r)["id"]
+
+	request := data.BidRequest{}
+	if !decodeJSON(rw, r, &request) || !validate(rw, &request) {
+		return
+	}
+	if !actsFor(rw, r, request.BidderId) {
+		return
+	}
+
+	c.l.Println("Handle POST car auction bid")
+
+	peers, ok := c.ownPeers(rw, r)
+	if !ok {
+		return
+	}
+
+	bid := data.AuctionBid{CarId: carId, BidderId: request.BidderId, Price: request.Price, Salt: request.Salt}
+	result, err := submitBid(contract, "SubmitBid", bid, peers, carId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+	bidId := string(result)
+
+	_, err = contract.SubmitTransaction("CommitBid", carId, request.BidderId, bidId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Location", "/cars/"+carId+"/auction")
+	writeJSON(rw, http.StatusCreated, data.SubmittedBid{BidId: bidId, Bid: bid})
+}
+
+// CloseAuction stops accepting bids, so that the bidders can reveal them
+func (c *Cars) CloseAuction(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	carId := mux.Vars(r)["id"]
+
+	c.l.Println("Handle POST car auction close")
+
+	_, err := contract.SubmitTransaction("CloseAuction", carId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	c.writeAuction(contract, rw, http.StatusOK, carId)
+}
+
+// RevealBid reveals the bid of the route in the closed auction. The JSON body must hold
+// the same bid that was submitted. The peers of the identity's organisation escrow the bid.
+func (c *Cars) RevealBid(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	carId := vars["id"]
+	bidId := vars["bid"]
+
+	request := data.BidRequest{}
+	if !decodeJSON(rw, r, &request) || !validate(rw, &request) {
+		return
+	}
+	if !actsFor(rw, r, request.BidderId) {
+		return
+	}
+
+	c.l.Println("Handle POST car auction bid reveal")
+
+	peers, ok := c.ownPeers(rw, r)
+	if !ok {
+		return
+	}
+
+	bid := data.AuctionBid{CarId: carId, BidderId: request.BidderId, Price: request.Price, Salt: request.Salt}
+	_, err := submitBid(contract, "RevealBid", bid, peers, carId, bidId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	c.writeAuction(contract, rw, http.StatusOK, carId)
+}
+
+// EndAuction ends the closed auction. When a revealed bid wins, the car is transferred to
+// the winner, whose escrowed bid is paid to the seller. The other bids are refunded as
+// payments.
+func (c *Cars) EndAuction(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	carId := mux.Vars(r)["id"]
+
+	c.l.Println("Handle POST car auction end")
+
+	_, err := c.submitOnOwnPeers(r, contract, "EndAuction", carId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	c.writeAuction(contract, rw, http.StatusOK, carId)
+}
+
+// submitBid submits the transaction with the bid in its transient data for endorsement by
+// the peers
+func submitBid(contract *gateway.Contract, name string, bid data.AuctionBid, peers []string, args ...string) ([]byte, error) {
+	bidAsBytes, err := json.Marshal(bid)
+	if err != nil {
+		return nil, err
+	}
+
+	transient := gateway.WithTransient(map[string][]byte{bidTransientKey: bidAsBytes})
+	transaction, err := contract.CreateTransaction(name, transient, gateway.WithEndorsingPeers(peers...))
+	if err != nil {
+		return nil, err
+	}
+
+	return transaction.Submit(args...)
+}
+
+// queryAuction reads the auction of the car
+func (c *Cars) queryAuction(contract *gateway.Contract, carId string) (*data.Auction, error) {
+	result, err := contract.EvaluateTransaction("QueryAuction", carId)
+	if err != nil {
+		return nil, err
+	}
+
+	auction := &data.Auction{}
+	err = json.Unmarshal(result, auction)
+	if err != nil {
+		return nil, err
+	}
+	return auction, nil
+}
+
+// writeAuction responds with the current state of the auction after a successful transaction
+func (c *Cars) writeAuction(contract *gateway.Contract, rw http.ResponseWriter, status int, carId string) {
+	auction, err := c.queryAuction(contract, carId)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to read the auction", nil)
+		return
+	}
+
+	writeJSON(rw, status, auction)
+}
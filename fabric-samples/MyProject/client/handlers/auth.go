@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"girhub.com/fist/chaincode/auth"
+	"girhub.com/fist/chaincode/data"
+	"github.com/gorilla/mux"
+)
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims of the authenticated caller or nil
+func ClaimsFromContext(ctx context.Context) *auth.Claims {
+	claims, _ := ctx.Value(claimsKey{}).(*auth.Claims)
+	return claims
+}
+
+// Authenticate is a middleware rejecting requests without a valid bearer token. The identity
+// claim of the token selects the wallet identity and is required for everyone but admins,
+// who may choose another one with IdentityHeader.
+func Authenticate(secret []byte) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			token := bearerToken(r)
+			if token == "" {
+				rw.Header().Set("WWW-Authenticate", `Bearer realm="cars"`)
+				writeError(rw, http.StatusUnauthorized, data.CodeUnauthenticated, "A bearer token is required", nil)
+				return
+			}
+
+			claims, err := auth.Verify(token, secret, time.Now())
+			if err != nil {
+				rw.Header().Set("WWW-Authenticate", `Bearer realm="cars", error="invalid_token"`)
+				writeError(rw, http.StatusUnauthorized, data.CodeUnauthenticated, err.Error(), nil)
+				return
+			}
+
+			if claims.Identity == "" && !claims.HasRole(auth.RoleAdmin) {
+				rw.Header().Set("WWW-Authenticate", `Bearer realm="cars", error="invalid_token"`)
+				writeError(rw, http.StatusUnauthorized, data.CodeUnauthenticated, "The token has no identity claim", nil)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), claimsKey{}, claims)
+			if claims.Identity != "" {
+				ctx = WithIdentity(ctx, claims.Identity)
+			}
+			next.ServeHTTP(rw, r.WithContext(ctx))
+		})
+	}
+}
+
+// bearerToken returns the token of the Authorization header. Browsers cannot set headers
+// on EventSource and WebSocket connections, so GET requests may pass it as access_token.
+func bearerToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	if r.Method == http.MethodGet {
+		return r.URL.Query().Get("access_token")
+	}
+	return ""
+}
+
+// RequireRole allows the request only when the caller has one of the roles
+func RequireRole(roles ...string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) {
+			claims := ClaimsFromContext(r.Context())
+			for _, role := range roles {
+				if claims != nil && claims.HasRole(role) {
+					next(rw, r)
+					return
+				}
+			}
+			writeError(rw, http.StatusForbidden, data.CodeForbidden, "One of the roles "+strings.Join(roles, ", ")+" is required", nil)
+		}
+	}
+}
+
+// mayActFor reports whether the caller is the person or an admin
+func mayActFor(r *http.Request, personId string) bool {
+	claims := ClaimsFromContext(r.Context())
+	return claims != nil && (claims.HasRole(auth.RoleAdmin) || claims.Subject == personId)
+}
+
+// actsFor reports whether the caller is the person. Money moves only on behalf of the
+// person, admins included. Otherwise the error response has already been written.
+func actsFor(rw http.ResponseWriter, r *http.Request, personId string) bool {
+	claims := ClaimsFromContext(r.Context())
+	if claims != nil && claims.Subject == personId {
+		return true
+	}
+	writeError(rw, http.StatusForbidden, data.CodeForbidden, "Only "+personId+" may do this", nil)
+	return false
+}
+
+// RequireCarOwner allows the request only when the caller owns the car of the route.
+// Admins are no exception, the chaincode lets only the owner sell, change or repair a car.
+func (c *Cars) RequireCarOwner(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		claims := ClaimsFromContext(r.Context())
+		if claims == nil || !claims.HasRole(auth.RoleOwner) {
+			writeError(rw, http.StatusForbidden, data.CodeForbidden, "The role owner is required", nil)
+			return
+		}
+
+		contract, ok := c.contract(rw, r)
+		if !ok {
+			return
+		}
+
+		vars := mux.Vars(r)
+		carId, ok := vars["id"]
+		if !ok {
+			carId = vars["car"]
+		}
+
+		car, err := c.queryCar(contract, carId)
+		if err != nil {
+			writeTransactionError(rw, err)
+			return
+		}
+		if car.OwnerId != claims.Subject {
+			writeError(rw, http.StatusForbidden, data.CodeForbidden, "Only the owner of the car may do this", nil)
+			return
+		}
+
+		next(rw, r)
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"girhub.com/fist/chaincode/auth"
+	"github.com/stretchr/testify/require"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// authenticate serves a request with a token of the claims and returns the response and
+// the wallet identity the request transacted as
+func authenticate(t *testing.T, claims *auth.Claims) (*httptest.ResponseRecorder, string) {
+	claims.ExpiresAt = time.Now().Add(time.Hour).Unix()
+	token, err := auth.Sign(claims, testSecret)
+	require.NoError(t, err)
+
+	identity := "not called"
+	handler := Authenticate(testSecret)(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		identity = IdentityFromContext(r.Context())
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/cars", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, r)
+
+	return rw, identity
+}
+
+func TestAuthenticateSelectsIdentity(t *testing.T) {
+	rw, identity := authenticate(t, &auth.Claims{Subject: "person1", Roles: []string{auth.RoleOwner}, Identity: "user1"})
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.Equal(t, "user1", identity)
+
+	// admins without an identity claim transact as the default identity
+	rw, identity = authenticate(t, &auth.Claims{Subject: "admin", Roles: []string{auth.RoleAdmin}})
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.Equal(t, "", identity)
+}
+
+func TestAuthenticateRequiresIdentityClaim(t *testing.T) {
+	rw, identity := authenticate(t, &auth.Claims{Subject: "person1", Roles: []string{auth.RoleOwner}})
+	require.Equal(t, http.StatusUnauthorized, rw.Code)
+	require.Contains(t, rw.Body.String(), "The token has no identity claim")
+	require.Equal(t, "not called", identity)
+}
@@ -5,39 +5,55 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"strings"
+	"net/url"
+	"strconv"
 
 	"girhub.com/fist/chaincode/data"
 	"github.com/gorilla/mux"
 	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
 )
 
+// defaultPageSize is the number of cars returned by GetCars when no pageSize is given
+const defaultPageSize = 10
+
 // Hello is a simple handler
 type Cars struct {
-	l        *log.Logger
-	contract *gateway.Contract
+	l         *log.Logger
+	contracts ContractProvider
+	evaluate  Transactor
+	submit    Transactor
 }
 
 // NewHello creates a new hello handler with the given logger
-func NewCars(l *log.Logger, contract *gateway.Contract) *Cars {
-	return &Cars{l, contract}
+func NewCars(l *log.Logger, contracts ContractProvider) *Cars {
+	return &Cars{l, contracts, evaluateOnPeers, submitOnPeers}
 }
 
 func (c *Cars) AddCarMalfunction(rw http.ResponseWriter, r *http.Request) {
 
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
 	vars := mux.Vars(r)
 	carId := vars["car"]
 	description := vars["description"]
-	repairPrice := vars["repairPrice"]
+	repairPrice, err := data.ParseAmount(vars["repairPrice"])
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, data.CodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	if repairPrice <= 0 {
+		writeError(rw, http.StatusBadRequest, data.CodeInvalidRequest, "the repair price must be greater than zero", nil)
+		return
+	}
 
 	c.l.Println("Handle AddCarMalfunction")
 
-	result, err := c.contract.SubmitTransaction("AddMalfunction", carId, description, repairPrice)
+	result, err := contract.SubmitTransaction("AddMalfunction", carId, description, strconv.FormatInt(repairPrice, 10))
 	if err != nil {
-		errors := strings.Split(err.Error(), ":")
-		message := fmt.Sprintf("Failed to evaluate transaction: %s\n", errors[len(errors)-1])
-		fmt.Printf(message)
-		http.Error(rw, message, http.StatusConflict)
+		writeTransactionError(rw, err)
 		return
 	}
 	fmt.Println(string(result))
@@ -46,17 +62,19 @@ func (c *Cars) AddCarMalfunction(rw http.ResponseWriter, r *http.Request) {
 
 func (c *Cars) RepairCar(rw http.ResponseWriter, r *http.Request) {
 
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
 	vars := mux.Vars(r)
 	carId := vars["car"]
 
 	c.l.Println("Handle repairCar")
 
-	result, err := c.contract.SubmitTransaction("RepairCar", carId)
+	result, err := c.submitOnOwnPeers(r, contract, "RepairCar", carId)
 	if err != nil {
-		errors := strings.Split(err.Error(), ":")
-		message := fmt.Sprintf("Failed to evaluate transaction: %s\n", errors[len(errors)-1])
-		fmt.Printf(message)
-		http.Error(rw, message, http.StatusConflict)
+		writeTransactionError(rw, err)
 		return
 	}
 	fmt.Println(string(result))
@@ -65,18 +83,20 @@ func (c *Cars) RepairCar(rw http.ResponseWriter, r *http.Request) {
 
 func (c *Cars) ChangeCarColor(rw http.ResponseWriter, r *http.Request) {
 
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
 	vars := mux.Vars(r)
 	carId := vars["car"]
 	newColour := vars["color"]
 
 	c.l.Println("Handle changeCarColor")
 
-	result, err := c.contract.SubmitTransaction("ChangeCarColour", carId, newColour)
+	result, err := contract.SubmitTransaction("ChangeCarColour", carId, newColour)
 	if err != nil {
-		errors := strings.Split(err.Error(), ":")
-		message := fmt.Sprintf("Failed to evaluate transaction: %s\n", errors[len(errors)-1])
-		fmt.Printf(message)
-		http.Error(rw, message, http.StatusConflict)
+		writeTransactionError(rw, err)
 		return
 	}
 	fmt.Println(string(result))
@@ -85,6 +105,11 @@ func (c *Cars) ChangeCarColor(rw http.ResponseWriter, r *http.Request) {
 
 func (c *Cars) TransferCarOwnership(rw http.ResponseWriter, r *http.Request) {
 
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
 	vars := mux.Vars(r)
 	carId := vars["car"]
 	newOwnerId := vars["owner"]
@@ -102,12 +127,9 @@ func (c *Cars) TransferCarOwnership(rw http.ResponseWriter, r *http.Request) {
 
 	c.l.Println("Handle transferCarOwnership")
 
-	result, err := c.contract.SubmitTransaction("ChangeOwner", carId, newOwnerId, fmt.Sprintf("%t", acceptMalfunctionedBool))
+	result, err := c.submitOnOwnPeers(r, contract, "ChangeOwner", carId, newOwnerId, fmt.Sprintf("%t", acceptMalfunctionedBool))
 	if err != nil {
-		errors := strings.Split(err.Error(), ":")
-		message := fmt.Sprintf("Failed to evaluate transaction: %s\n", errors[len(errors)-1])
-		fmt.Printf(message)
-		http.Error(rw, message, http.StatusConflict)
+		writeTransactionError(rw, err)
 		return
 	}
 	fmt.Println(string(result))
@@ -116,18 +138,20 @@ func (c *Cars) TransferCarOwnership(rw http.ResponseWriter, r *http.Request) {
 
 func (c *Cars) GetCarsByColorAndOwner(rw http.ResponseWriter, r *http.Request) {
 
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
 	vars := mux.Vars(r)
 	color := vars["color"]
 	ownerId := vars["owner"]
 
 	c.l.Println("Handle GET car by color & owner")
 
-	result, err := c.contract.EvaluateTransaction("QueryCarsByColorAndOwner", color, ownerId)
+	result, err := contract.EvaluateTransaction("QueryCarsByColorAndOwner", color, ownerId)
 	if err != nil {
-		errors := strings.Split(err.Error(), ":")
-		message := fmt.Sprintf("Failed to evaluate transaction: %s\n", errors[len(errors)-1])
-		fmt.Printf(message)
-		http.Error(rw, message, http.StatusConflict)
+		writeTransactionError(rw, err)
 		return
 	}
 	fmt.Println(string(result))
@@ -137,7 +161,7 @@ func (c *Cars) GetCarsByColorAndOwner(rw http.ResponseWriter, r *http.Request) {
 	err = json.Unmarshal(result, &cars)
 
 	if err != nil {
-		http.Error(rw, "Unable to marshal json", http.StatusInternalServerError)
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to marshal json", nil)
 	}
 
 	for _, car := range cars {
@@ -148,18 +172,20 @@ func (c *Cars) GetCarsByColorAndOwner(rw http.ResponseWriter, r *http.Request) {
 
 func (c *Cars) GetCarsByColor(rw http.ResponseWriter, r *http.Request) {
 
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
 	vars := mux.Vars(r)
 	color := vars["color"]
 
 	c.l.Println("Handle GET car by color")
 
-	result, err := c.contract.EvaluateTransaction("QueryCarsByColor", color)
+	result, err := contract.EvaluateTransaction("QueryCarsByColor", color)
 	if err != nil {
-		errors := strings.Split(err.Error(), ":")
-		message := fmt.Sprintf("Failed to evaluate transaction: %s\n", errors[len(errors)-1])
-		fmt.Printf(message)
-		http.Error(rw, message, http.StatusConflict)
-
+		writeTransactionError(rw, err)
+		return
 	}
 	fmt.Println(string(result))
 
@@ -168,7 +194,7 @@ func (c *Cars) GetCarsByColor(rw http.ResponseWriter, r *http.Request) {
 	err = json.Unmarshal(result, &cars)
 
 	if err != nil {
-		http.Error(rw, "Unable to marshal json", http.StatusInternalServerError)
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to marshal json", nil)
 	}
 
 	for _, car := range cars {
@@ -176,47 +202,56 @@ func (c *Cars) GetCarsByColor(rw http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetPerson responds with the person. Only the person and admins read the details and the
+// balance, everyone else gets the public part of the person.
 func (c *Cars) GetPerson(rw http.ResponseWriter, r *http.Request) {
 
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
 	vars := mux.Vars(r)
 	personId := vars["id"]
 
 	c.l.Println("Handle GET Person")
 
-	result, err := c.contract.EvaluateTransaction("QueryPerson", personId)
+	result, err := c.evaluateOnOwnPeers(r, contract, "QueryPerson", personId)
 	if err != nil {
-		errors := strings.Split(err.Error(), ":")
-		message := fmt.Sprintf("Failed to evaluate transaction: %s\n", errors[len(errors)-1])
-		fmt.Printf(message)
-		http.Error(rw, message, http.StatusConflict)
+		writeTransactionError(rw, err)
 		return
 	}
-	fmt.Println(string(result))
 
-	person := data.Person{}
+	person := &data.Person{}
 
-	err = json.Unmarshal(result, &person)
+	err = json.Unmarshal(result, person)
 
 	if err != nil {
-		http.Error(rw, "Unable to marshal json", http.StatusInternalServerError)
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to marshal json", nil)
+		return
 	}
 
+	if !mayActFor(r, personId) {
+		person = person.Public()
+	}
 	person.ToJSON(rw)
 }
 
 func (c *Cars) GetCar(rw http.ResponseWriter, r *http.Request) {
 
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
 	vars := mux.Vars(r)
 	carId := vars["id"]
 
 	c.l.Println("Handle GET Cars")
 
-	result, err := c.contract.EvaluateTransaction("QueryCar", carId)
+	result, err := contract.EvaluateTransaction("QueryCar", carId)
 	if err != nil {
-		errors := strings.Split(err.Error(), ":")
-		message := fmt.Sprintf("Failed to evaluate transaction: %s\n", errors[len(errors)-1])
-		fmt.Printf(message)
-		http.Error(rw, message, http.StatusConflict)
+		writeTransactionError(rw, err)
 		return
 	}
 	fmt.Println(string(result))
@@ -225,8 +260,377 @@ func (c *Cars) GetCar(rw http.ResponseWriter, r *http.Request) {
 	err = json.Unmarshal(result, &car)
 
 	if err != nil {
-		http.Error(rw, "Unable to marshal json", http.StatusInternalServerError)
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to marshal json", nil)
 	}
 
 	car.ToJSON(rw)
 }
+
+// GetCarValuation returns the current valuation of the car
+func (c *Cars) GetCarValuation(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	carId := mux.Vars(r)["id"]
+
+	c.l.Println("Handle GET car valuation")
+
+	result, err := contract.EvaluateTransaction("GetCarValuation", carId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	valuation := data.CarValuation{}
+	err = json.Unmarshal(result, &valuation)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to unmarshal json", nil)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, valuation)
+}
+
+func (c *Cars) GetCarHistory(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	carId := vars["id"]
+
+	c.l.Println("Handle GET car history")
+
+	result, err := contract.EvaluateTransaction("GetCarHistory", carId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	history := []data.CarHistoryEntry{}
+	err = json.Unmarshal(result, &history)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to unmarshal json", nil)
+		return
+	}
+
+	err = json.NewEncoder(rw).Encode(history)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to marshal json", nil)
+	}
+}
+
+// GetCars returns a page of cars, optionally filtered by the color and owner query parameters.
+// The bookmark of the returned page is passed as the bookmark query parameter to fetch the next one.
+func (c *Cars) GetCars(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	color := query.Get("color")
+	ownerId := query.Get("owner")
+	bookmark := query.Get("bookmark")
+
+	pageSize := int64(defaultPageSize)
+	if value := query.Get("pageSize"); value != "" {
+		var err error
+		pageSize, err = strconv.ParseInt(value, 10, 32)
+		if err != nil || pageSize <= 0 {
+			writeError(rw, http.StatusBadRequest, data.CodeInvalidRequest, "pageSize must be a positive number", nil)
+			return
+		}
+	}
+	pageSizeArg := strconv.FormatInt(pageSize, 10)
+
+	c.l.Println("Handle GET cars page")
+
+	var result []byte
+	var err error
+	switch {
+	case color != "" && ownerId != "":
+		result, err = contract.EvaluateTransaction("QueryCarsByColorAndOwnerWithPagination", color, ownerId, pageSizeArg, bookmark)
+	case color != "":
+		result, err = contract.EvaluateTransaction("QueryCarsByColorWithPagination", color, pageSizeArg, bookmark)
+	case ownerId != "":
+		result, err = contract.EvaluateTransaction("QueryCarsByOwnerWithPagination", ownerId, pageSizeArg, bookmark)
+	default:
+		result, err = contract.EvaluateTransaction("QueryAllCarsWithPagination", pageSizeArg, bookmark)
+	}
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	page := data.CarPage{}
+	err = json.Unmarshal(result, &page)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to unmarshal json", nil)
+		return
+	}
+
+	err = json.NewEncoder(rw).Encode(page)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to marshal json", nil)
+	}
+}
+
+// SearchCars returns the cars matching the brand, model, minYear, maxYear, currency,
+// minPrice, maxPrice and hasMalfunctions query parameters. The CouchDB selector is built
+// here, so callers never write Mango queries themselves.
+func (c *Cars) SearchCars(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	search, err := parseCarSearch(r.URL.Query())
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, data.CodeInvalidRequest, err.Error(), nil)
+		return
+	}
+
+	queryString, err := search.QueryString()
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to marshal json", nil)
+		return
+	}
+
+	c.l.Println("Handle GET car search")
+
+	result, err := contract.EvaluateTransaction("QueryCars", queryString)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	cars := []data.Car{}
+	err = json.Unmarshal(result, &cars)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to unmarshal json", nil)
+		return
+	}
+
+	err = json.NewEncoder(rw).Encode(cars)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to marshal json", nil)
+	}
+}
+
+// parseCarSearch reads the search criteria from the query parameters
+func parseCarSearch(query url.Values) (*data.CarSearch, error) {
+	search := &data.CarSearch{
+		Brand:    query.Get("brand"),
+		Model:    query.Get("model"),
+		Currency: query.Get("currency"),
+	}
+
+	for name, target := range map[string]**int{"minYear": &search.MinYear, "maxYear": &search.MaxYear} {
+		if value := query.Get(name); value != "" {
+			year, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a number", name)
+			}
+			*target = &year
+		}
+	}
+
+	for name, target := range map[string]**int64{"minPrice": &search.MinPrice, "maxPrice": &search.MaxPrice} {
+		if value := query.Get(name); value != "" {
+			price, err := data.ParseAmount(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			*target = &price
+		}
+	}
+
+	if value := query.Get("hasMalfunctions"); value != "" {
+		hasMalfunctions, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("hasMalfunctions must be true or false")
+		}
+		search.HasMalfunctions = &hasMalfunctions
+	}
+
+	return search, nil
+}
+
+// CreateCar creates the car described by the JSON body and returns it
+func (c *Cars) CreateCar(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	request := data.CreateCarRequest{}
+	if !decodeJSON(rw, r, &request) || !validate(rw, &request) {
+		return
+	}
+
+	c.l.Println("Handle POST car")
+
+	_, err := contract.SubmitTransaction("CreateCar", request.Id, request.Brand, request.Model, strconv.Itoa(request.Year),
+		request.Colour, request.OwnerId, strconv.FormatInt(request.Price.Amount, 10), request.Price.Currency)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Location", "/cars/"+request.Id)
+	c.writeCar(contract, rw, http.StatusCreated, request.Id)
+}
+
+// UpdateCar changes the fields of the car given in the JSON body and returns the car
+func (c *Cars) UpdateCar(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	carId := mux.Vars(r)["id"]
+
+	request := data.UpdateCarRequest{}
+	if !decodeJSON(rw, r, &request) || !validate(rw, &request) {
+		return
+	}
+
+	c.l.Println("Handle PATCH car")
+
+	car, err := c.queryCar(contract, carId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+	request.Apply(car)
+
+	_, err = contract.SubmitTransaction("UpdateCar", car.Id, car.Brand, car.Model, strconv.Itoa(car.Year),
+		car.Colour, strconv.FormatInt(car.Price.Amount, 10), car.Price.Currency)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	c.writeCar(contract, rw, http.StatusOK, carId)
+}
+
+// TransferCar sells the car to the new owner given in the JSON body and returns the car
+func (c *Cars) TransferCar(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	carId := mux.Vars(r)["id"]
+
+	request := data.TransferRequest{}
+	if !decodeJSON(rw, r, &request) || !validate(rw, &request) {
+		return
+	}
+
+	c.l.Println("Handle POST car transfer")
+
+	name := "ChangeOwner"
+	if request.AtValuation {
+		name = "ChangeOwnerAtValuation"
+	}
+
+	_, err := c.submitOnOwnPeers(r, contract, name, carId, request.NewOwnerId, strconv.FormatBool(request.AcceptCarWithMalfunction))
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	c.writeCar(contract, rw, http.StatusCreated, carId)
+}
+
+// ReportMalfunction records the malfunction given in the JSON body, quoted if it has a
+// repair price and otherwise only reported, and returns the car, which is scrapped when
+// the repairs exceed its price.
+func (c *Cars) ReportMalfunction(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	carId := mux.Vars(r)["id"]
+
+	request := data.MalfunctionRequest{}
+	if !decodeJSON(rw, r, &request) || !validate(rw, &request) {
+		return
+	}
+
+	c.l.Println("Handle POST car malfunction")
+
+	if request.RepairPrice == nil {
+		_, err := contract.SubmitTransaction("ReportMalfunction", carId, request.Description, request.Severity)
+		if err != nil {
+			writeTransactionError(rw, err)
+			return
+		}
+
+		c.writeCar(contract, rw, http.StatusCreated, carId)
+		return
+	}
+
+	car, err := c.queryCar(contract, carId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+	if request.RepairPrice.Currency != car.Price.Currency {
+		writeCurrencyError(rw, "RepairPrice.Currency", car.Price.Currency, "the car")
+		return
+	}
+
+	repairPrice := strconv.FormatInt(request.RepairPrice.Amount, 10)
+	if request.Severity != "" {
+		_, err = contract.SubmitTransaction("AddAssessedMalfunction", carId, request.Description, request.Severity, repairPrice)
+	} else {
+		_, err = contract.SubmitTransaction("AddMalfunction", carId, request.Description, repairPrice)
+	}
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	c.writeCar(contract, rw, http.StatusCreated, carId)
+}
+
+// queryCar reads the current state of the car
+func (c *Cars) queryCar(contract *gateway.Contract, carId string) (*data.Car, error) {
+	result, err := contract.EvaluateTransaction("QueryCar", carId)
+	if err != nil {
+		return nil, err
+	}
+
+	car := &data.Car{}
+	err = json.Unmarshal(result, car)
+	if err != nil {
+		return nil, err
+	}
+	return car, nil
+}
+
+// writeCar responds with the current state of the car after a successful transaction
+func (c *Cars) writeCar(contract *gateway.Contract, rw http.ResponseWriter, status int, carId string) {
+	car, err := c.queryCar(contract, carId)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to read the car", nil)
+		return
+	}
+
+	writeJSON(rw, status, car)
+}
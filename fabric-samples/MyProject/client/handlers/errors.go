@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"girhub.com/fist/chaincode/data"
+)
+
+// contractErrorStatus maps the error codes of the chaincode to HTTP statuses
+var contractErrorStatus = map[string]int{
+	data.CodeNotFound:          http.StatusNotFound,
+	data.CodeAlreadyExists:     http.StatusConflict,
+	data.CodeAlreadyOwner:      http.StatusConflict,
+	data.CodeHasMalfunctions:   http.StatusConflict,
+	data.CodeNoMalfunctions:    http.StatusConflict,
+	data.CodeInsufficientFunds: http.StatusPaymentRequired,
+	data.CodeOwnsCars:          http.StatusConflict,
+	data.CodeCurrencyMismatch:  http.StatusUnprocessableEntity,
+	data.CodeAmountOverflow:    http.StatusUnprocessableEntity,
+	data.CodeForbidden:         http.StatusForbidden,
+	data.CodeInvalidArgument:   http.StatusUnprocessableEntity,
+	data.CodeOfferExpired:      http.StatusConflict,
+	data.CodePriceMismatch:     http.StatusConflict,
+	data.CodeHashMismatch:      http.StatusConflict,
+	data.CodeInvalidState:      http.StatusConflict,
+}
+
+// unavailableErrors are parts of gateway errors that mean the network could not be reached
+var unavailableErrors = []string{"connection refused", "context deadline exceeded", "UNAVAILABLE", "no endorsement"}
+
+// writeError writes a problem details response
+func writeError(rw http.ResponseWriter, status int, code string, detail string, fieldErrors data.ValidationErrors) {
+	problem := data.Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+		Errors: fieldErrors,
+	}
+
+	rw.Header().Set("Content-Type", "application/problem+json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(problem)
+}
+
+// writeTransactionError writes the error of a submitted or evaluated transaction.
+// Rejections of the chaincode carry a ContractError envelope somewhere in the gateway
+// error; invalidated transactions and network failures are recognised by their text.
+func writeTransactionError(rw http.ResponseWriter, err error) {
+	message := err.Error()
+
+	if start := strings.Index(message, `{"Code":`); start >= 0 {
+		envelope := data.ContractError{}
+		if json.NewDecoder(strings.NewReader(message[start:])).Decode(&envelope) == nil {
+			status, ok := contractErrorStatus[envelope.Code]
+			if !ok {
+				status = http.StatusUnprocessableEntity
+			}
+			writeError(rw, status, envelope.Code, envelope.Message, nil)
+			return
+		}
+	}
+
+	if strings.Contains(message, "MVCC_READ_CONFLICT") || strings.Contains(message, "PHANTOM_READ_CONFLICT") {
+		rw.Header().Set("Retry-After", "1")
+		writeError(rw, http.StatusServiceUnavailable, data.CodeMVCCConflict, "The car was changed by another transaction, retry the request", nil)
+		return
+	}
+
+	for _, unavailable := range unavailableErrors {
+		if strings.Contains(message, unavailable) {
+			writeError(rw, http.StatusServiceUnavailable, data.CodeUnavailable, "The network is unavailable", nil)
+			return
+		}
+	}
+
+	errors := strings.Split(message, ":")
+	writeError(rw, http.StatusInternalServerError, data.CodeInternal, strings.TrimSpace(errors[len(errors)-1]), nil)
+}
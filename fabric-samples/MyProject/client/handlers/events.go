@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"girhub.com/fist/chaincode/data"
+	"golang.org/x/net/websocket"
+)
+
+// keepAliveInterval is how often an idle event stream sends a comment, so that
+// proxies do not close the connection
+const keepAliveInterval = 15 * time.Second
+
+// Events streams the events of the feed to HTTP clients
+type Events struct {
+	l    *log.Logger
+	feed *Feed
+}
+
+// NewEvents creates a new events handler for the feed
+func NewEvents(l *log.Logger, feed *Feed) *Events {
+	return &Events{l, feed}
+}
+
+// GetEvents streams events as Server-Sent Events. The name query parameter (repeated or
+// comma separated) and the car query parameter filter the events. A client resumes with the
+// fromBlock query parameter or the Last-Event-ID header sent by browsers on reconnect.
+func (e *Events) GetEvents(rw http.ResponseWriter, r *http.Request) {
+
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Streaming is not supported", nil)
+		return
+	}
+
+	s, from, err := parseSubscription(r)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, data.CodeInvalidRequest, err.Error(), nil)
+		return
+	}
+
+	e.l.Println("Handle GET events")
+
+	// the stream starts with the first event replayed from the ledger, or once the
+	// client is subscribed
+	started := false
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.Header().Set("Cache-Control", "no-cache")
+		rw.Header().Set("Connection", "keep-alive")
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	backlog, err := e.feed.subscribe(s, from, func(event *data.FeedEvent) error {
+		start()
+		err := writeServerSentEvent(rw, event)
+		flusher.Flush()
+		return err
+	})
+	if err != nil {
+		e.l.Printf("Unable to subscribe to the event feed: %v", err)
+		if !started {
+			writeError(rw, http.StatusBadGateway, data.CodeUnavailable, err.Error(), nil)
+		}
+		return
+	}
+	defer e.feed.unsubscribe(s)
+	start()
+
+	for _, event := range backlog {
+		err = writeServerSentEvent(rw, event)
+		if err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(rw, ": keep-alive\n\n")
+		case event, ok := <-s.events:
+			if !ok {
+				return
+			}
+			err = writeServerSentEvent(rw, event)
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// WebSocket returns the handler streaming events as JSON messages over a WebSocket.
+// It accepts the same query parameters as GetEvents.
+func (e *Events) WebSocket() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+
+		s, from, err := parseSubscription(r)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, data.CodeInvalidRequest, err.Error(), nil)
+			return
+		}
+
+		e.l.Println("Handle WebSocket events")
+
+		// websocket.Server accepts every origin, dashboards are served from other hosts
+		websocket.Server{Handler: func(ws *websocket.Conn) {
+			// the timeouts of the server apply to the hijacked connection as well
+			err := ws.SetDeadline(time.Time{})
+			if err != nil {
+				return
+			}
+
+			closed := make(chan struct{})
+			go func() {
+				// clients do not send messages, reading only detects a closed connection
+				var message string
+				for websocket.Message.Receive(ws, &message) == nil {
+				}
+				close(closed)
+			}()
+
+			backlog, err := e.feed.subscribe(s, from, func(event *data.FeedEvent) error {
+				return websocket.JSON.Send(ws, event)
+			})
+			if err != nil {
+				e.l.Printf("Unable to subscribe to the event feed: %v", err)
+				return
+			}
+			defer e.feed.unsubscribe(s)
+
+			for _, event := range backlog {
+				if websocket.JSON.Send(ws, event) != nil {
+					return
+				}
+			}
+
+			for {
+				select {
+				case <-closed:
+					return
+				case event, ok := <-s.events:
+					if !ok || websocket.JSON.Send(ws, event) != nil {
+						return
+					}
+				}
+			}
+		}}.ServeHTTP(rw, r)
+	})
+}
+
+// parseSubscription reads the filters and the resume point of the request
+func parseSubscription(r *http.Request) (*subscription, *resumePoint, error) {
+	query := r.URL.Query()
+
+	s := &subscription{names: map[string]bool{}, carId: query.Get("car")}
+	for _, value := range query["name"] {
+		for _, name := range strings.Split(value, ",") {
+			if name != "" {
+				s.names[name] = true
+			}
+		}
+	}
+
+	from, err := parseResumePoint(query.Get("fromBlock"), r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s, from, nil
+}
+
+// parseResumePoint reads the block to resume from. The Last-Event-ID header takes precedence
+// over the fromBlock query parameter, since it also identifies the last event received.
+func parseResumePoint(fromBlock string, lastEventId string) (*resumePoint, error) {
+	if lastEventId != "" {
+		block, txId, _ := strings.Cut(lastEventId, "/")
+		number, err := strconv.ParseUint(block, 10, 64)
+		if err != nil || txId == "" {
+			return nil, fmt.Errorf("invalid Last-Event-ID %s", lastEventId)
+		}
+		return &resumePoint{block: number, afterTxId: txId}, nil
+	}
+
+	if fromBlock != "" {
+		number, err := strconv.ParseUint(fromBlock, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("fromBlock must be a block number")
+		}
+		return &resumePoint{block: number}, nil
+	}
+
+	return nil, nil
+}
+
+// writeServerSentEvent writes a single event. Chaincode events get the id <block>/<txId>,
+// which the browser sends back as Last-Event-ID when it reconnects.
+func writeServerSentEvent(rw http.ResponseWriter, event *data.FeedEvent) error {
+	eventAsBytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if event.TxId != "" {
+		_, err = fmt.Fprintf(rw, "id: %d/%s\n", event.BlockNumber, event.TxId)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(rw, "event: %s\ndata: %s\n\n", event.EventName, eventAsBytes)
+	return err
+}
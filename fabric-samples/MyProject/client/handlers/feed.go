@@ -0,0 +1,436 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"girhub.com/fist/chaincode/data"
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+)
+
+const (
+	// feedBacklogSize is the number of recent events kept for clients resuming from a block.
+	// Clients resuming from an older block are replayed the blocks from the ledger.
+	feedBacklogSize = 1000
+	// subscriptionBufferSize is the number of events queued for a client before it is
+	// considered too slow and disconnected
+	subscriptionBufferSize = 64
+)
+
+// Ledger delivers the blocks committed to the channel from fromBlock on, until the
+// returned function is called
+type Ledger func(fromBlock uint64) (<-chan *fab.BlockEvent, func(), error)
+
+// Feed receives chaincode and block events from the gateway and fans them out to the
+// subscribed HTTP clients. The most recent events are kept in memory, so a client that
+// reconnects can resume from the block it has last seen. Older blocks are read from the
+// ledger again.
+//
+// The events of a block are published after its BlockCommitted event, in the order of
+// the transactions of the block.
+type Feed struct {
+	l             *log.Logger
+	ledger        Ledger
+	chaincode     string
+	mu            sync.Mutex
+	backlog       []*data.FeedEvent
+	oldestBlock   uint64
+	receivedBlock bool
+	subscriptions map[*subscription]struct{}
+}
+
+// subscription is a single connected client. events is closed when the client is
+// unsubscribed or falls too far behind.
+type subscription struct {
+	names  map[string]bool
+	carId  string
+	events chan *data.FeedEvent
+}
+
+// resumePoint is the position a client resumes from. Events of the block committed
+// up to and including afterTxId have already been received.
+type resumePoint struct {
+	block     uint64
+	afterTxId string
+	resumed   bool
+}
+
+// errReplayed stops the replay of the ledger once the backlog takes over
+var errReplayed = errors.New("replayed up to the backlog")
+
+// NewFeed creates an event feed that is started with Listen
+func NewFeed(l *log.Logger) *Feed {
+	return &Feed{l: l, subscriptions: map[*subscription]struct{}{}}
+}
+
+// Listen registers for the events of the network and the contract and publishes them until
+// the returned function is called. Clients resuming from blocks older than the backlog are
+// replayed the blocks delivered by the ledger.
+func (f *Feed) Listen(network *gateway.Network, contract *gateway.Contract, ledger Ledger) (func(), error) {
+	f.ledger = ledger
+	f.chaincode = contract.Name()
+
+	blockRegistration, blocks, err := network.RegisterBlockEvent()
+	if err != nil {
+		return nil, err
+	}
+
+	eventRegistration, events, err := contract.RegisterEvent(".*")
+	if err != nil {
+		network.Unregister(blockRegistration)
+		return nil, err
+	}
+
+	go f.merge(blocks, events)
+
+	return func() {
+		contract.Unregister(eventRegistration)
+		network.Unregister(blockRegistration)
+	}, nil
+}
+
+// merge publishes the blocks and chaincode events in ledger order until either channel is
+// closed. The SDK delivers a block before the chaincode events of its transactions, from a
+// single goroutine, so when a block is received the chaincode events of the earlier blocks
+// are already queued, and when a chaincode event is received its block is.
+func (f *Feed) merge(blocks <-chan *fab.BlockEvent, events <-chan *fab.CCEvent) {
+	var lastBlock uint64
+	receivedBlock := false
+
+	publishBlock := func(block *fab.BlockEvent) {
+		lastBlock = block.Block.Header.Number
+		receivedBlock = true
+		f.publish(&data.FeedEvent{EventName: data.BlockCommittedEvent, BlockNumber: lastBlock})
+	}
+
+	// publishEvent publishes the chaincode event once its block has been published
+	publishEvent := func(event *fab.CCEvent) bool {
+		for !receivedBlock || lastBlock < event.BlockNumber {
+			block, ok := <-blocks
+			if !ok {
+				return false
+			}
+			publishBlock(block)
+		}
+		f.publish(f.chaincodeEvent(event))
+		return true
+	}
+
+	for {
+		select {
+		case block, ok := <-blocks:
+			if !ok {
+				return
+			}
+			next, ok := f.publishQueued(events, block.Block.Header.Number)
+			if !ok {
+				return
+			}
+			publishBlock(block)
+			if next != nil && !publishEvent(next) {
+				return
+			}
+		case event, ok := <-events:
+			if !ok || !publishEvent(event) {
+				return
+			}
+		}
+	}
+}
+
+// publishQueued publishes the queued chaincode events of the blocks before number and
+// returns the first queued event of a later block, if any. It returns false when events
+// is closed.
+func (f *Feed) publishQueued(events <-chan *fab.CCEvent, number uint64) (*fab.CCEvent, bool) {
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil, false
+			}
+			if event.BlockNumber >= number {
+				return event, true
+			}
+			f.publish(f.chaincodeEvent(event))
+		default:
+			return nil, true
+		}
+	}
+}
+
+// blockEvents returns the BlockCommitted event of the block followed by the events of the
+// chaincode emitted by its valid transactions, in the order of the transactions. It is
+// used for the blocks replayed from the ledger.
+func (f *Feed) blockEvents(block *common.Block) []*data.FeedEvent {
+	number := block.GetHeader().GetNumber()
+
+	var validationCodes []byte
+	if metadata := block.GetMetadata().GetMetadata(); len(metadata) > int(common.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+		validationCodes = metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER]
+	}
+
+	events := []*data.FeedEvent{{EventName: data.BlockCommittedEvent, BlockNumber: number}}
+	for i, envelopeAsBytes := range block.GetData().GetData() {
+		// invalid transactions are committed to the block, but their events are not emitted
+		if i >= len(validationCodes) || peer.TxValidationCode(validationCodes[i]) != peer.TxValidationCode_VALID {
+			continue
+		}
+
+		event, txId, err := transactionEvent(envelopeAsBytes)
+		if err != nil {
+			f.l.Printf("Unable to read transaction %d of block %d: %v", i, number, err)
+			continue
+		}
+		if event == nil || event.ChaincodeId != f.chaincode {
+			continue
+		}
+
+		events = append(events, f.chaincodeEvent(&fab.CCEvent{
+			TxID:        txId,
+			ChaincodeID: event.ChaincodeId,
+			EventName:   event.EventName,
+			Payload:     event.Payload,
+			BlockNumber: number,
+		}))
+	}
+	return events
+}
+
+// transactionEvent returns the chaincode event of the endorser transaction in the envelope
+// and the transaction ID. The event is nil for other transactions and for transactions
+// without an event.
+func transactionEvent(envelopeAsBytes []byte) (*peer.ChaincodeEvent, string, error) {
+	envelope := &common.Envelope{}
+	err := proto.Unmarshal(envelopeAsBytes, envelope)
+	if err != nil {
+		return nil, "", err
+	}
+	payload := &common.Payload{}
+	err = proto.Unmarshal(envelope.Payload, payload)
+	if err != nil {
+		return nil, "", err
+	}
+	channelHeader := &common.ChannelHeader{}
+	err = proto.Unmarshal(payload.GetHeader().GetChannelHeader(), channelHeader)
+	if err != nil {
+		return nil, "", err
+	}
+	if common.HeaderType(channelHeader.Type) != common.HeaderType_ENDORSER_TRANSACTION {
+		return nil, channelHeader.TxId, nil
+	}
+
+	transaction := &peer.Transaction{}
+	err = proto.Unmarshal(payload.Data, transaction)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, action := range transaction.Actions {
+		actionPayload := &peer.ChaincodeActionPayload{}
+		err = proto.Unmarshal(action.Payload, actionPayload)
+		if err != nil {
+			return nil, "", err
+		}
+		responsePayload := &peer.ProposalResponsePayload{}
+		err = proto.Unmarshal(actionPayload.GetAction().GetProposalResponsePayload(), responsePayload)
+		if err != nil {
+			return nil, "", err
+		}
+		chaincodeAction := &peer.ChaincodeAction{}
+		err = proto.Unmarshal(responsePayload.Extension, chaincodeAction)
+		if err != nil {
+			return nil, "", err
+		}
+		event := &peer.ChaincodeEvent{}
+		err = proto.Unmarshal(chaincodeAction.Events, event)
+		if err != nil {
+			return nil, "", err
+		}
+		if event.EventName != "" {
+			return event, channelHeader.TxId, nil
+		}
+	}
+	return nil, channelHeader.TxId, nil
+}
+
+// chaincodeEvent converts a chaincode event, extracting the car or person it refers to
+func (f *Feed) chaincodeEvent(event *fab.CCEvent) *data.FeedEvent {
+	feedEvent := &data.FeedEvent{
+		EventName:   event.EventName,
+		BlockNumber: event.BlockNumber,
+		TxId:        event.TxID,
+		Payload:     event.Payload,
+	}
+
+	decoded, err := data.DecodeEvent(event.EventName, event.Payload)
+	if err != nil {
+		f.l.Printf("Unable to decode event %s of transaction %s: %v", event.EventName, event.TxID, err)
+		return feedEvent
+	}
+
+	switch decoded := decoded.(type) {
+	case *data.CarEvent:
+		feedEvent.CarId = decoded.CarId
+	case *data.PersonEvent:
+		feedEvent.PersonId = decoded.PersonId
+	case *data.SaleEvent:
+		feedEvent.CarId = decoded.CarId
+	case *data.AuctionEvent:
+		feedEvent.CarId = decoded.CarId
+	}
+	return feedEvent
+}
+
+// publish appends the event to the backlog and delivers it to every matching subscription.
+// Events are published in ledger order, so the backlog is ordered by block number.
+func (f *Feed) publish(event *data.FeedEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.receivedBlock {
+		f.oldestBlock = event.BlockNumber
+		f.receivedBlock = true
+	}
+	f.backlog = append(f.backlog, event)
+	if len(f.backlog) > feedBacklogSize {
+		dropped := f.backlog[len(f.backlog)-feedBacklogSize-1]
+		f.backlog = f.backlog[len(f.backlog)-feedBacklogSize:]
+		// a block whose first events were dropped is replayed from the ledger
+		f.oldestBlock = f.backlog[0].BlockNumber
+		if dropped.BlockNumber == f.oldestBlock {
+			f.oldestBlock++
+		}
+	}
+
+	for s := range f.subscriptions {
+		if !s.accepts(event) {
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+			f.l.Println("Disconnecting slow event feed client")
+			f.remove(s)
+		}
+	}
+}
+
+// subscribe registers a client and returns the events of the backlog it missed since the
+// resume point. When the resume point is older than the backlog, the missed blocks are
+// first read from the ledger and their events passed to replay. Events published
+// afterwards are delivered through the events channel of the subscription.
+func (f *Feed) subscribe(s *subscription, from *resumePoint, replay func(*data.FeedEvent) error) ([]*data.FeedEvent, error) {
+	f.mu.Lock()
+	if from == nil || f.receivedBlock && from.block >= f.oldestBlock {
+		defer f.mu.Unlock()
+		return f.register(s, from, 0), nil
+	}
+	f.mu.Unlock()
+
+	blocks, closeLedger, err := f.ledger(from.block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the ledger from block %d: %v", from.block, err)
+	}
+	defer closeLedger()
+
+	var backlog []*data.FeedEvent
+	for block := range blocks {
+		err = f.replayBlock(block.Block, func(event *data.FeedEvent) error {
+			if event.EventName == data.BlockCommittedEvent {
+				f.mu.Lock()
+				defer f.mu.Unlock()
+
+				// the backlog holds every event from this block on
+				if f.receivedBlock && event.BlockNumber >= f.oldestBlock {
+					backlog = f.register(s, from, event.BlockNumber)
+					return errReplayed
+				}
+			}
+			if !from.includes(event) || !s.accepts(event) {
+				return nil
+			}
+			return replay(event)
+		})
+		if err == errReplayed {
+			return backlog, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("the ledger stopped delivering blocks")
+}
+
+// replayBlock passes the events of the block to replay until it fails
+func (f *Feed) replayBlock(block *common.Block, replay func(*data.FeedEvent) error) error {
+	for _, event := range f.blockEvents(block) {
+		err := replay(event)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// register adds the subscription and returns the events of the backlog from fromBlock on
+// that it did not receive before the resume point. It is called with the lock held.
+func (f *Feed) register(s *subscription, from *resumePoint, fromBlock uint64) []*data.FeedEvent {
+	backlog := []*data.FeedEvent{}
+	if from != nil {
+		for _, event := range f.backlog {
+			if event.BlockNumber >= fromBlock && from.includes(event) && s.accepts(event) {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+
+	s.events = make(chan *data.FeedEvent, subscriptionBufferSize)
+	f.subscriptions[s] = struct{}{}
+	return backlog
+}
+
+// unsubscribe removes the client from the feed
+func (f *Feed) unsubscribe(s *subscription) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.remove(s)
+}
+
+func (f *Feed) remove(s *subscription) {
+	if _, ok := f.subscriptions[s]; ok {
+		delete(f.subscriptions, s)
+		close(s.events)
+	}
+}
+
+// accepts returns true when the event passes the name and car filters of the subscription.
+// BlockCommitted events do not refer to a car and pass the car filter.
+func (s *subscription) accepts(event *data.FeedEvent) bool {
+	if len(s.names) > 0 && !s.names[event.EventName] {
+		return false
+	}
+	if s.carId != "" && event.EventName != data.BlockCommittedEvent && event.CarId != s.carId {
+		return false
+	}
+	return true
+}
+
+// includes returns true when the event was committed after the resume point. Events are
+// passed in ledger order, until afterTxId is passed the events of the resume block are not
+// included.
+func (p *resumePoint) includes(event *data.FeedEvent) bool {
+	if event.BlockNumber < p.block {
+		return false
+	}
+	if event.BlockNumber == p.block && p.afterTxId != "" && !p.resumed {
+		p.resumed = event.TxId == p.afterTxId
+		return false
+	}
+	return true
+}
@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"girhub.com/fist/chaincode/data"
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+	"github.com/stretchr/testify/require"
+)
+
+// newTransaction returns the envelope of an endorser transaction emitting the chaincode event,
+// or no event when name is empty
+func newTransaction(t *testing.T, txId string, chaincode string, name string, payload string) []byte {
+	event, err := proto.Marshal(&peer.ChaincodeEvent{ChaincodeId: chaincode, TxId: txId, EventName: name, Payload: []byte(payload)})
+	require.NoError(t, err)
+	if name == "" {
+		event = nil
+	}
+	action, err := proto.Marshal(&peer.ChaincodeAction{Events: event})
+	require.NoError(t, err)
+	response, err := proto.Marshal(&peer.ProposalResponsePayload{Extension: action})
+	require.NoError(t, err)
+	actionPayload, err := proto.Marshal(&peer.ChaincodeActionPayload{Action: &peer.ChaincodeEndorsedAction{ProposalResponsePayload: response}})
+	require.NoError(t, err)
+	transaction, err := proto.Marshal(&peer.Transaction{Actions: []*peer.TransactionAction{{Payload: actionPayload}}})
+	require.NoError(t, err)
+
+	return newEnvelope(t, common.HeaderType_ENDORSER_TRANSACTION, txId, transaction)
+}
+
+func newEnvelope(t *testing.T, headerType common.HeaderType, txId string, data []byte) []byte {
+	channelHeader, err := proto.Marshal(&common.ChannelHeader{Type: int32(headerType), TxId: txId})
+	require.NoError(t, err)
+	payload, err := proto.Marshal(&common.Payload{Header: &common.Header{ChannelHeader: channelHeader}, Data: data})
+	require.NoError(t, err)
+	envelope, err := proto.Marshal(&common.Envelope{Payload: payload})
+	require.NoError(t, err)
+	return envelope
+}
+
+// newBlock returns a block of the transactions, all of which are valid unless listed as invalid
+func newBlock(number uint64, transactions [][]byte, invalid ...int) *common.Block {
+	validationCodes := make([]byte, len(transactions))
+	for _, i := range invalid {
+		validationCodes[i] = byte(peer.TxValidationCode_MVCC_READ_CONFLICT)
+	}
+	metadata := make([][]byte, len(common.BlockMetadataIndex_name))
+	metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER] = validationCodes
+
+	return &common.Block{
+		Header:   &common.BlockHeader{Number: number},
+		Data:     &common.BlockData{Data: transactions},
+		Metadata: &common.BlockMetadata{Metadata: metadata},
+	}
+}
+
+func newTestFeed() *Feed {
+	feed := NewFeed(log.New(ioutil.Discard, "", 0))
+	feed.chaincode = "basic"
+	return feed
+}
+
+// testLedger returns a ledger delivering the blocks from the requested block on
+func testLedger(blocks ...*common.Block) Ledger {
+	return func(fromBlock uint64) (<-chan *fab.BlockEvent, func(), error) {
+		delivered := make(chan *fab.BlockEvent, len(blocks))
+		for _, block := range blocks {
+			if block.Header.Number >= fromBlock {
+				delivered <- &fab.BlockEvent{Block: block}
+			}
+		}
+		close(delivered)
+		return delivered, func() {}, nil
+	}
+}
+
+func TestBlockEvents(t *testing.T) {
+	feed := newTestFeed()
+
+	block := newBlock(7, [][]byte{
+		newTransaction(t, "tx1", "basic", data.CarCreatedEvent, `{"CarId":"car1"}`),
+		newTransaction(t, "tx2", "basic", data.CarDeletedEvent, `{"CarId":"car2"}`),
+		newTransaction(t, "tx3", "other", data.CarCreatedEvent, `{"CarId":"car3"}`),
+		newTransaction(t, "tx4", "basic", "", ""),
+		newEnvelope(t, common.HeaderType_CONFIG, "tx5", nil),
+		newTransaction(t, "tx6", "basic", data.PersonCreatedEvent, `{"PersonId":"person1"}`),
+		[]byte("not an envelope"),
+	}, 1)
+
+	events := feed.blockEvents(block)
+	require.Equal(t, []*data.FeedEvent{
+		{EventName: data.BlockCommittedEvent, BlockNumber: 7},
+		{EventName: data.CarCreatedEvent, BlockNumber: 7, TxId: "tx1", CarId: "car1", Payload: []byte(`{"CarId":"car1"}`)},
+		{EventName: data.PersonCreatedEvent, BlockNumber: 7, TxId: "tx6", PersonId: "person1", Payload: []byte(`{"PersonId":"person1"}`)},
+	}, events)
+}
+
+func TestMergePublishesInLedgerOrder(t *testing.T) {
+	feed := newTestFeed()
+	blocks := make(chan *fab.BlockEvent, 3)
+	events := make(chan *fab.CCEvent, 3)
+
+	// the events of block 1 are queued when block 2 is received, block 2 is queued
+	// when its event is received
+	blocks <- &fab.BlockEvent{Block: newBlock(1, nil)}
+	events <- &fab.CCEvent{EventName: data.CarUpdatedEvent, BlockNumber: 1, TxID: "1"}
+	blocks <- &fab.BlockEvent{Block: newBlock(2, nil)}
+	events <- &fab.CCEvent{EventName: data.CarUpdatedEvent, BlockNumber: 2, TxID: "2"}
+	events <- &fab.CCEvent{EventName: data.CarUpdatedEvent, BlockNumber: 2, TxID: "3"}
+	blocks <- &fab.BlockEvent{Block: newBlock(3, nil)}
+
+	stopped := make(chan struct{})
+	go func() {
+		feed.merge(blocks, events)
+		close(stopped)
+	}()
+	require.Eventually(t, func() bool {
+		feed.mu.Lock()
+		defer feed.mu.Unlock()
+		return len(feed.backlog) == 6
+	}, time.Second, time.Millisecond)
+
+	close(blocks)
+	<-stopped
+	require.Equal(t, []string{data.BlockCommittedEvent, "1", data.BlockCommittedEvent, "2", "3", data.BlockCommittedEvent}, txIdsOf(feed.backlog))
+}
+
+// publishBlock publishes BlockCommitted followed by a chaincode event for each transaction
+func publishBlock(feed *Feed, number uint64, txIds ...string) {
+	feed.publish(&data.FeedEvent{EventName: data.BlockCommittedEvent, BlockNumber: number})
+	for _, txId := range txIds {
+		feed.publish(&data.FeedEvent{EventName: data.CarUpdatedEvent, BlockNumber: number, TxId: txId, CarId: "car" + txId})
+	}
+}
+
+func txIdsOf(events []*data.FeedEvent) []string {
+	txIds := []string{}
+	for _, event := range events {
+		if event.TxId == "" {
+			txIds = append(txIds, event.EventName)
+		} else {
+			txIds = append(txIds, event.TxId)
+		}
+	}
+	return txIds
+}
+
+// noReplay fails the test when the ledger is replayed
+func noReplay(t *testing.T) func(*data.FeedEvent) error {
+	return func(event *data.FeedEvent) error {
+		t.Fatalf("unexpected replay of block %d", event.BlockNumber)
+		return nil
+	}
+}
+
+func TestSubscribeResumes(t *testing.T) {
+	feed := newTestFeed()
+	publishBlock(feed, 5, "1", "2")
+	publishBlock(feed, 6, "3")
+
+	backlog, err := feed.subscribe(&subscription{}, nil, noReplay(t))
+	require.NoError(t, err)
+	require.Empty(t, backlog)
+
+	backlog, err = feed.subscribe(&subscription{}, &resumePoint{block: 5}, noReplay(t))
+	require.NoError(t, err)
+	require.Equal(t, []string{data.BlockCommittedEvent, "1", "2", data.BlockCommittedEvent, "3"}, txIdsOf(backlog))
+
+	backlog, err = feed.subscribe(&subscription{}, &resumePoint{block: 5, afterTxId: "1"}, noReplay(t))
+	require.NoError(t, err)
+	require.Equal(t, []string{"2", data.BlockCommittedEvent, "3"}, txIdsOf(backlog))
+
+	backlog, err = feed.subscribe(&subscription{carId: "car3"}, &resumePoint{block: 6}, noReplay(t))
+	require.NoError(t, err)
+	require.Equal(t, []string{data.BlockCommittedEvent, "3"}, txIdsOf(backlog))
+}
+
+func TestSubscribeReplaysLedger(t *testing.T) {
+	feed := newTestFeed()
+	feed.ledger = testLedger(
+		newBlock(3, [][]byte{newTransaction(t, "1", "basic", data.CarUpdatedEvent, `{"CarId":"car1"}`)}),
+		newBlock(4, [][]byte{
+			newTransaction(t, "2", "basic", data.CarUpdatedEvent, `{"CarId":"car2"}`),
+			newTransaction(t, "3", "basic", data.CarUpdatedEvent, `{"CarId":"car3"}`),
+		}),
+		newBlock(5, [][]byte{newTransaction(t, "4", "basic", data.CarUpdatedEvent, `{"CarId":"car4"}`)}),
+	)
+	publishBlock(feed, 5, "4")
+	publishBlock(feed, 6, "5")
+
+	replayed := []*data.FeedEvent{}
+	replay := func(event *data.FeedEvent) error {
+		replayed = append(replayed, event)
+		return nil
+	}
+
+	s := &subscription{}
+	backlog, err := feed.subscribe(s, &resumePoint{block: 3, afterTxId: "1"}, replay)
+	require.NoError(t, err)
+	require.Equal(t, []string{data.BlockCommittedEvent, "2", "3"}, txIdsOf(replayed))
+	require.Equal(t, []string{data.BlockCommittedEvent, "4", data.BlockCommittedEvent, "5"}, txIdsOf(backlog))
+	require.Contains(t, feed.subscriptions, s)
+
+	replayed = []*data.FeedEvent{}
+	_, err = feed.subscribe(&subscription{carId: "car3"}, &resumePoint{block: 4}, replay)
+	require.NoError(t, err)
+	require.Equal(t, []string{data.BlockCommittedEvent, "3"}, txIdsOf(replayed))
+}
+
+func TestSubscribeStopsReplayOnError(t *testing.T) {
+	feed := newTestFeed()
+	feed.ledger = testLedger(newBlock(1, nil), newBlock(2, nil))
+	publishBlock(feed, 2)
+
+	s := &subscription{}
+	_, err := feed.subscribe(s, &resumePoint{block: 1}, func(event *data.FeedEvent) error {
+		return errors.New("connection closed")
+	})
+	require.EqualError(t, err, "connection closed")
+	require.NotContains(t, feed.subscriptions, s)
+}
+
+func TestSubscribeFailsWhenLedgerStops(t *testing.T) {
+	feed := newTestFeed()
+	feed.ledger = testLedger(newBlock(1, nil))
+
+	_, err := feed.subscribe(&subscription{}, &resumePoint{block: 1}, func(event *data.FeedEvent) error {
+		return nil
+	})
+	require.EqualError(t, err, "the ledger stopped delivering blocks")
+}
+
+func TestBacklogIsTrimmed(t *testing.T) {
+	feed := newTestFeed()
+	publishBlock(feed, 1, "1", "2")
+	for i := 0; i < feedBacklogSize-2; i++ {
+		publishBlock(feed, 2)
+	}
+	require.Len(t, feed.backlog, feedBacklogSize)
+
+	// the BlockCommitted event of block 1 was dropped, so block 1 is replayed
+	require.Equal(t, uint64(2), feed.oldestBlock)
+	feed.ledger = testLedger(
+		newBlock(1, [][]byte{
+			newTransaction(t, "1", "basic", data.CarUpdatedEvent, `{"CarId":"car1"}`),
+			newTransaction(t, "2", "basic", data.CarUpdatedEvent, `{"CarId":"car2"}`),
+		}),
+		newBlock(2, nil),
+	)
+
+	replayed := []*data.FeedEvent{}
+	backlog, err := feed.subscribe(&subscription{}, &resumePoint{block: 1}, func(event *data.FeedEvent) error {
+		replayed = append(replayed, event)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{data.BlockCommittedEvent, "1", "2"}, txIdsOf(replayed))
+	require.Len(t, backlog, feedBacklogSize-2)
+}
+
+func TestPublishDelivers(t *testing.T) {
+	feed := newTestFeed()
+	all := &subscription{}
+	_, err := feed.subscribe(all, nil, noReplay(t))
+	require.NoError(t, err)
+	named := &subscription{names: map[string]bool{data.BlockCommittedEvent: true}}
+	_, err = feed.subscribe(named, nil, noReplay(t))
+	require.NoError(t, err)
+
+	publishBlock(feed, 1, "1")
+	require.Equal(t, data.BlockCommittedEvent, (<-all.events).EventName)
+	require.Equal(t, "1", (<-all.events).TxId)
+	require.Equal(t, data.BlockCommittedEvent, (<-named.events).EventName)
+	require.Empty(t, named.events)
+
+	feed.unsubscribe(named)
+	_, ok := <-named.events
+	require.False(t, ok)
+}
+
+func TestSlowSubscriptionIsRemoved(t *testing.T) {
+	feed := newTestFeed()
+	s := &subscription{}
+	_, err := feed.subscribe(s, nil, noReplay(t))
+	require.NoError(t, err)
+
+	for i := 0; i <= subscriptionBufferSize; i++ {
+		publishBlock(feed, uint64(i))
+	}
+	require.NotContains(t, feed.subscriptions, s)
+	for range s.events {
+	}
+}
+
+func TestParseResumePoint(t *testing.T) {
+	from, err := parseResumePoint("", "")
+	require.NoError(t, err)
+	require.Nil(t, from)
+
+	from, err = parseResumePoint("12", "")
+	require.NoError(t, err)
+	require.Equal(t, &resumePoint{block: 12}, from)
+
+	from, err = parseResumePoint("12", "14/tx9")
+	require.NoError(t, err)
+	require.Equal(t, &resumePoint{block: 14, afterTxId: "tx9"}, from)
+
+	_, err = parseResumePoint("twelve", "")
+	require.EqualError(t, err, "fromBlock must be a block number")
+
+	_, err = parseResumePoint("", "14")
+	require.EqualError(t, err, "invalid Last-Event-ID 14")
+}
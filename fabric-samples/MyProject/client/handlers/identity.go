@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"girhub.com/fist/chaincode/data"
+	"girhub.com/fist/chaincode/gateways"
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+)
+
+// IdentityHeader selects the wallet identity a request transacts as, e.g. org1Admin.
+// Requests without it use the default identity of the configuration.
+const IdentityHeader = "X-Fabric-Identity"
+
+// ContractProvider returns the contract as seen by a wallet identity and the peers of the
+// identity's organisation, or those of the default identity when the label is empty
+type ContractProvider interface {
+	Contract(label string) (*gateway.Contract, error)
+	Peers(label string) ([]string, error)
+}
+
+// Transactor evaluates or submits the named transaction of the contract. Unless peers is
+// empty, only the peers endorse it.
+type Transactor func(contract *gateway.Contract, peers []string, name string, args ...string) ([]byte, error)
+
+type identityKey struct{}
+
+// WithIdentity returns a context in which requests transact as the identity
+func WithIdentity(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, identityKey{}, label)
+}
+
+// IdentityFromContext returns the identity chosen for the request or an empty label
+func IdentityFromContext(ctx context.Context) string {
+	label, _ := ctx.Value(identityKey{}).(string)
+	return label
+}
+
+// IdentityFromHeader is a middleware choosing the identity of the request by IdentityHeader
+func IdentityFromHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if label := r.Header.Get(IdentityHeader); label != "" {
+			r = r.WithContext(WithIdentity(r.Context(), label))
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// contract returns the contract of the identity chosen for the request. On failure the
+// error response has already been written.
+func (c *Cars) contract(rw http.ResponseWriter, r *http.Request) (*gateway.Contract, bool) {
+	contract, err := c.contracts.Contract(IdentityFromContext(r.Context()))
+	if err != nil {
+		var unknown *gateways.UnknownIdentityError
+		if errors.As(err, &unknown) {
+			writeError(rw, http.StatusBadRequest, data.CodeUnknownIdentity, err.Error(), nil)
+			return nil, false
+		}
+		c.l.Println(err)
+		writeError(rw, http.StatusServiceUnavailable, data.CodeUnavailable, "The network is unavailable", nil)
+		return nil, false
+	}
+	return contract, true
+}
+
+// ownPeers returns the peers of the organisation of the identity chosen for the request,
+// which alone may endorse transactions writing its private data. On failure the error
+// response has already been written.
+func (c *Cars) ownPeers(rw http.ResponseWriter, r *http.Request) ([]string, bool) {
+	peers, err := c.contracts.Peers(IdentityFromContext(r.Context()))
+	if err != nil || len(peers) == 0 {
+		writeError(rw, http.StatusServiceUnavailable, data.CodeUnavailable, "No peers of the organisation are configured to endorse the transaction", nil)
+		return nil, false
+	}
+	return peers, true
+}
+
+// evaluateOnOwnPeers evaluates the transaction on the peers of the organisation of the
+// identity chosen for the request, which alone can read the details and balances of its
+// persons. Without configured peers the gateway chooses the peers.
+func (c *Cars) evaluateOnOwnPeers(r *http.Request, contract *gateway.Contract, name string, args ...string) ([]byte, error) {
+	peers, err := c.contracts.Peers(IdentityFromContext(r.Context()))
+	if err != nil {
+		return nil, err
+	}
+	return c.evaluate(contract, peers, name, args...)
+}
+
+// submitOnOwnPeers submits the transaction for endorsement by the peers of the organisation
+// of the identity chosen for the request, which alone can read and write the details and
+// balances of its persons. Without configured peers the gateway chooses the peers.
+func (c *Cars) submitOnOwnPeers(r *http.Request, contract *gateway.Contract, name string, args ...string) ([]byte, error) {
+	peers, err := c.contracts.Peers(IdentityFromContext(r.Context()))
+	if err != nil {
+		return nil, err
+	}
+	return c.submit(contract, peers, name, args...)
+}
+
+// evaluateOnPeers is the Transactor evaluating transactions
+func evaluateOnPeers(contract *gateway.Contract, peers []string, name string, args ...string) ([]byte, error) {
+	if len(peers) == 0 {
+		return contract.EvaluateTransaction(name, args...)
+	}
+
+	transaction, err := contract.CreateTransaction(name, gateway.WithEndorsingPeers(peers...))
+	if err != nil {
+		return nil, err
+	}
+	return transaction.Evaluate(args...)
+}
+
+// submitOnPeers is the Transactor submitting transactions
+func submitOnPeers(contract *gateway.Contract, peers []string, name string, args ...string) ([]byte, error) {
+	if len(peers) == 0 {
+		return contract.SubmitTransaction(name, args...)
+	}
+
+	transaction, err := contract.CreateTransaction(name, gateway.WithEndorsingPeers(peers...))
+	if err != nil {
+		return nil, err
+	}
+	return transaction.Submit(args...)
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"girhub.com/fist/chaincode/data"
+)
+
+// maxBodySize limits the size of JSON request bodies
+const maxBodySize = 1 << 20
+
+// decodeJSON reads the JSON body of the request into v. Unknown fields are rejected, so that
+// misspelled fields are reported instead of silently ignored. On failure the error
+// response has already been written.
+func decodeJSON(rw http.ResponseWriter, r *http.Request, v interface{}) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		writeError(rw, http.StatusUnsupportedMediaType, data.CodeUnsupportedMedia, "Content-Type must be application/json", nil)
+		return false
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(rw, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(v)
+	if err == nil && decoder.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("body must contain a single JSON object")
+	}
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, data.CodeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err), nil)
+		return false
+	}
+
+	return true
+}
+
+// validate writes the field errors of the request, if there are any
+func validate(rw http.ResponseWriter, request interface{ Validate() data.ValidationErrors }) bool {
+	fieldErrors := request.Validate()
+	if len(fieldErrors) > 0 {
+		writeError(rw, http.StatusUnprocessableEntity, data.CodeValidationFailed, "Invalid request body", fieldErrors)
+		return false
+	}
+	return true
+}
+
+// writeCurrencyError writes the field error of an amount whose currency differs from
+// the currency of what it is paid for or into, e.g. "the car"
+func writeCurrencyError(rw http.ResponseWriter, field string, currency string, of string) {
+	writeError(rw, http.StatusUnprocessableEntity, data.CodeValidationFailed, "Invalid request body", data.ValidationErrors{
+		{Field: field, Message: fmt.Sprintf("must be %s, the currency of %s", currency, of)},
+	})
+}
+
+// writeJSON writes v as the JSON body of the response
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(v)
+}
+
+// Deprecated marks a route that is kept only for existing clients. The successor is
+// announced in the Link header.
+func Deprecated(successor string, next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Deprecation", "true")
+		rw.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		next(rw, r)
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"girhub.com/fist/chaincode/data"
+	"github.com/gorilla/mux"
+)
+
+// QuoteMalfunction sets the repair price of the reported malfunction of the route and
+// returns the car, which is scrapped when the repairs exceed its price
+func (c *Cars) QuoteMalfunction(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	carId := vars["id"]
+	malfunctionId := vars["malfunction"]
+
+	request := data.QuoteRequest{}
+	if !decodeJSON(rw, r, &request) || !validate(rw, &request) {
+		return
+	}
+
+	c.l.Println("Handle POST car malfunction quote")
+
+	car, err := c.queryCar(contract, carId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+	if request.RepairPrice.Currency != car.Price.Currency {
+		writeCurrencyError(rw, "RepairPrice.Currency", car.Price.Currency, "the car")
+		return
+	}
+
+	_, err = contract.SubmitTransaction("QuoteMalfunction", carId, malfunctionId, strconv.FormatInt(request.RepairPrice.Amount, 10))
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	c.writeCar(contract, rw, http.StatusOK, carId)
+}
+
+// RepairMalfunctions orders the repair of the quoted malfunctions of the JSON body, which
+// the owner pays for one by one, and returns the car
+func (c *Cars) RepairMalfunctions(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	carId := mux.Vars(r)["id"]
+
+	request := data.RepairRequest{}
+	if !decodeJSON(rw, r, &request) || !validate(rw, &request) {
+		return
+	}
+
+	c.l.Println("Handle POST car repairs")
+
+	malfunctionIds, err := json.Marshal(request.MalfunctionIds)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to encode the malfunctions", nil)
+		return
+	}
+
+	_, err = c.submitOnOwnPeers(r, contract, "RepairMalfunctions", carId, string(malfunctionIds))
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	c.writeCar(contract, rw, http.StatusOK, carId)
+}
+
+// CompleteRepair marks the malfunction of the route as fixed and returns the car
+func (c *Cars) CompleteRepair(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	carId := vars["id"]
+	malfunctionId := vars["malfunction"]
+
+	c.l.Println("Handle POST car malfunction complete")
+
+	_, err := contract.SubmitTransaction("CompleteRepair", carId, malfunctionId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	c.writeCar(contract, rw, http.StatusOK, carId)
+}
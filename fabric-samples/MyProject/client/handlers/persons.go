@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"girhub.com/fist/chaincode/data"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+)
+
+// GetPayments returns the payments owed to the person that have not been collected yet.
+// Only the peers of the caller's organisation evaluate it.
+func (c *Cars) GetPayments(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	personId := mux.Vars(r)["id"]
+	if !mayActFor(r, personId) {
+		writeError(rw, http.StatusForbidden, data.CodeForbidden, "Only "+personId+" and admins may read the payments", nil)
+		return
+	}
+
+	c.l.Println("Handle GET person payments")
+
+	result, err := c.evaluateOnOwnPeers(r, contract, "QueryPayments", personId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	payments := []*data.Payment{}
+	err = json.Unmarshal(result, &payments)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to unmarshal json", nil)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, payments)
+}
+
+// CollectPayments adds the payments owed to the person to the person's balance and returns
+// the person. Only the peers of the caller's organisation can credit the balance.
+func (c *Cars) CollectPayments(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	personId := mux.Vars(r)["id"]
+	if !actsFor(rw, r, personId) {
+		return
+	}
+
+	c.l.Println("Handle POST person payments collect")
+
+	_, err := c.submitOnOwnPeers(r, contract, "CollectPayments", personId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	result, err := c.evaluateOnOwnPeers(r, contract, "QueryPerson", personId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	person := &data.Person{}
+	err = json.Unmarshal(result, person)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to unmarshal json", nil)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, person)
+}
+
+// Deposit pays the amount of the JSON body into the balance of the person and returns the person
+func (c *Cars) Deposit(rw http.ResponseWriter, r *http.Request) {
+	c.moveMoney(rw, r, "Deposit")
+}
+
+// Withdraw pays the amount of the JSON body out of the balance of the person and returns the person
+func (c *Cars) Withdraw(rw http.ResponseWriter, r *http.Request) {
+	c.moveMoney(rw, r, "Withdraw")
+}
+
+// GetStatement returns the journal entries of the person between the RFC 3339 timestamps of
+// the query parameters from and to. Without them the statement covers the whole journal.
+// Only the peers of the caller's organisation hold the journal and evaluate it.
+func (c *Cars) GetStatement(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	personId := mux.Vars(r)["id"]
+	if !actsFor(rw, r, personId) {
+		return
+	}
+
+	query := r.URL.Query()
+	from := query.Get("from")
+	if from == "" {
+		from = time.Unix(0, 0).UTC().Format(time.RFC3339)
+	}
+	to := query.Get("to")
+	if to == "" {
+		to = time.Now().UTC().Add(time.Minute).Format(time.RFC3339)
+	}
+
+	c.l.Println("Handle GET person statement")
+
+	result, err := c.evaluateOnOwnPeers(r, contract, "GetStatement", personId, from, to)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	statement := data.Statement{}
+	err = json.Unmarshal(result, &statement)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to unmarshal json", nil)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, statement)
+}
+
+// GetReconciliation checks that the journal of the person sums to the person's balance and
+// that every transaction of the person balances. Only the peers of the caller's organisation
+// evaluate it.
+func (c *Cars) GetReconciliation(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	personId := mux.Vars(r)["id"]
+	if !actsFor(rw, r, personId) {
+		return
+	}
+
+	c.l.Println("Handle GET person reconciliation")
+
+	result, err := c.evaluateOnOwnPeers(r, contract, "ReconcileBalance", personId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	reconciliation := data.Reconciliation{}
+	err = json.Unmarshal(result, &reconciliation)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to unmarshal json", nil)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, reconciliation)
+}
+
+// GetAccountBalances returns the balances of the external accounts journaled by the caller's
+// organisation. Only the peers of the caller's organisation hold the journal and evaluate it.
+func (c *Cars) GetAccountBalances(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	c.l.Println("Handle GET account balances")
+
+	result, err := c.evaluateOnOwnPeers(r, contract, "QueryAccountBalances")
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	balances := []*data.AccountBalance{}
+	err = json.Unmarshal(result, &balances)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to unmarshal json", nil)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, balances)
+}
+
+// moveMoney submits the named transaction with the amount of the JSON body and returns the person
+func (c *Cars) moveMoney(rw http.ResponseWriter, r *http.Request, name string) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	personId := mux.Vars(r)["id"]
+	if !actsFor(rw, r, personId) {
+		return
+	}
+
+	request := data.MoneyRequest{}
+	if !decodeJSON(rw, r, &request) || !validate(rw, &request) {
+		return
+	}
+
+	c.l.Println("Handle POST person " + name)
+
+	person, err := c.queryPerson(r, contract, personId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+	if request.Amount.Currency != person.Money.Currency {
+		writeCurrencyError(rw, "Amount.Currency", person.Money.Currency, "the balance")
+		return
+	}
+
+	_, err = c.submitOnOwnPeers(r, contract, name, personId, strconv.FormatInt(request.Amount.Amount, 10))
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	person, err = c.queryPerson(r, contract, personId)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to read the person", nil)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, person)
+}
+
+// queryPerson reads the person including the details, which only the peers of the caller's
+// organisation may read
+func (c *Cars) queryPerson(r *http.Request, contract *gateway.Contract, personId string) (*data.Person, error) {
+	result, err := c.evaluateOnOwnPeers(r, contract, "QueryPerson", personId)
+	if err != nil {
+		return nil, err
+	}
+
+	person := &data.Person{}
+	err = json.Unmarshal(result, person)
+	if err != nil {
+		return nil, err
+	}
+	return person, nil
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"girhub.com/fist/chaincode/auth"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+	"github.com/stretchr/testify/require"
+)
+
+// testContracts provides a contract for every identity and the peers of its organisation
+type testContracts map[string][]string
+
+func (p testContracts) Contract(label string) (*gateway.Contract, error) {
+	return &gateway.Contract{}, nil
+}
+
+func (p testContracts) Peers(label string) ([]string, error) {
+	return p[label], nil
+}
+
+// endorsement is a transaction evaluated by the test handler
+type endorsement struct {
+	peers []string
+	name  string
+	args  []string
+}
+
+// newTestCars returns a handler whose evaluated transactions are recorded and return the result
+func newTestCars(result string) (*Cars, *[]endorsement) {
+	endorsements := []endorsement{}
+	cars := NewCars(log.New(ioutil.Discard, "", 0), testContracts{"": {"peer0.org4.example.com"}, "org1User": {"peer0.org1.example.com"}})
+	cars.evaluate = func(contract *gateway.Contract, peers []string, name string, args ...string) ([]byte, error) {
+		endorsements = append(endorsements, endorsement{peers, name, args})
+		return []byte(result), nil
+	}
+	return cars, &endorsements
+}
+
+// personRequest returns a request of the caller, transacting as the identity, for the person
+func personRequest(claims *auth.Claims, identity string, personId string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/persons/"+personId, nil)
+	ctx := context.WithValue(r.Context(), claimsKey{}, claims)
+	if identity != "" {
+		ctx = WithIdentity(ctx, identity)
+	}
+	return mux.SetURLVars(r.WithContext(ctx), map[string]string{"id": personId})
+}
+
+func TestGetPersonEvaluatesOnOwnPeers(t *testing.T) {
+	cars, endorsements := newTestCars(`{"Id":"person1","Name":"Jean-Jacques","Money":{"Amount":100,"Currency":"EUR"},"MSPID":"Org1MSP"}`)
+
+	rw := httptest.NewRecorder()
+	cars.GetPerson(rw, personRequest(&auth.Claims{Subject: "person1", Roles: []string{auth.RoleOwner}}, "org1User", "person1"))
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.Contains(t, rw.Body.String(), "Jean-Jacques")
+	require.Equal(t, []endorsement{{[]string{"peer0.org1.example.com"}, "QueryPerson", []string{"person1"}}}, *endorsements)
+
+	// the default identity is endorsed by the peers of its own organisation
+	rw = httptest.NewRecorder()
+	cars.GetPerson(rw, personRequest(&auth.Claims{Subject: "admin", Roles: []string{auth.RoleAdmin}}, "", "person1"))
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.Equal(t, []string{"peer0.org4.example.com"}, (*endorsements)[1].peers)
+}
+
+func TestGetPaymentsEvaluatesOnOwnPeers(t *testing.T) {
+	cars, endorsements := newTestCars(`[{"PayeeId":"person1","Debit":"@escrow","Amount":{"Amount":15000,"Currency":"EUR"},"Memo":"refund of the offer for car1"}]`)
+
+	rw := httptest.NewRecorder()
+	cars.GetPayments(rw, personRequest(&auth.Claims{Subject: "person2", Roles: []string{auth.RoleOwner}}, "org1User", "person1"))
+	require.Equal(t, http.StatusForbidden, rw.Code)
+	require.Empty(t, *endorsements)
+
+	rw = httptest.NewRecorder()
+	cars.GetPayments(rw, personRequest(&auth.Claims{Subject: "person1", Roles: []string{auth.RoleOwner}}, "org1User", "person1"))
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.Contains(t, rw.Body.String(), "refund of the offer for car1")
+	require.Equal(t, []endorsement{{[]string{"peer0.org1.example.com"}, "QueryPayments", []string{"person1"}}}, *endorsements)
+}
+
+func TestGetStatementEvaluatesOnOwnPeers(t *testing.T) {
+	cars, endorsements := newTestCars(`{"PersonId":"person1","Entries":[{"TxId":"tx3","Account":"person1","Side":"debit","Contra":"@cash","Amount":{"Amount":500,"Currency":"EUR"},"Memo":"withdrawal"}]}`)
+
+	r := personRequest(&auth.Claims{Subject: "person1", Roles: []string{auth.RoleOwner}}, "org1User", "person1")
+	r.URL.RawQuery = "from=2023-01-01T00:00:00Z&to=2024-01-01T00:00:00Z"
+	rw := httptest.NewRecorder()
+	cars.GetStatement(rw, r)
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.Contains(t, rw.Body.String(), "withdrawal")
+	require.Equal(t, []endorsement{{[]string{"peer0.org1.example.com"}, "GetStatement", []string{"person1", "2023-01-01T00:00:00Z", "2024-01-01T00:00:00Z"}}}, *endorsements)
+
+	cars, endorsements = newTestCars(`{"PersonId":"person1","Entries":4,"Unbalanced":[],"Reconciled":true}`)
+	rw = httptest.NewRecorder()
+	cars.GetReconciliation(rw, personRequest(&auth.Claims{Subject: "person1", Roles: []string{auth.RoleOwner}}, "org1User", "person1"))
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.Equal(t, []endorsement{{[]string{"peer0.org1.example.com"}, "ReconcileBalance", []string{"person1"}}}, *endorsements)
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"girhub.com/fist/chaincode/data"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+)
+
+// GetSale returns the listing of the car and the offers made for it
+func (c *Cars) GetSale(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	carId := mux.Vars(r)["id"]
+
+	c.l.Println("Handle GET car sale")
+
+	sale, err := c.querySale(contract, carId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, sale)
+}
+
+// ListCar lists the car for sale at the asking price of the JSON body, or changes the
+// asking price of a listed car, and returns the sale
+func (c *Cars) ListCar(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	carId := mux.Vars(r)["id"]
+
+	request := data.ListCarRequest{}
+	if !decodeJSON(rw, r, &request) || !validate(rw, &request) {
+		return
+	}
+
+	c.l.Println("Handle PUT car sale")
+
+	car, err := c.queryCar(contract, carId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+	if request.AskingPrice.Currency != car.Price.Currency {
+		writeCurrencyError(rw, "AskingPrice.Currency", car.Price.Currency, "the car")
+		return
+	}
+
+	_, err = contract.SubmitTransaction("ListCarForSale", carId, strconv.FormatInt(request.AskingPrice.Amount, 10))
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	c.writeSale(contract, rw, http.StatusOK, carId)
+}
+
+// CancelSale withdraws the car from sale together with all offers
+func (c *Cars) CancelSale(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	carId := mux.Vars(r)["id"]
+
+	c.l.Println("Handle DELETE car sale")
+
+	_, err := contract.SubmitTransaction("CancelSale", carId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// MakeOffer places the offer of the JSON body for the listed car and returns the sale.
+// Callers other than admins may only make offers for themselves. The peers of the caller's
+// organisation escrow the price from the buyer's balance.
+func (c *Cars) MakeOffer(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	carId := mux.Vars(r)["id"]
+
+	request := data.OfferRequest{}
+	if !decodeJSON(rw, r, &request) || !validate(rw, &request) {
+		return
+	}
+	if !actsFor(rw, r, request.BuyerId) {
+		return
+	}
+
+	c.l.Println("Handle POST car sale offer")
+
+	sale, err := c.querySale(contract, carId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+	if request.Price.Currency != sale.Listing.AskingPrice.Currency {
+		writeCurrencyError(rw, "Price.Currency", sale.Listing.AskingPrice.Currency, "the car")
+		return
+	}
+
+	_, err = c.submitOnOwnPeers(r, contract, "MakeOffer", carId, request.BuyerId,
+		strconv.FormatInt(request.Price.Amount, 10), strconv.FormatInt(request.ValidForSeconds, 10))
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Location", "/cars/"+carId+"/sale")
+	c.writeSale(contract, rw, http.StatusCreated, carId)
+}
+
+// CancelOffer withdraws the offer of the buyer of the route and refunds its escrow
+func (c *Cars) CancelOffer(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	carId := vars["id"]
+	buyerId := vars["buyer"]
+	if !actsFor(rw, r, buyerId) {
+		return
+	}
+
+	c.l.Println("Handle DELETE car sale offer")
+
+	_, err := c.submitOnOwnPeers(r, contract, "CancelOffer", carId, buyerId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptOffer transfers the car to the buyer of the route, whose offer must match the
+// asking price, and returns the car
+func (c *Cars) AcceptOffer(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	carId := vars["id"]
+	buyerId := vars["buyer"]
+
+	c.l.Println("Handle POST car sale offer accept")
+
+	_, err := c.submitOnOwnPeers(r, contract, "TransferCar", carId, buyerId)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	c.writeCar(contract, rw, http.StatusOK, carId)
+}
+
+// querySale reads the listing of the car and its offers
+func (c *Cars) querySale(contract *gateway.Contract, carId string) (*data.Sale, error) {
+	result, err := contract.EvaluateTransaction("QuerySale", carId)
+	if err != nil {
+		return nil, err
+	}
+
+	sale := &data.Sale{}
+	err = json.Unmarshal(result, sale)
+	if err != nil {
+		return nil, err
+	}
+	return sale, nil
+}
+
+// writeSale responds with the current state of the sale after a successful transaction
+func (c *Cars) writeSale(contract *gateway.Contract, rw http.ResponseWriter, status int, carId string) {
+	sale, err := c.querySale(contract, carId)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to read the sale", nil)
+		return
+	}
+
+	writeJSON(rw, status, sale)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"girhub.com/fist/chaincode/data"
+	"github.com/gorilla/mux"
+)
+
+// GetTokenSettlement returns the token chaincode that persons linked to a token account pay with
+func (c *Cars) GetTokenSettlement(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	c.l.Println("Handle GET token settlement")
+
+	result, err := contract.EvaluateTransaction("QueryTokenSettlement")
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	settlement := data.TokenSettlement{}
+	err = json.Unmarshal(result, &settlement)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to unmarshal json", nil)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, settlement)
+}
+
+// SetTokenSettlement configures the token chaincode of the JSON body and returns it
+func (c *Cars) SetTokenSettlement(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	request := data.TokenSettlementRequest{}
+	if !decodeJSON(rw, r, &request) || !validate(rw, &request) {
+		return
+	}
+
+	c.l.Println("Handle PUT token settlement")
+
+	_, err := contract.SubmitTransaction("SetTokenSettlement", request.Chaincode, request.Currency, request.RepairAccount)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, data.TokenSettlement(request))
+}
+
+// LinkTokenAccount links the person to the token account of the JSON body and returns the
+// person. The buyer has to approve the seller's client with the token chaincode before a
+// car is transferred. Only the peers of the caller's organisation can update the person.
+func (c *Cars) LinkTokenAccount(rw http.ResponseWriter, r *http.Request) {
+
+	contract, ok := c.contract(rw, r)
+	if !ok {
+		return
+	}
+
+	personId := mux.Vars(r)["id"]
+	if !actsFor(rw, r, personId) {
+		return
+	}
+
+	request := data.TokenAccountRequest{}
+	if !decodeJSON(rw, r, &request) || !validate(rw, &request) {
+		return
+	}
+
+	c.l.Println("Handle PUT person token account")
+
+	_, err := c.submitOnOwnPeers(r, contract, "LinkTokenAccount", personId, request.TokenAccount)
+	if err != nil {
+		writeTransactionError(rw, err)
+		return
+	}
+
+	person, err := c.queryPerson(r, contract, personId)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, data.CodeInternal, "Unable to read the person", nil)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, person)
+}
@@ -3,96 +3,154 @@ package main
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"girhub.com/fist/chaincode/auth"
+	"girhub.com/fist/chaincode/config"
+	"girhub.com/fist/chaincode/gateways"
 	"girhub.com/fist/chaincode/handlers"
 	"github.com/gorilla/mux"
-	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
 	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
 )
 
 func main() {
 
-	err := os.Setenv("DISCOVERY_AS_LOCALHOST", "true")
+	cfg, err := config.Load(os.Args[1:])
 	if err != nil {
-		log.Fatalf("Error setting DISCOVERY_AS_LOCALHOST environemnt variable: %v", err)
+		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	wallet, err := gateway.NewFileSystemWallet("wallet")
+	err = os.Setenv("DISCOVERY_AS_LOCALHOST", strconv.FormatBool(cfg.DiscoveryAsLocalhost))
 	if err != nil {
-		log.Fatalf("Failed to create wallet: %v", err)
+		log.Fatalf("Error setting DISCOVERY_AS_LOCALHOST environemnt variable: %v", err)
 	}
 
-	if !wallet.Exists("appUser") {
-		err = populateWallet(wallet)
-		if err != nil {
-			log.Fatalf("Failed to populate wallet contents: %v", err)
-		}
+	pool, err := gateways.NewPool(cfg)
+	if err != nil {
+		log.Fatalf("Failed to populate wallet contents: %v", err)
 	}
+	defer pool.Close()
 
-	ccpPath := filepath.Join(
-		"..",
-		"..",
-		"test-network",
-		"organizations",
-		"peerOrganizations",
-		"org4.example.com",
-		"connection-org4.yaml",
-	)
-
-	gw, err := gateway.Connect(
-		gateway.WithConfig(config.FromFile(filepath.Clean(ccpPath))),
-		gateway.WithIdentity(wallet, "appUser"),
-	)
+	// the default identity is connected on startup, the others on their first request
+	network, err := pool.Network("")
 	if err != nil {
 		log.Fatalf("Failed to connect to gateway: %v", err)
 	}
-	defer gw.Close()
 
-	network, err := gw.GetNetwork("mychannel")
+	contract, err := pool.Contract("")
 	if err != nil {
-		log.Fatalf("Failed to get network: %v", err)
+		log.Fatalf("Failed to get contract: %v", err)
+	}
+	if cfg.InitLedger {
+		initLedger(contract)
 	}
-
-	contract := network.GetContract("basic")
-	initLedger(contract)
 	//-------------------------------------------HANDLER ---------------------------------------------------------------//
 	l := log.New(os.Stdout, "products-api ", log.LstdFlags)
-	handler := handlers.NewCars(l, contract)
+	handler := handlers.NewCars(l, pool)
+
+	feed := handlers.NewFeed(l)
+	stopFeed, err := feed.Listen(network, contract, func(fromBlock uint64) (<-chan *fab.BlockEvent, func(), error) {
+		return pool.Blocks("", fromBlock)
+	})
+	if err != nil {
+		log.Fatalf("Failed to register for events: %v", err)
+	}
+	defer stopFeed()
+	eventsHandler := handlers.NewEvents(l, feed)
+
 	sm := mux.NewRouter()
 
 	getRouter := sm.Methods(http.MethodGet).Subrouter()
+	getRouter.HandleFunc("/cars", handler.GetCars)
+	getRouter.HandleFunc("/cars/search", handler.SearchCars)
 	getRouter.HandleFunc("/cars/{id}", handler.GetCar)
 	getRouter.HandleFunc("/cars/color/{color}", handler.GetCarsByColor)
+	getRouter.HandleFunc("/cars/{id}/history", handler.GetCarHistory)
+	getRouter.HandleFunc("/cars/{id}/valuation", handler.GetCarValuation)
+	getRouter.HandleFunc("/cars/{id}/sale", handler.GetSale)
+	getRouter.HandleFunc("/cars/{id}/auction", handler.GetAuction)
 	getRouter.HandleFunc("/cars/{color}/{owner}", handler.GetCarsByColorAndOwner)
 	getRouter.HandleFunc("/persons/{id}", handler.GetPerson)
+	getRouter.HandleFunc("/persons/{id}/payments", handler.GetPayments)
+	getRouter.HandleFunc("/persons/{id}/statement", handler.GetStatement)
+	getRouter.HandleFunc("/persons/{id}/reconciliation", handler.GetReconciliation)
+	getRouter.HandleFunc("/accounts", handlers.RequireRole(auth.RoleAdmin)(handler.GetAccountBalances))
+	getRouter.HandleFunc("/settings/token", handler.GetTokenSettlement)
+
+	// every mutation is authorized before it is submitted: dealers list new cars, only the
+	// owner of a car may sell, auction, change or repair it, persons make offers and bids and
+	// move their money for themselves, only mechanics and admins report, quote and fix
+	// malfunctions and only admins configure payments in tokens
+	dealer := handlers.RequireRole(auth.RoleDealer, auth.RoleAdmin)
+	owner := handler.RequireCarOwner
+	buyer := handlers.RequireRole(auth.RoleOwner)
+	mechanic := handlers.RequireRole(auth.RoleMechanic, auth.RoleAdmin)
+	admin := handlers.RequireRole(auth.RoleAdmin)
 
 	postRouter := sm.Methods(http.MethodPost).Subrouter()
-	postRouter.HandleFunc("/cars/ownership/{car}/{owner}/{flag}", handler.TransferCarOwnership)
-	postRouter.HandleFunc("/cars/color/{car}/{color}", handler.ChangeCarColor)
-	postRouter.HandleFunc("/cars/malfunction/{car}/{description}/{repairPrice}", handler.AddCarMalfunction)
-	postRouter.HandleFunc("/cars/repair/{car}", handler.RepairCar)
+	postRouter.HandleFunc("/cars", dealer(handler.CreateCar))
+	postRouter.HandleFunc("/cars/{id}/transfers", owner(handler.TransferCar))
+	postRouter.HandleFunc("/cars/{id}/malfunctions", mechanic(handler.ReportMalfunction))
+	postRouter.HandleFunc("/cars/{id}/malfunctions/{malfunction}/quote", mechanic(handler.QuoteMalfunction))
+	postRouter.HandleFunc("/cars/{id}/malfunctions/{malfunction}/complete", mechanic(handler.CompleteRepair))
+	postRouter.HandleFunc("/cars/{id}/repairs", owner(handler.RepairMalfunctions))
+	postRouter.HandleFunc("/cars/repair/{car}", owner(handler.RepairCar))
+	postRouter.HandleFunc("/cars/{id}/sale/offers", buyer(handler.MakeOffer))
+	postRouter.HandleFunc("/cars/{id}/sale/offers/{buyer}/accept", owner(handler.AcceptOffer))
+	postRouter.HandleFunc("/persons/{id}/payments/collect", buyer(handler.CollectPayments))
+	postRouter.HandleFunc("/cars/{id}/auction", owner(handler.CreateAuction))
+	postRouter.HandleFunc("/cars/{id}/auction/bids", buyer(handler.SubmitBid))
+	postRouter.HandleFunc("/cars/{id}/auction/close", owner(handler.CloseAuction))
+	postRouter.HandleFunc("/cars/{id}/auction/bids/{bid}/reveal", buyer(handler.RevealBid))
+	postRouter.HandleFunc("/cars/{id}/auction/end", owner(handler.EndAuction))
+	postRouter.HandleFunc("/persons/{id}/deposits", handler.Deposit)
+	postRouter.HandleFunc("/persons/{id}/withdrawals", handler.Withdraw)
+
+	putRouter := sm.Methods(http.MethodPut).Subrouter()
+	putRouter.HandleFunc("/cars/{id}/sale", owner(handler.ListCar))
+	putRouter.HandleFunc("/persons/{id}/token-account", handler.LinkTokenAccount)
+	putRouter.HandleFunc("/settings/token", admin(handler.SetTokenSettlement))
+
+	patchRouter := sm.Methods(http.MethodPatch).Subrouter()
+	patchRouter.HandleFunc("/cars/{id}", owner(handler.UpdateCar))
+
+	deleteRouter := sm.Methods(http.MethodDelete).Subrouter()
+	deleteRouter.HandleFunc("/cars/{id}/sale", owner(handler.CancelSale))
+	deleteRouter.HandleFunc("/cars/{id}/sale/offers/{buyer}", buyer(handler.CancelOffer))
+
+	// path-style routes kept for existing clients
+	postRouter.HandleFunc("/cars/ownership/{car}/{owner}/{flag}", handlers.Deprecated("/cars/{id}/transfers", owner(handler.TransferCarOwnership)))
+	postRouter.HandleFunc("/cars/color/{car}/{color}", handlers.Deprecated("/cars/{id}", owner(handler.ChangeCarColor)))
+	postRouter.HandleFunc("/cars/malfunction/{car}/{description}/{repairPrice}", handlers.Deprecated("/cars/{id}/malfunctions", mechanic(handler.AddCarMalfunction)))
+
+	// the event streams stay open for as long as the client is connected, so they are
+	// served outside of the timeout that bounds every other endpoint
+	root := mux.NewRouter()
+	root.Use(handlers.IdentityFromHeader, handlers.Authenticate([]byte(cfg.JWTSecret)))
+	eventsRouter := root.Methods(http.MethodGet).Subrouter()
+	eventsRouter.HandleFunc("/events", eventsHandler.GetEvents)
+	eventsRouter.Handle("/events/ws", eventsHandler.WebSocket())
+	root.PathPrefix("/").Handler(http.TimeoutHandler(sm, 10*time.Second, "Request timed out"))
 
 	// create a new server
 	s := http.Server{
-		Addr:         ":9090",           // configure the bind address
-		Handler:      sm,                // set the default handler
-		ErrorLog:     l,                 // set the logger for the server
-		ReadTimeout:  5 * time.Second,   // max time to read request from the client
-		WriteTimeout: 10 * time.Second,  // max time to write response to the client
-		IdleTimeout:  120 * time.Second, // max time for connections using TCP Keep-Alive
+		Addr:        cfg.Address,       // configure the bind address
+		Handler:     root,              // set the default handler
+		ErrorLog:    l,                 // set the logger for the server
+		ReadTimeout: 5 * time.Second,   // max time to read request from the client
+		IdleTimeout: 120 * time.Second, // max time for connections using TCP Keep-Alive
 	}
 
 	// start the server
 	go func() {
-		l.Println("Starting server on port 9090")
+		l.Printf("Starting server on %s\n", cfg.Address)
 
 		err := s.ListenAndServe()
 		if err != nil {
@@ -111,7 +169,8 @@ func main() {
 	log.Println("Got signal:", sig)
 
 	// gracefully shutdown the server, waiting max 30 seconds for current operations to complete
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 	s.Shutdown(ctx)
 
 }
@@ -127,44 +186,3 @@ func initLedger(contract *gateway.Contract) {
 	}
 	log.Println(string(result))
 }
-
-func populateWallet(wallet *gateway.Wallet) error {
-
-	credPath := filepath.Join(
-		"..",
-		"..",
-		"test-network",
-		"organizations",
-		"peerOrganizations",
-		"org4.example.com",
-		"users",
-		"User1@org4.example.com",
-		"msp",
-	)
-
-	certPath := "../../test-network/organizations/peerOrganizations/org4.example.com/users/User1@org4.example.com/msp/signcerts/cert.pem"
-	// read the certificate pem
-	cert, err := ioutil.ReadFile(filepath.Clean(certPath))
-	if err != nil {
-		return err
-	}
-
-	keyDir := filepath.Join(credPath, "keystore")
-	// there's a single file in this dir containing the private key
-	files, err := ioutil.ReadDir(keyDir)
-	if err != nil {
-		return err
-	}
-	if len(files) != 1 {
-		return fmt.Errorf("keystore folder should have contain one file")
-	}
-	keyPath := filepath.Join(keyDir, files[0].Name())
-	key, err := ioutil.ReadFile(filepath.Clean(keyPath))
-	if err != nil {
-		return err
-	}
-
-	identity := gateway.NewX509Identity("Org4MSP", string(cert), string(key))
-
-	return wallet.Put("appUser", identity)
-}